@@ -0,0 +1,438 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/docker/go-plugins-helpers/sdk"
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/lorenzleutgeb/docker-volume-gcs/gcs"
+)
+
+// commands maps names of subcommands to their implementation. They
+// talk to an already running instance of the plugin via its socket.
+var commands = map[string]func(args []string) error{
+	"ls":      ls,
+	"inspect": inspect,
+	"drain":   drain,
+	"orphans": orphans,
+	"remount": remount,
+	"rename":  rename,
+	"options": options,
+	"config":  config,
+	"logs":    logs,
+
+	"validate-options": validateOptions,
+}
+
+type errPlugin struct {
+	method string
+	msg    string
+}
+
+func (e errPlugin) Error() string {
+	return fmt.Sprintf("plugin responded to %s with error: %s", e.method, e.msg)
+}
+
+func ls(args []string) error {
+	fs := flag.NewFlagSet("ls", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print volumes as JSON instead of a table")
+	socket := fs.String("socket", socketAddress, "socket of the running plugin")
+	fs.Parse(args)
+
+	var res volume.ListResponse
+	if err := call(*socket, "List", struct{}{}, &res); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(res.Volumes)
+	}
+	return printTable(res.Volumes)
+}
+
+func inspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print volume as JSON instead of a table")
+	socket := fs.String("socket", socketAddress, "socket of the running plugin")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("usage: docker-volume-gcs inspect [-json] [-socket PATH] NAME")
+	}
+
+	var res volume.GetResponse
+	if err := call(*socket, "Get", volume.GetRequest{Name: fs.Arg(0)}, &res); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(res.Volume)
+	}
+	return printTable([]*volume.Volume{res.Volume})
+}
+
+func drain(args []string) error {
+	fs := flag.NewFlagSet("drain", flag.ExitOnError)
+	socket := fs.String("socket", socketAddress, "socket of the running plugin")
+	fs.Parse(args)
+
+	method := http.MethodGet
+	switch fs.Arg(0) {
+	case "":
+	case "on":
+		method = http.MethodPost
+	case "off":
+		method = http.MethodDelete
+	default:
+		return errors.New("usage: docker-volume-gcs drain [-socket PATH] [on|off]")
+	}
+
+	req, err := http.NewRequest(method, "http://plugin/drain", nil)
+	if err != nil {
+		return err
+	}
+
+	r, err := client(*socket).Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(r.Body)
+		return errPlugin{method: "drain", msg: strings.TrimSpace(string(msg))}
+	}
+
+	var res gcs.DrainResponse
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+		return err
+	}
+
+	fmt.Printf("draining: %t\n", res.Draining)
+	return nil
+}
+
+func orphans(args []string) error {
+	fs := flag.NewFlagSet("orphans", flag.ExitOnError)
+	socket := fs.String("socket", socketAddress, "socket of the running plugin")
+	reap := fs.Bool("reap", false, "interrupt the orphaned processes")
+	fs.Parse(args)
+
+	method := http.MethodGet
+	if *reap {
+		method = http.MethodPost
+	}
+
+	req, err := http.NewRequest(method, "http://plugin/orphans", nil)
+	if err != nil {
+		return err
+	}
+
+	r, err := client(*socket).Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return errPlugin{method: "orphans", msg: r.Status}
+	}
+
+	var res []gcs.Orphan
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "PID\tMOUNTPOINT")
+	for _, o := range res {
+		fmt.Fprintf(w, "%d\t%s\n", o.Pid, o.Mountpoint)
+	}
+	return w.Flush()
+}
+
+func remount(args []string) error {
+	fs := flag.NewFlagSet("remount", flag.ExitOnError)
+	socket := fs.String("socket", socketAddress, "socket of the running plugin")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		return errors.New("usage: docker-volume-gcs remount [-socket PATH] NAME [KEY=VALUE ...]")
+	}
+
+	req := gcs.RemountRequest{Options: parseOptions(fs.Args()[1:])}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	r, err := client(*socket).Post("http://plugin/remount/"+fs.Arg(0), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(r.Body)
+		return errPlugin{method: "remount", msg: strings.TrimSpace(string(msg))}
+	}
+
+	var v volume.Volume
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+		return err
+	}
+	return printTable([]*volume.Volume{&v})
+}
+
+func rename(args []string) error {
+	fs := flag.NewFlagSet("rename", flag.ExitOnError)
+	socket := fs.String("socket", socketAddress, "socket of the running plugin")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return errors.New("usage: docker-volume-gcs rename [-socket PATH] NAME NEW_NAME")
+	}
+
+	body, err := json.Marshal(gcs.RenameRequest{Name: fs.Arg(1)})
+	if err != nil {
+		return err
+	}
+
+	r, err := client(*socket).Post("http://plugin/rename/"+fs.Arg(0), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(r.Body)
+		return errPlugin{method: "rename", msg: strings.TrimSpace(string(msg))}
+	}
+
+	var v volume.Volume
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+		return err
+	}
+	return printTable([]*volume.Volume{&v})
+}
+
+func options(args []string) error {
+	fs := flag.NewFlagSet("options", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print options as JSON instead of a table")
+	socket := fs.String("socket", socketAddress, "socket of the running plugin")
+	fs.Parse(args)
+
+	r, err := client(*socket).Get("http://plugin/options")
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(r.Body)
+		return errPlugin{method: "options", msg: strings.TrimSpace(string(msg))}
+	}
+
+	var specs []gcs.OptionSpec
+	if err := json.NewDecoder(r.Body).Decode(&specs); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(specs)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tTYPE\tDEFAULT\tDESCRIPTION")
+	for _, spec := range specs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", spec.Key, spec.Type, spec.Default, spec.Description)
+	}
+	return w.Flush()
+}
+
+func config(args []string) error {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	socket := fs.String("socket", socketAddress, "socket of the running plugin")
+	fs.Parse(args)
+
+	r, err := client(*socket).Get("http://plugin/config")
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return errPlugin{method: "config", msg: r.Status}
+	}
+
+	var res gcs.ConfigResponse
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+		return err
+	}
+	return printJSON(res)
+}
+
+func logs(args []string) error {
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	socket := fs.String("socket", socketAddress, "socket of the running plugin")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("usage: docker-volume-gcs logs [-socket PATH] BUCKET")
+	}
+
+	r, err := client(*socket).Get("http://plugin/logs/" + fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(r.Body)
+		return errPlugin{method: "logs", msg: strings.TrimSpace(string(msg))}
+	}
+
+	_, err = io.Copy(os.Stdout, r.Body)
+	return err
+}
+
+// validateOptions does not talk to the plugin, so that options can be
+// checked where it does not run, e.g. in CI.
+func validateOptions(args []string) error {
+	fs := flag.NewFlagSet("validate-options", flag.ExitOnError)
+	dir := fs.String("root", "/var/lib/docker/volumes/gcs", "root directory that mountpoints are shown below")
+	host := fs.Bool("host", false, "also check what options depend on against this host: users, groups, gcsfuse and the kernel")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		return errors.New("usage: docker-volume-gcs validate-options [-root PATH] [-host] NAME [KEY=VALUE ...]")
+	}
+
+	argv, errs := gcs.ValidateOptions(gcs.Config{Root: *dir}, fs.Arg(0), parseOptions(fs.Args()[1:]), *host)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return errors.New("options are invalid")
+	}
+
+	fmt.Println(strings.Join(append([]string{"gcsfuse"}, argv...), " "))
+	return nil
+}
+
+// parseOptions turns arguments like "key=value" into options of a volume.
+// Keys without a value get an empty one.
+func parseOptions(args []string) map[string]string {
+	opts := make(map[string]string)
+	for _, kv := range args {
+		i := strings.Index(kv, "=")
+		if i == -1 {
+			opts[kv] = ""
+		} else {
+			opts[kv[:i]] = kv[i+1:]
+		}
+	}
+	return opts
+}
+
+// client returns an HTTP client that connects to the plugin socket. It
+// authenticates with $GCS_ADMIN_TOKEN or $GCS_ADMIN_BASIC_AUTH, if set,
+// see -admin-token-file.
+func client(socket string) *http.Client {
+	return &http.Client{
+		Transport: authTransport{&http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}},
+	}
+}
+
+type authTransport struct {
+	http.RoundTripper
+}
+
+func (t authTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	token, basic := os.Getenv("GCS_ADMIN_TOKEN"), os.Getenv("GCS_ADMIN_BASIC_AUTH")
+	if token == "" && basic == "" {
+		return t.RoundTripper.RoundTrip(r)
+	}
+
+	r = r.Clone(r.Context())
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	} else if i := strings.Index(basic, ":"); i != -1 {
+		r.SetBasicAuth(basic[:i], basic[i+1:])
+	}
+	return t.RoundTripper.RoundTrip(r)
+}
+
+// call invokes method of the volume plugin protocol, just like Docker
+// would, and decodes the response into res.
+func call(socket, method string, req, res interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	r, err := client(socket).Post("http://plugin/VolumeDriver."+method, sdk.DefaultContentTypeV1_1, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		var e struct{ Err string }
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			return errPlugin{method: method, msg: r.Status}
+		}
+		return errPlugin{method: method, msg: e.Err}
+	}
+
+	return json.NewDecoder(r.Body).Decode(res)
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func printTable(volumes []*volume.Volume) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tMOUNTPOINT")
+	for _, v := range volumes {
+		fmt.Fprintf(w, "%s\t%s\n", v.Name, v.Mountpoint)
+	}
+	return w.Flush()
+}
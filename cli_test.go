@@ -0,0 +1,457 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// servePlugin serves h on a socket like the one of the plugin, and
+// returns its path.
+func servePlugin(t *testing.T, h http.HandlerFunc) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "gcs.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: h}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return socket
+}
+
+// respond returns a handler that records requests in got and responds
+// with status and body.
+func respond(got *[]string, status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*got = append(*got, r.Method+" "+r.URL.Path)
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+// capture returns what fn prints to stdout.
+func capture(t *testing.T, fn func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		out <- b
+	}()
+
+	err = fn()
+	os.Stdout = stdout
+	w.Close()
+	return string(<-out), err
+}
+
+func TestLs(t *testing.T) {
+	const body = `{"Volumes":[{"Name":"b","Mountpoint":"/mnt/b"},{"Name":"b/sub","Mountpoint":"/mnt/b/sub","Status":{"bucket":"b"}}]}`
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		status int
+		body   string
+		out    string
+		err    error
+	}{
+		{"table", nil, http.StatusOK, body, "NAME   MOUNTPOINT\nb      /mnt/b\nb/sub  /mnt/b/sub\n", nil},
+		{"json", []string{"-json"}, http.StatusOK, body, `[
+  {
+    "Name": "b",
+    "Mountpoint": "/mnt/b"
+  },
+  {
+    "Name": "b/sub",
+    "Mountpoint": "/mnt/b/sub",
+    "Status": {
+      "bucket": "b"
+    }
+  }
+]
+`, nil},
+		{"empty", nil, http.StatusOK, `{"Volumes":null}`, "NAME  MOUNTPOINT\n", nil},
+		{"error", nil, http.StatusInternalServerError, `{"Err":"boom"}`, "", errPlugin{method: "List", msg: "boom"}},
+		{"no JSON", nil, http.StatusBadGateway, "bad gateway", "", errPlugin{method: "List", msg: "502 Bad Gateway"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var req []string
+			socket := servePlugin(t, respond(&req, tc.status, tc.body))
+			out, err := capture(t, func() error { return ls(append([]string{"-socket", socket}, tc.args...)) })
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("got %v, want %v", err, tc.err)
+			}
+			if out != tc.out {
+				t.Errorf("printed %q, want %q", out, tc.out)
+			}
+			if want := []string{"POST /VolumeDriver.List"}; !reflect.DeepEqual(req, want) {
+				t.Errorf("requested %q, want %q", req, want)
+			}
+		})
+	}
+}
+
+func TestInspect(t *testing.T) {
+	const body = `{"Volume":{"Name":"b","Mountpoint":"/mnt/b","Status":{"references":1}}}`
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		status int
+		out    string
+		err    error
+	}{
+		{"table", []string{"b"}, http.StatusOK, "NAME  MOUNTPOINT\nb     /mnt/b\n", nil},
+		{"json", []string{"-json", "b"}, http.StatusOK, "{\n  \"Name\": \"b\",\n  \"Mountpoint\": \"/mnt/b\",\n  \"Status\": {\n    \"references\": 1\n  }\n}\n", nil},
+		{"error", []string{"b"}, http.StatusInternalServerError, "", errPlugin{method: "Get", msg: "boom"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var req []string
+			res := body
+			if tc.status != http.StatusOK {
+				res = `{"Err":"boom"}`
+			}
+			socket := servePlugin(t, respond(&req, tc.status, res))
+			out, err := capture(t, func() error { return inspect(append([]string{"-socket", socket}, tc.args...)) })
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("got %v, want %v", err, tc.err)
+			}
+			if out != tc.out {
+				t.Errorf("printed %q, want %q", out, tc.out)
+			}
+		})
+	}
+
+	if err := inspect(nil); err == nil {
+		t.Error("inspect without a name did not fail")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		status int
+		body   string
+		req    []string
+		err    error
+	}{
+		{"show", nil, http.StatusOK, `{"Draining":false}`, []string{"GET /drain"}, nil},
+		{"on", []string{"on"}, http.StatusOK, `{"Draining":true}`, []string{"POST /drain"}, nil},
+		{"off", []string{"off"}, http.StatusOK, `{"Draining":false}`, []string{"DELETE /drain"}, nil},
+		{"unauthorized", []string{"on"}, http.StatusUnauthorized, "unauthorized\n", []string{"POST /drain"}, errPlugin{method: "drain", msg: "unauthorized"}},
+		{"error", nil, http.StatusInternalServerError, "boom\n", []string{"GET /drain"}, errPlugin{method: "drain", msg: "boom"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var req []string
+			socket := servePlugin(t, respond(&req, tc.status, tc.body))
+			err := drain(append([]string{"-socket", socket}, tc.args...))
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("got %v, want %v", err, tc.err)
+			}
+			if !reflect.DeepEqual(req, tc.req) {
+				t.Errorf("requested %q, want %q", req, tc.req)
+			}
+		})
+	}
+}
+
+func TestOrphans(t *testing.T) {
+	const body = `[{"Pid":42,"Args":["gcsfuse","b","/mnt/b"],"Mountpoint":"/mnt/b"}]`
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		status int
+		body   string
+		req    []string
+		out    string
+		err    error
+	}{
+		{"list", nil, http.StatusOK, body, []string{"GET /orphans"}, "PID  MOUNTPOINT\n42   /mnt/b\n", nil},
+		{"none", nil, http.StatusOK, "[]", []string{"GET /orphans"}, "PID  MOUNTPOINT\n", nil},
+		{"reap", []string{"-reap"}, http.StatusOK, body, []string{"POST /orphans"}, "PID  MOUNTPOINT\n42   /mnt/b\n", nil},
+		{"error", nil, http.StatusInternalServerError, "boom\n", []string{"GET /orphans"}, "", errPlugin{method: "orphans", msg: "500 Internal Server Error"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var req []string
+			socket := servePlugin(t, respond(&req, tc.status, tc.body))
+			out, err := capture(t, func() error { return orphans(append([]string{"-socket", socket}, tc.args...)) })
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("got %v, want %v", err, tc.err)
+			}
+			if out != tc.out {
+				t.Errorf("printed %q, want %q", out, tc.out)
+			}
+			if !reflect.DeepEqual(req, tc.req) {
+				t.Errorf("requested %q, want %q", req, tc.req)
+			}
+		})
+	}
+}
+
+func TestRemount(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		status int
+		body   string
+		req    string
+		out    string
+		err    error
+	}{
+		{"options", []string{"b", "access=ro", "comment=hi"}, http.StatusOK, `{"Name":"b","Mountpoint":"/mnt/b"}`, `{"Options":{"access":"ro","comment":"hi"}}`, "NAME  MOUNTPOINT\nb     /mnt/b\n", nil},
+		{"no options", []string{"b"}, http.StatusOK, `{"Name":"b","Mountpoint":"/mnt/b"}`, `{"Options":{}}`, "NAME  MOUNTPOINT\nb     /mnt/b\n", nil},
+		{"conflict", []string{"b", "access=ro"}, http.StatusConflict, "refusing\n", `{"Options":{"access":"ro"}}`, "", errPlugin{method: "remount", msg: "refusing"}},
+		{"usage", nil, 0, "", "", "", errors.New("usage: docker-volume-gcs remount [-socket PATH] NAME [KEY=VALUE ...]")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var req []string
+			socket := servePlugin(t, func(w http.ResponseWriter, r *http.Request) {
+				b, _ := ioutil.ReadAll(r.Body)
+				req = append(req, r.Method+" "+r.URL.Path+" "+strings.TrimSpace(string(b)))
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			})
+			out, err := capture(t, func() error { return remount(append([]string{"-socket", socket}, tc.args...)) })
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("got %v, want %v", err, tc.err)
+			}
+			if out != tc.out {
+				t.Errorf("printed %q, want %q", out, tc.out)
+			}
+			var want []string
+			if tc.req != "" {
+				want = []string{"POST /remount/" + tc.args[0] + " " + tc.req}
+			}
+			if !reflect.DeepEqual(req, want) {
+				t.Errorf("requested %q, want %q", req, want)
+			}
+		})
+	}
+}
+
+func TestOptions(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		status int
+		body   string
+		err    error
+	}{
+		{"table", nil, http.StatusOK, `[{"Key":"access","Type":"ro|rw","Default":"rw","Description":"access mode"}]`, nil},
+		{"json", []string{"-json"}, http.StatusOK, `[]`, nil},
+		{"unauthorized", nil, http.StatusUnauthorized, "unauthorized\n", errPlugin{method: "options", msg: "unauthorized"}},
+		{"error", nil, http.StatusInternalServerError, "boom", errPlugin{method: "options", msg: "boom"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var req []string
+			socket := servePlugin(t, respond(&req, tc.status, tc.body))
+			err := options(append([]string{"-socket", socket}, tc.args...))
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("got %v, want %v", err, tc.err)
+			}
+			if want := []string{"GET /options"}; !reflect.DeepEqual(req, want) {
+				t.Errorf("requested %q, want %q", req, want)
+			}
+		})
+	}
+}
+
+func TestConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		status int
+		body   string
+		out    string
+		err    error
+	}{
+		{"config", http.StatusOK, `{"Config":{"Relaunch":true},"Args":["--implicit-dirs"],"Unmount":["fusermount","-u"],"LogLevel":"info"}`,
+			"{\n  \"Config\": {\n    \"Relaunch\": true\n  },\n  \"Args\": [\n    \"--implicit-dirs\"\n  ],\n  \"Unmount\": [\n    \"fusermount\",\n    \"-u\"\n  ],\n  \"LogLevel\": \"info\"\n}\n", nil},
+		{"error", http.StatusMethodNotAllowed, "method not allowed\n", "", errPlugin{method: "config", msg: "405 Method Not Allowed"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var req []string
+			socket := servePlugin(t, respond(&req, tc.status, tc.body))
+			out, err := capture(t, func() error { return config([]string{"-socket", socket}) })
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("got %v, want %v", err, tc.err)
+			}
+			if out != tc.out {
+				t.Errorf("printed %q, want %q", out, tc.out)
+			}
+			if want := []string{"GET /config"}; !reflect.DeepEqual(req, want) {
+				t.Errorf("requested %q, want %q", req, want)
+			}
+		})
+	}
+}
+
+func TestLogs(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		status int
+		body   string
+		req    []string
+		out    string
+		err    error
+	}{
+		{"logs", []string{"b"}, http.StatusOK, "starting\nmounted\n", []string{"GET /logs/b"}, "starting\nmounted\n", nil},
+		{"not started", []string{"b"}, http.StatusNotFound, "gcsfuse was not started for this bucket\n", []string{"GET /logs/b"}, "", errPlugin{method: "logs", msg: "gcsfuse was not started for this bucket"}},
+		{"usage", nil, 0, "", nil, "", errors.New("usage: docker-volume-gcs logs [-socket PATH] BUCKET")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var req []string
+			socket := servePlugin(t, respond(&req, tc.status, tc.body))
+			out, err := capture(t, func() error { return logs(append([]string{"-socket", socket}, tc.args...)) })
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("got %v, want %v", err, tc.err)
+			}
+			if out != tc.out {
+				t.Errorf("printed %q, want %q", out, tc.out)
+			}
+			if !reflect.DeepEqual(req, tc.req) {
+				t.Errorf("requested %q, want %q", req, tc.req)
+			}
+		})
+	}
+}
+
+func TestAuthTransport(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		token, basic string
+		header       string
+	}{
+		{"none", "", "", ""},
+		{"token", "t", "", "Bearer t"},
+		{"basic", "", "u:p", "Basic dTpw"},
+		{"token wins", "t", "u:p", "Bearer t"},
+		{"malformed basic", "", "up", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("GCS_ADMIN_TOKEN", tc.token)
+			t.Setenv("GCS_ADMIN_BASIC_AUTH", tc.basic)
+			var header string
+			socket := servePlugin(t, func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header.Get("Authorization")
+			})
+			r, err := client(socket).Get("http://plugin/config")
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Body.Close()
+			if header != tc.header {
+				t.Errorf("sent %q, want %q", header, tc.header)
+			}
+		})
+	}
+}
+
+func TestRename(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		status int
+		body   string
+		req    []string
+		out    string
+		err    error
+	}{
+		{"renamed", []string{"b/old", "b/new"}, http.StatusOK, `{"Name":"b/new","Mountpoint":"/mnt/b/new"}`, []string{`POST /rename/b/old {"Name":"b/new"}`}, "NAME   MOUNTPOINT\nb/new  /mnt/b/new\n", nil},
+		{"conflict", []string{"b/old", "b/new"}, http.StatusConflict, "exists\n", []string{`POST /rename/b/old {"Name":"b/new"}`}, "", errPlugin{method: "rename", msg: "exists"}},
+		{"usage", []string{"b/old"}, 0, "", nil, "", errors.New("usage: docker-volume-gcs rename [-socket PATH] NAME NEW_NAME")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var req []string
+			socket := servePlugin(t, func(w http.ResponseWriter, r *http.Request) {
+				b, _ := ioutil.ReadAll(r.Body)
+				req = append(req, r.Method+" "+r.URL.Path+" "+strings.TrimSpace(string(b)))
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			})
+			out, err := capture(t, func() error { return rename(append([]string{"-socket", socket}, tc.args...)) })
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("got %v, want %v", err, tc.err)
+			}
+			if out != tc.out {
+				t.Errorf("printed %q, want %q", out, tc.out)
+			}
+			if !reflect.DeepEqual(req, tc.req) {
+				t.Errorf("requested %q, want %q", req, tc.req)
+			}
+		})
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want map[string]string
+	}{
+		{nil, map[string]string{}},
+		{[]string{"access=ro"}, map[string]string{"access": "ro"}},
+		{[]string{"nonempty", "access=ro"}, map[string]string{"nonempty": "", "access": "ro"}},
+		{[]string{"access=", "comment=a=b"}, map[string]string{"access": "", "comment": "a=b"}},
+		{[]string{"access=ro", "access=rw"}, map[string]string{"access": "rw"}},
+	} {
+		if got := parseOptions(tc.args); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseOptions(%q) = %v, want %v", tc.args, got, tc.want)
+		}
+	}
+}
+
+// validate-options works without a plugin to talk to.
+func TestValidateOptions(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		args []string
+		out  string
+		err  string
+	}{
+		{"defaults", []string{"b"}, "gcsfuse --foreground -o subtype=gcsfuse,rw b /var/lib/docker/volumes/gcs/b\n", ""},
+		{"root", []string{"-root", "/mnt", "b", "access=ro"}, "gcsfuse --foreground -o subtype=gcsfuse,ro b /mnt/b\n", ""},
+		{"invalid", []string{"b", "access=rx"}, "", "options are invalid"},
+		{"unknown", []string{"b", "nope"}, "", "options are invalid"},
+		{"no volume", nil, "", "usage: docker-volume-gcs validate-options [-root PATH] [-host] NAME [KEY=VALUE ...]"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := capture(t, func() error { return validateOptions(tc.args) })
+			if msg := fmt.Sprint(err); err != nil && msg != tc.err || err == nil && tc.err != "" {
+				t.Errorf("got %v, want %s", err, tc.err)
+			}
+			if out != tc.out {
+				t.Errorf("printed %q, want %q", out, tc.out)
+			}
+		})
+	}
+}
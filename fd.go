@@ -0,0 +1,45 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"flag"
+	"log"
+	"syscall"
+)
+
+var raiseFDLimit = flag.Bool("raise-fd-limit", false, "raise the soft limit on open files to the hard limit at startup")
+
+// raiseFDs raises the soft limit on open files to the hard limit.
+func raiseFDs() {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		log.Printf("Getting limit on open files failed: %s", err)
+		return
+	}
+	if rl.Cur == rl.Max {
+		return
+	}
+
+	old := rl.Cur
+	rl.Cur = rl.Max
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		log.Printf("Raising limit on open files failed: %s", err)
+		return
+	}
+	log.Printf("Raised limit on open files from %d to %d", old, rl.Cur)
+}
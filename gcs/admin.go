@@ -0,0 +1,483 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// The administrative endpoints below are served on the plugin socket,
+// next to the volume plugin protocol.
+
+var drainMode = newGauge("gcs_draining", "Whether the driver refuses to mount further buckets.")
+
+// DrainResponse is the body of responses to /drain.
+type DrainResponse struct {
+	Draining bool
+}
+
+// serveDrain reports (GET), enables (POST) or disables (DELETE) drain
+// mode, in which buckets that are not mounted yet are not mounted.
+func (d Driver) serveDrain(w http.ResponseWriter, r *http.Request) {
+	d.Lock()
+	defer d.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		d.setDraining(true)
+	case http.MethodDelete:
+		d.setDraining(false)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DrainResponse{Draining: *d.draining})
+}
+
+// Orphan is an instance of gcsfuse that the driver does not know about, as
+// listed by /orphans.
+type Orphan struct {
+	Pid        int
+	Args       []string
+	Mountpoint string
+
+	// Decoded from the comment of the mount, if it was mounted with the
+	// option metadata.
+	Metadata map[string]string
+}
+
+// serveOrphans reports (GET) or interrupts (POST) instances of gcsfuse
+// that mount below root, but are not known to the driver. Such orphans
+// are left behind when the driver crashes.
+func (d Driver) serveOrphans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	orphans, err := d.orphans()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		for _, o := range orphans {
+			infof("Interrupting orphaned gcsfuse %d for %s", o.Pid, o.Mountpoint)
+			if p, err := os.FindProcess(o.Pid); err == nil {
+				p.Signal(d.teardown)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(orphans)
+}
+
+func (d Driver) orphans() ([]Orphan, error) {
+	ps, err := procs.list()
+	if err != nil {
+		return nil, err
+	}
+
+	d.Lock()
+	known := make(map[int]bool)
+	for _, m := range d.cmds {
+		if m.proc != nil {
+			known[m.proc.pid()] = true
+		}
+	}
+	d.Unlock()
+
+	orphans := []Orphan{}
+	for _, p := range ps {
+		if known[p.pid] || len(p.args) < 3 || filepath.Base(p.args[0]) != "gcsfuse" {
+			continue
+		}
+
+		// The mountpoint is the last argument.
+		mnt := p.args[len(p.args)-1]
+		if rel, err := filepath.Rel(d.cfg.Root, mnt); err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		o := Orphan{Pid: p.pid, Args: p.args, Mountpoint: mnt}
+		if c, ok := mountOption(p.args, "comment"); ok {
+			o.Metadata, _ = decodeMetadata(c)
+		}
+		orphans = append(orphans, o)
+	}
+	return orphans, nil
+}
+
+// RemountRequest is the body of requests to /remount/<name>.
+type RemountRequest struct {
+	Options map[string]string
+}
+
+// serveRemount replaces the options of a volume, and restarts gcsfuse for
+// its bucket with them if it is mounted (POST /remount/<name>). Containers
+// that use the bucket keep referencing it.
+func (d Driver) serveRemount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/remount/")
+
+	var req RemountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := d.remount(name, req.Options); err != nil {
+		code := http.StatusInternalServerError
+		switch err.(type) {
+		case errUnknownOption, errBadOption:
+			code = http.StatusBadRequest
+		}
+		switch err {
+		case errNoSuchVolume:
+			code = http.StatusNotFound
+		case errUnsafeRemount, errOnlyDirRemount:
+			code = http.StatusConflict
+		case errShutdown, errDraining:
+			code = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	res, err := d.Get(&volume.GetRequest{Name: name})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res.Volume)
+}
+
+func (d Driver) remount(name string, opts map[string]string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	name = normalize(name)
+
+	if _, ok := d.opts[name]; !ok {
+		return errNoSuchVolume
+	}
+
+	b := d.key(name, d.opts[name])
+	if d.key(name, opts) != b {
+		return errOnlyDirRemount
+	}
+
+	eff, err := d.options(d.bucket(d.resolve(name)), opts)
+	if err != nil {
+		return err
+	}
+	c, err := d.command(b, eff)
+	if err != nil {
+		return err
+	}
+	access, err := accessMode(eff)
+	if err != nil {
+		return err
+	}
+	if err := d.checkExport(eff); err != nil {
+		return err
+	}
+
+	m, ok := d.awaitStop(b)
+	if ok {
+		d.await(m)
+	}
+	if !ok || m.err != nil {
+		// Not mounted, the options are used next time.
+		if err := d.persist(name, opts); err != nil {
+			return err
+		}
+		d.opts[name] = opts
+		return nil
+	}
+
+	// Containers would suddenly be unable to write, or be able to.
+	if access != m.access && len(m.refs) > 0 {
+		return errUnsafeRemount
+	}
+
+	// Like Mount, do not start gcsfuse while mounts are torn down, it
+	// would be left running.
+	if *d.stopping {
+		return errShutdown
+	}
+	if *d.draining {
+		return errDraining
+	}
+
+	if err := d.persist(name, opts); err != nil {
+		return err
+	}
+	d.opts[name] = opts
+
+	if h := optionsHash(eff); h != m.hash {
+		infof("Remounting %s %s, options changed (%s, was %s)", b, access, h, m.hash)
+	} else {
+		infof("Remounting %s %s, options did not change (%s)", b, access, h)
+	}
+
+	old, export := m.proc, m.export
+	m.proc, m.export, m.ready = nil, "", make(chan struct{})
+	out := d.logBuffer(b)
+
+	d.Unlock()
+	if err := d.preUnmount(d.bucket(b), d.target(b)); err != nil {
+		warnf("Pre-unmount hook for remount of %s failed: %s", b, err)
+	}
+	if export != "" {
+		d.unexport(d.bucket(b), d.target(b))
+	}
+	if err := d.interrupt(b, old); err != nil {
+		errorf("Stopping gcsfuse %s for remount failed: %s", b, err)
+	}
+	if err := d.release(d.target(b)); err != nil {
+		errorf("Unmounting %s for remount failed: %s", b, err)
+	}
+	d.slots <- struct{}{}
+	proc, err := d.start(c, out, nil)
+	<-d.slots
+	var exported string
+	if err == nil && enabled(eff, "nfs_export") {
+		exported, err = d.export(d.bucket(b), d.target(b))
+	}
+	d.Lock()
+
+	m.proc, m.err, m.export = proc, err, exported
+	close(m.ready)
+	if err == nil && *d.stopping {
+		// Shutdown waited for this, and stops gcsfuse.
+		return errShutdown
+	}
+	bucketAccess.delete("bucket", b, "access", m.access)
+	if m.err != nil {
+		mountErrors.add(1, "bucket", b, "reason", failure(m.err))
+		// Like stop, do not hold the lock while gcsfuse is torn down.
+		// Mounts of the bucket wait for it, see awaitStop. Nothing is
+		// exported anymore, that was undone before stopping the old one.
+		m.stopped = make(chan struct{})
+		d.Unlock()
+		d.discard(b, d.target(b), proc)
+		d.Lock()
+		close(m.stopped)
+		m.stopped = nil
+		delete(d.cmds, b)
+		bucketRefs.delete("bucket", b)
+		return m.err
+	}
+
+	m.access, m.cmd, m.limits, m.failed, m.hash, m.cache, m.degraded = access, c, usageLimits(eff), "", optionsHash(eff), eff["cache_dir"], ""
+	bucketAccess.set(1, "bucket", b, "access", access)
+	go d.supervise(b, m, proc)
+	return nil
+}
+
+// ConfigResponse is the body of responses to /config.
+type ConfigResponse struct {
+	// Fields of Config by name, after defaults were applied. Durations
+	// are rendered like "30s", and secrets are redacted.
+	Config map[string]interface{}
+
+	// Arguments that all instances of gcsfuse get, before the options of
+	// their volume, redacted.
+	Args []string
+
+	// Command that unmounts leftover mountpoints, see Config.UnmountTool.
+	Unmount []string
+
+	// See SetLogLevel.
+	LogLevel string
+}
+
+// serveConfig reports the configuration that the driver actually uses
+// (GET /config), so that precedence of flags and defaults can be checked.
+func (d Driver) serveConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	res := ConfigResponse{
+		Config:   make(map[string]interface{}),
+		Args:     redact(d.globalFlags().args()),
+		Unmount:  d.unmount,
+		LogLevel: logLevel.String(),
+	}
+
+	c := *d.cfg
+	c.GcsfuseArgs = redact(c.GcsfuseArgs)
+	c.EventWebhook = redactURL(c.EventWebhook)
+	c.TraceEndpoint = redactURL(c.TraceEndpoint)
+	v := reflect.ValueOf(c)
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i).Interface()
+		if t, ok := f.(time.Duration); ok {
+			f = t.String()
+		}
+		res.Config[v.Type().Field(i).Name] = f
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// RenameRequest is the body of requests to /rename/<name>.
+type RenameRequest struct {
+	Name string
+}
+
+// serveRename gives a volume another name (POST /rename/<name>). If both
+// names are served by the same instance of gcsfuse, it keeps running.
+// Docker does not learn about the new name, containers have to use it.
+func (d Driver) serveRename(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/rename/")
+
+	var req RenameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := d.rename(name, req.Name); err != nil {
+		code := http.StatusInternalServerError
+		switch err {
+		case errBadName:
+			code = http.StatusBadRequest
+		case errNoSuchVolume:
+			code = http.StatusNotFound
+		case errVolumeExists, errRenameInUse:
+			code = http.StatusConflict
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	res, err := d.Get(&volume.GetRequest{Name: req.Name})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res.Volume)
+}
+
+func (d Driver) rename(from, to string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	from, to = normalize(from), normalize(to)
+
+	if err := checkName(to); err != nil {
+		return err
+	}
+	opts, ok := d.opts[from]
+	if !ok {
+		return errNoSuchVolume
+	}
+	if from == to {
+		return nil
+	}
+	if _, ok := d.opts[to]; ok {
+		return errVolumeExists
+	}
+
+	// The instance of gcsfuse is identified by the bucket, or the subpath
+	// with only_dir, not by the name. Only if that changes, the volume
+	// moves to another one.
+	k, nk := d.key(from, opts), d.key(to, opts)
+	if k != nk {
+		if m, ok := d.cmds[k]; ok && len(m.refs) > 0 {
+			return errRenameInUse
+		}
+		if err := d.mkdir(d.target(nk)); err != nil {
+			return err
+		}
+	}
+
+	if err := d.persist(to, opts); err != nil {
+		return err
+	}
+	delete(d.opts, from)
+	d.opts[to] = opts
+	d.forget(from)
+	infof("Renamed volume %s to %s.", from, to)
+
+	if k == nk {
+		return nil
+	}
+	for other, o := range d.opts {
+		if d.key(other, o) == k {
+			return nil
+		}
+	}
+	// Like Remove, the directory of the old one is only removed if it is
+	// empty. An idle gcsfuse is left to be evicted.
+	if _, ok := d.cmds[k]; !ok {
+		os.Remove(d.target(k))
+	}
+	return nil
+}
+
+// serveOptions lists the options that volumes support.
+func serveOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(optionSpecs)
+}
+
+// setDraining must be called with the lock held.
+func (d Driver) setDraining(on bool) {
+	if *d.draining == on {
+		return
+	}
+	*d.draining = on
+
+	if on {
+		infof("Draining, refusing to mount further buckets.")
+		drainMode.set(1)
+	} else {
+		infof("Not draining anymore.")
+		drainMode.set(0)
+	}
+}
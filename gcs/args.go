@@ -0,0 +1,277 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"errors"
+	"math"
+	"os"
+	"strings"
+)
+
+var errNoGRPC = errors.New("grpc_conn_pool_size only applies to the gRPC client of gcsfuse; also set client_protocol=grpc")
+
+// Arguments for gcsfuse that the driver relies on. They come first, and
+// are overridden by the default owner (see Config.User), the log level
+// (see Config.GcsfuseLogLevel), global flags (see Config.GcsfuseArgs) and
+// the options of volumes, in that order.
+var defaultArgs = []string{"--foreground", "-o", "subtype=gcsfuse"}
+
+// flagSet collects flags of gcsfuse, later flags override earlier ones.
+// Mount options, which are given via -o, accumulate instead.
+type flagSet struct {
+	// Names of flags in order of their first occurrence.
+	names []string
+
+	// Maps name of flags to their value, which is empty for flags that
+	// are set without a value.
+	values map[string]string
+
+	// Same as above, for mount options.
+	optNames []string
+	opts     map[string]string
+}
+
+// Pairs of mount options that are mutually exclusive.
+var exclusiveOpts = map[string]string{
+	"ro":      "rw",
+	"rw":      "ro",
+	"noexec":  "exec",
+	"exec":    "noexec",
+	"nosuid":  "suid",
+	"suid":    "nosuid",
+	"nodev":   "dev",
+	"dev":     "nodev",
+	"noatime": "atime",
+	"atime":   "noatime",
+	// FUSE refuses to mount with both.
+	"allow_other": "allow_root",
+	"allow_root":  "allow_other",
+}
+
+// Mount options that Config.SecureDefaults adds, right after defaultArgs.
+var secureArgs = []string{"-o", "noexec,nosuid,nodev"}
+
+// Mount option that Config.Noatime adds, likewise.
+var noatimeArgs = []string{"-o", "noatime"}
+
+// Flags of gcsfuse by Config.GcsfuseLogLevel.
+var gcsfuseLogLevels = map[string][]string{
+	"":        nil,
+	"trace":   {"--log-severity=TRACE"},
+	"debug":   {"--log-severity=DEBUG"},
+	"info":    {"--log-severity=INFO"},
+	"warning": {"--log-severity=WARNING"},
+	"error":   {"--log-severity=ERROR"},
+	"off":     {"--log-severity=OFF"},
+}
+
+func newFlagSet() *flagSet {
+	return &flagSet{values: make(map[string]string), opts: make(map[string]string)}
+}
+
+// parse adds arguments for gcsfuse to the set. Since gcsfuse takes no
+// positional arguments other than bucket and mountpoint, an argument that
+// is not a flag, see isFlag, is the value of the preceding flag. So
+// negative values work, e.g. "--limit-ops-per-sec -1".
+func (f *flagSet) parse(args []string) {
+	for i := 0; i < len(args); i++ {
+		if !isFlag(args[i]) {
+			continue
+		}
+		name := strings.TrimLeft(args[i], "-")
+
+		value := ""
+		if j := strings.Index(name, "="); j != -1 {
+			name, value = name[:j], name[j+1:]
+		} else if i+1 < len(args) && !isFlag(args[i+1]) {
+			i++
+			value = args[i]
+		}
+
+		if name == "o" {
+			for _, o := range strings.Split(value, ",") {
+				f.setOpt(o)
+			}
+			continue
+		}
+
+		if _, ok := f.values[name]; !ok {
+			f.names = append(f.names, name)
+		}
+		f.values[name] = value
+	}
+}
+
+// isFlag tells whether a is the name of a flag, i.e. one or two dashes
+// followed by a letter, as opposed to a value such as "-1".
+func isFlag(a string) bool {
+	name := strings.TrimPrefix(a, "-")
+	if name == a {
+		return false
+	}
+	name = strings.TrimPrefix(name, "-")
+	return name != "" && (name[0] >= 'a' && name[0] <= 'z' || name[0] >= 'A' && name[0] <= 'Z')
+}
+
+// setOpt adds a mount option, e.g. "ro" or "comment=foo".
+func (f *flagSet) setOpt(o string) {
+	if o == "" {
+		return
+	}
+
+	name := o
+	if j := strings.Index(o, "="); j != -1 {
+		name = o[:j]
+	}
+
+	// Of mutually exclusive options, the last one wins.
+	if other, ok := exclusiveOpts[name]; ok {
+		f.deleteOpt(other)
+	}
+
+	if _, ok := f.opts[name]; !ok {
+		f.optNames = append(f.optNames, name)
+	}
+	f.opts[name] = o
+}
+
+func (f *flagSet) deleteOpt(name string) {
+	if _, ok := f.opts[name]; !ok {
+		return
+	}
+	delete(f.opts, name)
+	for i, n := range f.optNames {
+		if n == name {
+			f.optNames = append(f.optNames[:i], f.optNames[i+1:]...)
+			break
+		}
+	}
+}
+
+// args renders the set as arguments for gcsfuse.
+func (f *flagSet) args() []string {
+	var args []string
+	for _, name := range f.names {
+		if v := f.values[name]; v != "" {
+			args = append(args, "--"+name+"="+v)
+		} else {
+			args = append(args, "--"+name)
+		}
+	}
+
+	if len(f.optNames) > 0 {
+		opts := make([]string, len(f.optNames))
+		for i, name := range f.optNames {
+			opts[i] = f.opts[name]
+		}
+		args = append(args, "-o", strings.Join(opts, ","))
+	}
+	return args
+}
+
+// globalFlags returns the flags that all instances of gcsfuse get, before
+// the options of their volume. See defaultArgs for precedence.
+func (d Driver) globalFlags() *flagSet {
+	f := newFlagSet()
+	f.parse(defaultArgs)
+	if d.cfg.SecureDefaults {
+		f.parse(secureArgs)
+	}
+	if d.cfg.Noatime {
+		f.parse(noatimeArgs)
+	}
+	f.parse(d.owner)
+	f.parse(gcsfuseLogLevels[d.cfg.GcsfuseLogLevel])
+	f.parse(d.cfg.GcsfuseArgs)
+	return f
+}
+
+// buildArgs returns the complete arguments for gcsfuse to mount the bucket
+// or subpath identified by k (see key) for a volume with the given options.
+// See defaultArgs for precedence.
+func (d Driver) buildArgs(k string, opts map[string]string) ([]string, error) {
+	vol, err := mountOptions(opts, d.host)
+	if err != nil {
+		return nil, err
+	}
+
+	f := d.globalFlags()
+	f.parse(vol)
+	if opts["access_scope"] == "private" {
+		// Overrides global flags, which options can only add to.
+		f.deleteOpt("allow_other")
+		f.deleteOpt("allow_root")
+	}
+	if _, ok := f.values["experimental-grpc-conn-pool-size"]; ok && f.values["client-protocol"] != "grpc" {
+		return nil, errNoGRPC
+	}
+	if sub := subpath(k); sub != "" {
+		f.parse([]string{"--only-dir=" + sub})
+	}
+
+	return append(f.args(), d.bucket(k), d.target(k)), nil
+}
+
+// Variables of the environment by the options that set them. Both spellings
+// are set, since tools differ in which one they prefer.
+var proxyVars = map[string][]string{
+	"http_proxy":  {"HTTP_PROXY", "http_proxy"},
+	"https_proxy": {"HTTPS_PROXY", "https_proxy"},
+	"no_proxy":    {"NO_PROXY", "no_proxy"},
+}
+
+// command describes how to run gcsfuse for an instance.
+type command struct {
+	args []string
+
+	// The complete environment of gcsfuse, see environ.
+	env []string
+
+	// See asyncMount.
+	async bool
+
+	// Bytes of memory that gcsfuse may use, see limitMemory.
+	memory int64
+}
+
+// command returns how to run gcsfuse for the instance identified by k, for
+// a volume with the given options.
+func (d Driver) command(k string, opts map[string]string) (command, error) {
+	args, err := d.buildArgs(k, opts)
+	if err != nil {
+		return command{}, err
+	}
+
+	var overrides []string
+	if v, ok := opts["gomaxprocs"]; ok {
+		overrides = append(overrides, "GOMAXPROCS="+v)
+	}
+	if v, ok := opts["key_file"]; ok {
+		overrides = append(overrides, "GOOGLE_APPLICATION_CREDENTIALS="+v)
+	}
+	for _, k := range []string{"http_proxy", "https_proxy", "no_proxy"} {
+		if v, ok := opts[k]; ok {
+			for _, name := range proxyVars[k] {
+				overrides = append(overrides, name+"="+v)
+			}
+		}
+	}
+	env := environ(os.Environ(), overrides, opts["key_file"] != "")
+	memory, _ := parseInt(opts["memory_limit"], minMemoryLimit, math.MaxInt64)
+	return command{args: args, env: env, async: d.asyncMount(opts), memory: memory}, nil
+}
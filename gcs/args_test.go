@@ -0,0 +1,291 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsFlag(t *testing.T) {
+	for a, want := range map[string]bool{
+		"--uid":    true,
+		"-o":       true,
+		"--uid=1":  true,
+		"-1":       false,
+		"--1":      false,
+		"-":        false,
+		"--":       false,
+		"-.5":      false,
+		"ro":       false,
+		"":         false,
+		"---weird": false,
+	} {
+		if got := isFlag(a); got != want {
+			t.Errorf("isFlag(%q) = %v, want %v", a, got, want)
+		}
+	}
+}
+
+func TestFlagSetParse(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   [][]string
+		want []string
+	}{
+		{"empty", nil, nil},
+		{"bool", [][]string{{"--implicit-dirs"}}, []string{"--implicit-dirs"}},
+		{"separate value", [][]string{{"--uid", "1000"}}, []string{"--uid=1000"}},
+		{"joined value", [][]string{{"--uid=1000"}}, []string{"--uid=1000"}},
+		{"single dash", [][]string{{"-uid", "1000"}}, []string{"--uid=1000"}},
+		{"negative value", [][]string{{"--limit-ops-per-sec", "-1"}}, []string{"--limit-ops-per-sec=-1"}},
+		{"negative joined", [][]string{{"--limit-ops-per-sec=-1"}}, []string{"--limit-ops-per-sec=-1"}},
+		{"negative fraction", [][]string{{"--limit-bytes-per-sec", "-.5"}}, []string{"--limit-bytes-per-sec=-.5"}},
+		{"bool before flag", [][]string{{"--implicit-dirs", "--uid", "1"}}, []string{"--implicit-dirs", "--uid=1"}},
+		{"later wins", [][]string{{"--uid", "1"}, {"--uid", "2"}}, []string{"--uid=2"}},
+		{"order of first occurrence", [][]string{{"--a", "--b"}, {"--a=1"}}, []string{"--a=1", "--b"}},
+		{"mount options accumulate", [][]string{{"-o", "ro"}, {"-o", "noexec,nosuid"}}, []string{"-o", "ro,noexec,nosuid"}},
+		{"exclusive options", [][]string{{"-o", "ro,noexec"}, {"-o", "rw"}}, []string{"-o", "noexec,rw"}},
+		{"option with value", [][]string{{"-o", "comment=a"}, {"-o", "comment=b"}}, []string{"-o", "comment=b"}},
+		{"allow_root over allow_other", [][]string{{"-o", "allow_other"}, {"-o", "allow_root"}}, []string{"-o", "allow_root"}},
+		{"stray values", [][]string{{"-1", "ro", "--uid", "1"}}, []string{"--uid=1"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFlagSet()
+			for _, args := range tc.in {
+				f.parse(args)
+			}
+			if got := f.args(); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBuildArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		cfg   Config
+		owner []string
+		k     string
+		opts  map[string]string
+		want  []string
+		err   error
+	}{
+		{
+			name: "defaults",
+			k:    "b",
+			want: []string{"--foreground", "-o", "subtype=gcsfuse,rw", "b", "/mnt/b"},
+		},
+		{
+			name: "global flags",
+			cfg:  Config{GcsfuseArgs: []string{"--limit-ops-per-sec", "-1"}, GcsfuseLogLevel: "debug", SecureDefaults: true},
+			k:    "b",
+			want: []string{"--foreground", "--log-severity=DEBUG", "--limit-ops-per-sec=-1", "-o", "subtype=gcsfuse,noexec,nosuid,nodev,rw", "b", "/mnt/b"},
+		},
+		{
+			name: "options override global flags",
+			cfg:  Config{SecureDefaults: true},
+			k:    "b",
+			opts: map[string]string{"noexec": "false", "comment": "hi"},
+			want: []string{"--foreground", "-o", "subtype=gcsfuse,nosuid,nodev,comment=hi,exec,rw", "b", "/mnt/b"},
+		},
+		{
+			name: "private scope drops global allow_other",
+			cfg:  Config{GcsfuseArgs: []string{"-o", "allow_other"}},
+			k:    "b",
+			opts: map[string]string{"access_scope": "private"},
+			want: []string{"--foreground", "-o", "subtype=gcsfuse,rw", "b", "/mnt/b"},
+		},
+		{
+			name: "root scope",
+			cfg:  Config{GcsfuseArgs: []string{"-o", "allow_other"}},
+			k:    "b",
+			opts: map[string]string{"access_scope": "root"},
+			want: []string{"--foreground", "-o", "subtype=gcsfuse,allow_root,rw", "b", "/mnt/b"},
+		},
+		{
+			name: "subpath",
+			k:    "b/some dir",
+			want: []string{"--foreground", "--only-dir=some dir", "-o", "subtype=gcsfuse,rw", "b", "/mnt/.subpaths/b/some%20dir"},
+		},
+		{
+			name: "squash with modes",
+			k:    "b",
+			opts: map[string]string{"squash": "1:2", "file_mode": "640"},
+			want: []string{"--foreground", "--file-mode=640", "--uid=1", "--gid=2", "-o", "subtype=gcsfuse,rw", "b", "/mnt/b"},
+		},
+		{
+			name: "grpc pool without grpc",
+			cfg:  Config{GcsfuseArgs: []string{"--experimental-grpc-conn-pool-size=4"}},
+			k:    "b",
+			err:  errNoGRPC,
+		},
+		{
+			name: "unknown option",
+			k:    "b",
+			opts: map[string]string{"nope": "1"},
+			err:  errUnknownOption{key: "nope"},
+		},
+		{
+			name: "subtype overrides default",
+			k:    "b",
+			opts: map[string]string{"subtype": "data", "fsname": "b"},
+			want: []string{"--foreground", "-o", "subtype=data,fsname=b,rw", "b", "/mnt/b"},
+		},
+		{
+			name:  "default owner",
+			owner: []string{"--uid", "1000", "--gid", "1000"},
+			k:     "b",
+			want:  []string{"--foreground", "--uid=1000", "--gid=1000", "-o", "subtype=gcsfuse,rw", "b", "/mnt/b"},
+		},
+		{
+			name:  "options override default owner",
+			owner: []string{"--uid", "1000", "--gid", "1000"},
+			k:     "b",
+			opts:  map[string]string{"user": "0"},
+			want:  []string{"--foreground", "--uid=0", "--gid=1000", "-o", "subtype=gcsfuse,rw", "b", "/mnt/b"},
+		},
+		{
+			name: "secure defaults with global flags",
+			cfg:  Config{SecureDefaults: true, GcsfuseArgs: []string{"-o", "dev"}},
+			k:    "b",
+			opts: map[string]string{"nosuid": "false"},
+			want: []string{"--foreground", "-o", "subtype=gcsfuse,noexec,dev,suid,rw", "b", "/mnt/b"},
+		},
+		{
+			name: "log level overridden by global flags",
+			cfg:  Config{GcsfuseLogLevel: "off", GcsfuseArgs: []string{"--log-severity", "ERROR"}},
+			k:    "b",
+			want: []string{"--foreground", "--log-severity=ERROR", "-o", "subtype=gcsfuse,rw", "b", "/mnt/b"},
+		},
+		{
+			name: "grpc pool",
+			k:    "b",
+			opts: map[string]string{"client_protocol": "grpc", "grpc_conn_pool_size": "4"},
+			want: []string{"--foreground", "--client-protocol=grpc", "--experimental-grpc-conn-pool-size=4", "-o", "subtype=gcsfuse,rw", "b", "/mnt/b"},
+		},
+		{
+			name: "grpc pool with global grpc",
+			cfg:  Config{GcsfuseArgs: []string{"--client-protocol", "grpc"}},
+			k:    "b",
+			opts: map[string]string{"grpc_conn_pool_size": "4"},
+			want: []string{"--foreground", "--client-protocol=grpc", "--experimental-grpc-conn-pool-size=4", "-o", "subtype=gcsfuse,rw", "b", "/mnt/b"},
+		},
+		{
+			name: "grpc pool overridden protocol",
+			cfg:  Config{GcsfuseArgs: []string{"--client-protocol", "grpc"}},
+			k:    "b",
+			opts: map[string]string{"client_protocol": "http2", "grpc_conn_pool_size": "4"},
+			err:  errNoGRPC,
+		},
+		{
+			name: "noatime default",
+			cfg:  Config{Noatime: true},
+			k:    "b",
+			want: []string{"--foreground", "-o", "subtype=gcsfuse,noatime,rw", "b", "/mnt/b"},
+		},
+		{
+			name: "noatime default overridden by volume",
+			cfg:  Config{Noatime: true},
+			k:    "b",
+			opts: map[string]string{"noatime": "false"},
+			want: []string{"--foreground", "-o", "subtype=gcsfuse,atime,rw", "b", "/mnt/b"},
+		},
+		{
+			name: "noatime default overridden by global flags",
+			cfg:  Config{Noatime: true, GcsfuseArgs: []string{"-o", "atime"}},
+			k:    "b",
+			want: []string{"--foreground", "-o", "subtype=gcsfuse,atime,rw", "b", "/mnt/b"},
+		},
+		{
+			name: "all scope",
+			cfg:  Config{GcsfuseArgs: []string{"-o", "allow_root"}},
+			k:    "b",
+			opts: map[string]string{"access_scope": "all"},
+			want: []string{"--foreground", "-o", "subtype=gcsfuse,allow_other,rw", "b", "/mnt/b"},
+		},
+		{
+			name: "private scope drops global allow_root",
+			cfg:  Config{GcsfuseArgs: []string{"-o", "allow_root,noatime"}},
+			k:    "b",
+			opts: map[string]string{"access_scope": "private"},
+			want: []string{"--foreground", "-o", "subtype=gcsfuse,noatime,rw", "b", "/mnt/b"},
+		},
+		{
+			name: "global flags decide without scope",
+			cfg:  Config{GcsfuseArgs: []string{"-o", "allow_other"}},
+			k:    "b",
+			want: []string{"--foreground", "-o", "subtype=gcsfuse,allow_other,rw", "b", "/mnt/b"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cfg.Root = "/mnt"
+			d := Driver{cfg: &tc.cfg, host: anyHost, owner: tc.owner}
+			got, err := d.buildArgs(tc.k, tc.opts)
+			if err != tc.err {
+				t.Fatalf("got error %v, want %v", err, tc.err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// Variables that options set for gcsfuse, on top of the environment of the
+// driver.
+func TestCommandEnv(t *testing.T) {
+	t.Setenv("GOMAXPROCS", "8")
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "/driver.json")
+	t.Setenv("CLOUDSDK_AUTH_ACCESS_TOKEN_FILE", "/token")
+	t.Setenv("HTTP_PROXY", "http://driver:3128")
+	for _, tc := range []struct {
+		name string
+		opts map[string]string
+		set  []string
+	}{
+		{"none", nil, []string{"GOMAXPROCS=8", "GOOGLE_APPLICATION_CREDENTIALS=/driver.json", "CLOUDSDK_AUTH_ACCESS_TOKEN_FILE=/token", "HTTP_PROXY=http://driver:3128"}},
+		{"gomaxprocs", map[string]string{"gomaxprocs": "2"}, []string{"GOOGLE_APPLICATION_CREDENTIALS=/driver.json", "CLOUDSDK_AUTH_ACCESS_TOKEN_FILE=/token", "HTTP_PROXY=http://driver:3128", "GOMAXPROCS=2"}},
+		// The volume does not get to use the token of the driver either.
+		{"key file", map[string]string{"key_file": "/volume.json"}, []string{"GOMAXPROCS=8", "HTTP_PROXY=http://driver:3128", "GOOGLE_APPLICATION_CREDENTIALS=/volume.json"}},
+		// Proxies of the driver that the volume does not set are kept.
+		{"https proxy", map[string]string{"https_proxy": "http://volume:3128"}, []string{"GOMAXPROCS=8", "GOOGLE_APPLICATION_CREDENTIALS=/driver.json", "CLOUDSDK_AUTH_ACCESS_TOKEN_FILE=/token", "HTTP_PROXY=http://driver:3128", "HTTPS_PROXY=http://volume:3128", "https_proxy=http://volume:3128"}},
+		{"all proxies", map[string]string{"http_proxy": "http://volume:3128", "no_proxy": "localhost,10.0.0.0/8", "https_proxy": "socks5://volume:1080"}, []string{"GOMAXPROCS=8", "GOOGLE_APPLICATION_CREDENTIALS=/driver.json", "CLOUDSDK_AUTH_ACCESS_TOKEN_FILE=/token",
+			"HTTP_PROXY=http://volume:3128", "http_proxy=http://volume:3128", "HTTPS_PROXY=socks5://volume:1080", "https_proxy=socks5://volume:1080", "NO_PROXY=localhost,10.0.0.0/8", "no_proxy=localhost,10.0.0.0/8"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := Driver{cfg: &Config{Root: "/mnt"}, host: anyHost}
+			c, err := d.command("b", tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var set []string
+			for _, kv := range c.env {
+				for _, name := range []string{"GOMAXPROCS=", "GOOGLE_APPLICATION_CREDENTIALS=", "CLOUDSDK_AUTH_ACCESS_TOKEN_FILE=", "HTTP_PROXY=", "http_proxy=", "HTTPS_PROXY=", "https_proxy=", "NO_PROXY=", "no_proxy="} {
+					if strings.HasPrefix(kv, name) {
+						set = append(set, kv)
+					}
+				}
+			}
+			if !reflect.DeepEqual(set, tc.set) {
+				t.Errorf("got %q, want %q", set, tc.set)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+var errEmptySecret = errors.New("file with credentials for the administrative endpoints is empty")
+
+var unauthorized = newCounter("gcs_admin_unauthorized_total", "Number of requests to administrative endpoints that were rejected for lack of credentials.")
+
+// readSecret returns the first line of the file at path, if path is set.
+func readSecret(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	s := strings.TrimSpace(strings.SplitN(string(b), "\n", 2)[0])
+	if s == "" {
+		return "", errEmptySecret
+	}
+	return s, nil
+}
+
+// guard wraps h such that it requires the bearer token or the basic auth
+// credentials of Config.AdminTokenFile or Config.AdminBasicAuthFile, if
+// any of them is set.
+func (d Driver) guard(h http.HandlerFunc) http.HandlerFunc {
+	if d.token == "" && d.basic == "" {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !d.authorized(r) {
+			unauthorized.add(1)
+			if d.basic != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="gcs"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="gcs"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (d Driver) authorized(r *http.Request) bool {
+	if d.token != "" {
+		if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") && equal(h[len("Bearer "):], d.token) {
+			return true
+		}
+	}
+	if d.basic != "" {
+		if u, p, ok := r.BasicAuth(); ok && equal(u+":"+p, d.basic) {
+			return true
+		}
+	}
+	return false
+}
+
+// equal compares secrets in constant time.
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,132 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadSecret(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		secret  string
+		err     error
+	}{
+		{"token", "s3cret\n", "s3cret", nil},
+		{"first line", "  user:pass  \nignored\n", "user:pass", nil},
+		{"empty", "\n", "", errEmptySecret},
+		{"blank first line", "\ns3cret\n", "", errEmptySecret},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "secret")
+			if err := ioutil.WriteFile(path, []byte(tc.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			s, err := readSecret(path)
+			if s != tc.secret || err != tc.err {
+				t.Errorf("got %q, %v, want %q, %v", s, err, tc.secret, tc.err)
+			}
+		})
+	}
+
+	if s, err := readSecret(""); s != "" || err != nil {
+		t.Errorf("no file: got %q, %v", s, err)
+	}
+	if _, err := readSecret(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("reading a missing file succeeded")
+	}
+}
+
+func TestGuard(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		token, basic string
+		// Credentials of the request.
+		bearer     string
+		user, pass string
+		status     int
+		challenge  string
+	}{
+		{name: "open", status: http.StatusOK},
+		{name: "token", token: "t", bearer: "t", status: http.StatusOK},
+		{name: "wrong token", token: "t", bearer: "u", status: http.StatusUnauthorized, challenge: `Bearer realm="gcs"`},
+		{name: "no token", token: "t", status: http.StatusUnauthorized, challenge: `Bearer realm="gcs"`},
+		{name: "basic for token", token: "t", user: "t", status: http.StatusUnauthorized, challenge: `Bearer realm="gcs"`},
+		{name: "basic", basic: "u:p", user: "u", pass: "p", status: http.StatusOK},
+		{name: "wrong password", basic: "u:p", user: "u", pass: "q", status: http.StatusUnauthorized, challenge: `Basic realm="gcs"`},
+		{name: "token for basic", basic: "u:p", bearer: "u:p", status: http.StatusUnauthorized, challenge: `Basic realm="gcs"`},
+		{name: "either, token", token: "t", basic: "u:p", bearer: "t", status: http.StatusOK},
+		{name: "either, basic", token: "t", basic: "u:p", user: "u", pass: "p", status: http.StatusOK},
+		{name: "either, none", token: "t", basic: "u:p", status: http.StatusUnauthorized, challenge: `Basic realm="gcs"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := Driver{token: tc.token, basic: tc.basic}
+			h := d.guard(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+
+			r := httptest.NewRequest(http.MethodGet, "/drain", nil)
+			if tc.bearer != "" {
+				r.Header.Set("Authorization", "Bearer "+tc.bearer)
+			}
+			if tc.user != "" {
+				r.SetBasicAuth(tc.user, tc.pass)
+			}
+			before := unauthorized.get()
+			w := httptest.NewRecorder()
+			h(w, r)
+
+			if w.Code != tc.status {
+				t.Errorf("got status %d, want %d", w.Code, tc.status)
+			}
+			if got := w.Header().Get("WWW-Authenticate"); got != tc.challenge {
+				t.Errorf("challenged with %q, want %q", got, tc.challenge)
+			}
+			want := 0.0
+			if tc.status == http.StatusUnauthorized {
+				want = 1
+			}
+			if n := unauthorized.get() - before; n != want {
+				t.Errorf("counted %v rejections, want %v", n, want)
+			}
+		})
+	}
+}
+
+func TestNewAdminCredentials(t *testing.T) {
+	dir := t.TempDir()
+	token, empty := filepath.Join(dir, "token"), filepath.Join(dir, "empty")
+	for path, content := range map[string]string{token: "t\n", empty: ""} {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d, err := New(Config{Root: t.TempDir(), MountConcurrency: 1, AdminTokenFile: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.token != "t" {
+		t.Errorf("token is %q", d.token)
+	}
+	if _, err := New(Config{Root: t.TempDir(), MountConcurrency: 1, AdminBasicAuthFile: empty}); err != errEmptySecret {
+		t.Errorf("empty file: got %v, want %v", err, errEmptySecret)
+	}
+}
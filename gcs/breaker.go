@@ -0,0 +1,90 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"fmt"
+	"time"
+)
+
+// State of the circuit breaker of buckets that failed to mount, see
+// Config.BreakerThreshold: 1 while open, 2 while half-open. Buckets that
+// mount fine have no value.
+var breakerState = newGauge("gcs_breaker_state", "State of the circuit breaker of buckets that failed to mount.")
+
+type errBreakerOpen struct {
+	cause error
+	until time.Time
+}
+
+func (e errBreakerOpen) Error() string {
+	return fmt.Sprintf("bucket failed to mount repeatedly, not trying again before %s; last error: %s", e.until.Format(time.RFC3339), e.cause)
+}
+
+// breaker counts consecutive failures to mount a bucket.
+type breaker struct {
+	failures int
+
+	// Once failures reach the threshold, mounting is refused until then,
+	// with err.
+	until time.Time
+	err   error
+}
+
+// allow returns the last error while the breaker for k is open. After the
+// cooldown, it lets one attempt through (half-open), and record decides
+// whether to close the breaker again. The caller must hold the lock.
+func (d Driver) allow(k string) error {
+	b, ok := d.breakers[k]
+	if !ok || b.failures < d.cfg.BreakerThreshold {
+		return nil
+	}
+	if time.Now().Before(b.until) {
+		return errBreakerOpen{cause: b.err, until: b.until}
+	}
+	breakerState.set(2, "bucket", k)
+	return nil
+}
+
+// record updates the breaker for k after an attempt to mount, which
+// failed with err unless it is nil. The caller must hold the lock.
+func (d Driver) record(k string, err error) {
+	if d.cfg.BreakerThreshold <= 0 {
+		return
+	}
+
+	if err == nil {
+		if _, ok := d.breakers[k]; ok {
+			delete(d.breakers, k)
+			breakerState.delete("bucket", k)
+		}
+		return
+	}
+
+	b, ok := d.breakers[k]
+	if !ok {
+		b = &breaker{}
+		d.breakers[k] = b
+	}
+	b.failures++
+	b.err = err
+	if b.failures >= d.cfg.BreakerThreshold {
+		b.until = time.Now().Add(d.cfg.BreakerCooldown)
+		breakerState.set(1, "bucket", k)
+		warnf("%s failed to mount %d times in a row, refusing to mount it until %s.", k, b.failures, b.until.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestBreaker(t *testing.T) {
+	const cooldown = 100 * time.Millisecond
+	notFound := "bucket doesn't exist\n"
+	run := &fakeRunner{}
+	d := newTestDriver(t, Config{BreakerThreshold: 2, BreakerCooldown: cooldown}, run)
+	mustCreate(t, d, "b", nil)
+
+	for i, step := range []struct {
+		wait   bool
+		output string
+		// Whether the breaker refused, or the error of gcsfuse.
+		open   bool
+		err    error
+		starts int
+		state  float64
+	}{
+		{false, notFound, false, errBucketNotFound{bucket: "b"}, 1, 0},
+		{false, notFound, false, errBucketNotFound{bucket: "b"}, 2, 1},
+		{false, successLine, true, nil, 2, 1},
+		// Half-open, one attempt goes through and fails again.
+		{true, notFound, false, errBucketNotFound{bucket: "b"}, 3, 1},
+		{false, successLine, true, nil, 3, 1},
+		{true, successLine, false, nil, 4, 0},
+		// Closed, counting starts over.
+		{false, notFound, false, errBucketNotFound{bucket: "b"}, 5, 0},
+	} {
+		if step.wait {
+			time.Sleep(cooldown)
+		}
+		run.output = step.output
+		_, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"})
+		if step.open {
+			if e, ok := err.(errBreakerOpen); !ok || e.cause != (errBucketNotFound{bucket: "b"}) {
+				t.Errorf("step %d: got %v, want the breaker to be open", i, err)
+			}
+		} else if err != step.err {
+			t.Errorf("step %d: got %v, want %v", i, err, step.err)
+		}
+		if n := run.starts(); n != step.starts {
+			t.Errorf("step %d: started gcsfuse %d times, want %d", i, n, step.starts)
+		}
+		if s := breakerState.get("bucket", "b"); s != step.state {
+			t.Errorf("step %d: breaker state is %g, want %g", i, s, step.state)
+		}
+		if err == nil {
+			if err := d.Unmount(&volume.UnmountRequest{Name: "b", ID: "1"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	run := &fakeRunner{output: "bucket doesn't exist\n"}
+	d := newTestDriver(t, Config{}, run)
+	mustCreate(t, d, "b", nil)
+	for i := 0; i < 5; i++ {
+		if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"}); err != (errBucketNotFound{bucket: "b"}) {
+			t.Errorf("attempt %d: got %v", i, err)
+		}
+	}
+	if n := run.starts(); n != 5 {
+		t.Errorf("started gcsfuse %d times, want 5", n)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+)
+
+// Where cgroups of gcsfuse are created if Config.CgroupRoot is not set.
+const defaultCgroupRoot = "/sys/fs/cgroup/docker-volume-gcs"
+
+// Smallest memory_limit, below which gcsfuse hardly starts.
+const minMemoryLimit = 32 << 20
+
+type errCgroup struct {
+	dir string
+	err error
+}
+
+func (e errCgroup) Error() string {
+	return fmt.Sprintf("limiting the memory of gcsfuse in cgroup %s failed: %s; check that cgroup v2 is mounted writable at %s with the memory controller enabled, or drop the option memory_limit", e.dir, e.err, cgroupFS)
+}
+
+// cgroup returns the directory of the cgroup that gcsfuse for c runs in,
+// if it has a memory_limit. Each mountpoint has one.
+func (d Driver) cgroup(c command) string {
+	mnt := c.args[len(c.args)-1]
+	rel, err := filepath.Rel(d.cfg.Root, mnt)
+	if err != nil {
+		rel = mnt
+	}
+	return filepath.Join(d.cfg.CgroupRoot, url.PathEscape(rel))
+}
+
+// limitMemory moves daemon, which was started for c, into its cgroup, if
+// c has a memory_limit.
+func (d Driver) limitMemory(c command, daemon process) error {
+	if c.memory <= 0 {
+		return nil
+	}
+	dir := d.cgroup(c)
+	if err := joinCgroup(dir, daemon.pid(), c.memory); err != nil {
+		return errCgroup{dir: dir, err: err}
+	}
+	debugf("Limited memory of gcsfuse %d to %d bytes in %s.", daemon.pid(), c.memory, dir)
+	return nil
+}
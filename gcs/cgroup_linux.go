@@ -0,0 +1,95 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package gcs
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Where the cgroup v2 hierarchy is mounted.
+const cgroupFS = "/sys/fs/cgroup"
+
+// cgroup2 tells whether the unified cgroup hierarchy is available.
+func cgroup2() bool {
+	_, err := os.Stat(filepath.Join(cgroupFS, "cgroup.controllers"))
+	return err == nil
+}
+
+// joinCgroup moves the process pid into the cgroup dir, which is created
+// with a memory limit in bytes if need be. The memory controller is
+// enabled for its parent, which must not have processes of its own.
+func joinCgroup(dir string, pid int, limit int64) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := writeCgroup(filepath.Dir(dir), "cgroup.subtree_control", "+memory"); err != nil {
+		return err
+	}
+	if err := writeCgroup(dir, "memory.max", strconv.FormatInt(limit, 10)); err != nil {
+		return err
+	}
+	// Swapping would only put off hitting the limit. Without swap
+	// accounting, the file is missing.
+	writeCgroup(dir, "memory.swap.max", "0")
+	return writeCgroup(dir, "cgroup.procs", strconv.Itoa(pid))
+}
+
+func writeCgroup(dir, file, value string) error {
+	f, err := os.OpenFile(filepath.Join(dir, file), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(value); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// memoryEvents returns the counters of memory.events of the cgroup dir,
+// e.g. "max" for how often the limit was about to be exceeded, and
+// "oom_kill" for how many processes were killed for exceeding it.
+func memoryEvents(dir string) (map[string]uint64, error) {
+	f, err := os.Open(filepath.Join(dir, "memory.events"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	events := make(map[string]uint64)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if n, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			events[fields[0]] = n
+		}
+	}
+	return events, s.Err()
+}
+
+// removeCgroup removes the cgroup dir, which fails as long as processes
+// are in it.
+func removeCgroup(dir string) error {
+	return os.Remove(dir)
+}
@@ -0,0 +1,119 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package gcs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeCgroup creates the files of a cgroup v2 hierarchy that joinCgroup
+// writes to below a temporary directory, except for those in skip. It
+// returns the directory of the cgroup.
+func fakeCgroup(t *testing.T, skip ...string) string {
+	t.Helper()
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "b")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]bool{
+		filepath.Join(parent, "cgroup.subtree_control"): true,
+		filepath.Join(dir, "memory.max"):                true,
+		filepath.Join(dir, "memory.swap.max"):           true,
+		filepath.Join(dir, "cgroup.procs"):              true,
+	}
+	for _, f := range skip {
+		delete(files, filepath.Join(parent, f))
+	}
+	for f := range files {
+		if err := ioutil.WriteFile(f, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestJoinCgroup(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		skip []string
+		want map[string]string
+		err  bool
+	}{
+		{name: "joined", want: map[string]string{"../cgroup.subtree_control": "+memory", "memory.max": "268435456", "memory.swap.max": "0", "cgroup.procs": "42"}},
+		{name: "no swap accounting", skip: []string{"b/memory.swap.max"}, want: map[string]string{"../cgroup.subtree_control": "+memory", "memory.max": "268435456", "cgroup.procs": "42"}},
+		{name: "no memory controller", skip: []string{"b/memory.max"}, err: true},
+		{name: "no cgroup", skip: []string{"cgroup.subtree_control"}, err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := fakeCgroup(t, tc.skip...)
+			err := joinCgroup(dir, 42, 268435456)
+			if (err != nil) != tc.err {
+				t.Fatalf("got %v, want error %t", err, tc.err)
+			}
+			if tc.err {
+				return
+			}
+			for f, want := range tc.want {
+				b, err := ioutil.ReadFile(filepath.Join(dir, f))
+				if err != nil || string(b) != want {
+					t.Errorf("%s is %q, %v, want %q", f, b, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryEvents(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := memoryEvents(dir); !os.IsNotExist(err) {
+		t.Errorf("without memory.events: got %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "memory.events"), []byte("low 0\nhigh 2\nmax 3\noom 1\noom_kill 1\nbroken\nbad x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	events, err := memoryEvents(dir)
+	if want := map[string]uint64{"low": 0, "high": 2, "max": 3, "oom": 1, "oom_kill": 1}; err != nil || !reflect.DeepEqual(events, want) {
+		t.Errorf("got %v, %v, want %v", events, err, want)
+	}
+}
+
+func TestRemoveCgroup(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "b")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte("42\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Outside of the cgroup hierarchy, a directory with files stands in
+	// for a cgroup with processes.
+	if err := removeCgroup(dir); err == nil {
+		t.Error("removed a cgroup with a process")
+	}
+	os.Remove(filepath.Join(dir, "cgroup.procs"))
+	if err := removeCgroup(dir); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("%s is still there", dir)
+	}
+}
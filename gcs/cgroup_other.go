@@ -0,0 +1,36 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd netbsd openbsd solaris
+
+package gcs
+
+// There are no cgroups, memory_limit is rejected.
+const cgroupFS = ""
+
+func cgroup2() bool {
+	return false
+}
+
+func joinCgroup(dir string, pid int, limit int64) error {
+	return errNotSupported
+}
+
+func memoryEvents(dir string) (map[string]uint64, error) {
+	return nil, errNotSupported
+}
+
+func removeCgroup(dir string) error {
+	return errNotSupported
+}
@@ -0,0 +1,76 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCgroupDir(t *testing.T) {
+	d := Driver{cfg: &Config{Root: "/mnt", CgroupRoot: "/sys/fs/cgroup/gcs"}}
+	for mnt, want := range map[string]string{
+		"/mnt/b":                 "/sys/fs/cgroup/gcs/b",
+		"/mnt/.subpaths/b/a%2Fb": "/sys/fs/cgroup/gcs/.subpaths%2Fb%2Fa%252Fb",
+		"/mnt/b.with.dots":       "/sys/fs/cgroup/gcs/b.with.dots",
+	} {
+		if got := d.cgroup(command{args: []string{"b", mnt}}); got != want {
+			t.Errorf("cgroup of %s is %s, want %s", mnt, got, want)
+		}
+	}
+}
+
+func TestLimitMemory(t *testing.T) {
+	d := Driver{cfg: &Config{Root: "/mnt", CgroupRoot: filepath.Join(t.TempDir(), "gcs")}}
+	if err := d.limitMemory(command{args: []string{"b", "/mnt/b"}}, &fakeProcess{}); err != nil {
+		t.Errorf("without memory_limit: %s", err)
+	}
+	// The hierarchy below a temporary directory lacks the files of cgroups.
+	c := command{args: []string{"b", "/mnt/b"}, memory: minMemoryLimit}
+	if err, ok := d.limitMemory(c, &fakeProcess{}).(errCgroup); !ok || err.dir != d.cgroup(c) || err.err == nil {
+		t.Errorf("got %v, want it to fail for %s", err, d.cgroup(c))
+	}
+}
+
+func TestMemoryLimitOption(t *testing.T) {
+	none := anyHost
+	none.cgroup2 = func() bool { return false }
+	for _, tc := range []struct {
+		name   string
+		v      string
+		h      host
+		memory int64
+		err    error
+	}{
+		{"limit", "268435456", anyHost, 268435456, nil},
+		{"smallest", "33554432", anyHost, minMemoryLimit, nil},
+		{"too small", "1048576", anyHost, 0, errBadOption{key: "memory_limit", value: "1048576", reason: "want an integer from 33554432 to 9223372036854775807"}},
+		{"no cgroup v2", "268435456", none, 0, errBadOption{key: "memory_limit", value: "268435456", reason: "requires cgroup v2, which is only available on Linux"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := Driver{cfg: &Config{Root: "/mnt"}, host: tc.h}
+			c, err := d.command("b", map[string]string{"memory_limit": tc.v})
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Fatalf("got %v, want %v", err, tc.err)
+			}
+			if c.memory != tc.memory {
+				t.Errorf("got memory %d, want %d", c.memory, tc.memory)
+			}
+		})
+	}
+}
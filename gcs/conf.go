@@ -0,0 +1,109 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Directory below root that holds options for buckets, one file per
+// bucket named like "<bucket>.conf".
+const confDir = ".gcsopts"
+
+// Configuration files larger than that are rejected.
+const maxConfSize = 64 * 1024
+
+type errBadConf struct {
+	path string
+	line int
+	msg  string
+}
+
+func (e errBadConf) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.path, e.line, e.msg)
+}
+
+// options returns the effective options of a volume of bucket b, i.e.
+// opts on top of the options configured for b, if any.
+func (d Driver) options(b string, opts map[string]string) (map[string]string, error) {
+	conf, err := readConf(filepath.Join(d.cfg.Root, confDir, b+".conf"))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range opts {
+		conf[k] = v
+	}
+	return conf, nil
+}
+
+// readConf parses a file that contains one option "key=value" per line,
+// with blank lines and lines starting with '#' being ignored. A missing
+// file holds no options.
+func readConf(path string) (map[string]string, error) {
+	opts := make(map[string]string)
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return opts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(io.LimitReader(f, maxConfSize+1))
+	n, size := 0, 0
+	for s.Scan() {
+		n++
+		size += len(s.Bytes()) + 1
+		if size > maxConfSize {
+			return nil, errBadConf{path: path, line: n, msg: fmt.Sprintf("file is larger than %d bytes", maxConfSize)}
+		}
+
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		k, v := l, ""
+		if i := strings.Index(l, "="); i != -1 {
+			k, v = strings.TrimSpace(l[:i]), strings.TrimSpace(l[i+1:])
+		}
+		if k == "" {
+			return nil, errBadConf{path: path, line: n, msg: "missing key"}
+		}
+		opts[k] = v
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	// Validate early, to point at the file.
+	if _, ok := opts["only_dir"]; ok {
+		return nil, errBadConf{path: path, line: n, msg: errOnlyDirConf.Error()}
+	}
+	if _, err := mountOptions(opts, localHost); err != nil {
+		return nil, errBadConf{path: path, line: n, msg: err.Error()}
+	}
+	return opts, nil
+}
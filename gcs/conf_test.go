@@ -0,0 +1,106 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadConf(t *testing.T) {
+	big := strings.Repeat("# padding\n", maxConfSize/10+1)
+	for _, tc := range []struct {
+		name    string
+		content string
+		want    map[string]string
+		err     func(path string) error
+	}{
+		{"empty", "", map[string]string{}, nil},
+		{"options", "access=ro\nnonempty = true\n", map[string]string{"access": "ro", "nonempty": "true"}, nil},
+		{"comments and blanks", "# defaults\n\n  access=ro  \n", map[string]string{"access": "ro"}, nil},
+		{"empty value", "comment=\n", map[string]string{"comment": ""}, nil},
+		{"value with =", "comment=a=b\n", map[string]string{"comment": "a=b"}, nil},
+		{"later wins", "access=ro\naccess=rw\n", map[string]string{"access": "rw"}, nil},
+		{"no trailing newline", "access=ro", map[string]string{"access": "ro"}, nil},
+		{"missing key", "access=ro\n=1\n", nil, func(p string) error { return errBadConf{path: p, line: 2, msg: "missing key"} }},
+		{"unknown option", "nope=1\n", nil, func(p string) error {
+			return errBadConf{path: p, line: 1, msg: errUnknownOption{key: "nope"}.Error()}
+		}},
+		{"bad value", "access=rx\n", nil, func(p string) error {
+			return errBadConf{path: p, line: 1, msg: errBadOption{key: "access", value: "rx", reason: "want one of ro, rw"}.Error()}
+		}},
+		{"only_dir", "only_dir=true\n", nil, func(p string) error { return errBadConf{path: p, line: 1, msg: errOnlyDirConf.Error()} }},
+		{"too large", big, nil, func(p string) error {
+			return errBadConf{path: p, line: maxConfSize/10 + 1, msg: "file is larger than 65536 bytes"}
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "b.conf")
+			if err := ioutil.WriteFile(path, []byte(tc.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			var want error
+			if tc.err != nil {
+				want = tc.err(path)
+			}
+			got, err := readConf(path)
+			if !reflect.DeepEqual(err, want) {
+				t.Fatalf("got error %v, want %v", err, want)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	if got, err := readConf(filepath.Join(t.TempDir(), "missing.conf")); err != nil || len(got) != 0 {
+		t.Errorf("missing file: got %v, %v", got, err)
+	}
+}
+
+func TestOptionsFromConf(t *testing.T) {
+	d := newTestDriver(t, Config{}, &fakeRunner{output: successLine})
+	dir := filepath.Join(d.cfg.Root, confDir)
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "b.conf"), []byte("access=ro\ncomment=conf\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		bucket string
+		opts   map[string]string
+		want   map[string]string
+	}{
+		{"b", nil, map[string]string{"access": "ro", "comment": "conf"}},
+		{"b", map[string]string{"access": "rw"}, map[string]string{"access": "rw", "comment": "conf"}},
+		{"other", map[string]string{"nonempty": "true"}, map[string]string{"nonempty": "true"}},
+	} {
+		got, err := d.options(tc.bucket, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("options(%s, %v) = %v, want %v", tc.bucket, tc.opts, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Flags of gcsfuse whose values are not shown, see redact.
+var secretFlags = []string{"key-file"}
+
+// Variables of the environment that point gcsfuse, or tools it calls, at
+// credentials. Volumes with their own key file do not get the ones of the
+// driver, see environ.
+var credentialVars = []string{
+	"GOOGLE_APPLICATION_CREDENTIALS",
+	"CLOUDSDK_AUTH_CREDENTIAL_FILE_OVERRIDE",
+	"CLOUDSDK_AUTH_ACCESS_TOKEN_FILE",
+}
+
+type errKeyUnreadable struct {
+	path string
+	uid  int
+}
+
+func (e errKeyUnreadable) Error() string {
+	return fmt.Sprintf("key file %s is not readable by uid %d, which the plugin runs as; fix its permissions", e.path, e.uid)
+}
+
+// keyFile returns the path of the key file that gcsfuse with the given
+// arguments uses, if any.
+func keyFile(args []string) string {
+	f := newFlagSet()
+	f.parse(args)
+	if path, ok := f.values["key-file"]; ok {
+		return path
+	}
+	return os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+}
+
+// environ returns the environment for an instance of gcsfuse: base, which
+// is the one of the driver, without variables that are set by overrides,
+// nor those with credentials if the volume has its own, then overrides.
+func environ(base, overrides []string, ownKey bool) []string {
+	drop := make(map[string]bool)
+	for _, kv := range overrides {
+		drop[kv[:strings.Index(kv, "=")+1]] = true
+	}
+	if ownKey {
+		for _, name := range credentialVars {
+			drop[name+"="] = true
+		}
+	}
+
+	env := make([]string, 0, len(base)+len(overrides))
+	for _, kv := range base {
+		if i := strings.Index(kv, "="); i == -1 || !drop[kv[:i+1]] {
+			env = append(env, kv)
+		}
+	}
+	return append(env, overrides...)
+}
+
+// redact replaces the values of secretFlags in args, which are arguments
+// for gcsfuse, so that they can be shown.
+func redact(args []string) []string {
+	out := make([]string, len(args))
+	copy(out, args)
+	for i, a := range out {
+		if !isFlag(a) {
+			continue
+		}
+		name := strings.TrimLeft(a, "-")
+		for _, s := range secretFlags {
+			switch {
+			case strings.HasPrefix(name, s+"="):
+				out[i] = a[:len(a)-len(name)] + s + "=REDACTED"
+			case name == s && i+1 < len(out) && !isFlag(out[i+1]):
+				out[i+1] = "REDACTED"
+			}
+		}
+	}
+	return out
+}
+
+// checkKeyFile makes sure that gcsfuse with the given arguments can read
+// its key file. Other problems with the file are left for gcsfuse to
+// report.
+func checkKeyFile(args []string) error {
+	path := keyFile(args)
+	if path == "" {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if os.IsPermission(err) {
+		return errKeyUnreadable{path: path, uid: os.Geteuid()}
+	}
+	if err == nil {
+		f.Close()
+	}
+	return nil
+}
+
+// redactURL hides credentials in u, a URL such as Config.EventWebhook, so
+// that it can be shown. They might be in its user info or its query, e.g.
+// a token.
+func redactURL(u string) string {
+	if u == "" {
+		return ""
+	}
+	p, err := url.Parse(u)
+	if err != nil {
+		return "REDACTED"
+	}
+	if p.User != nil {
+		p.User = url.User("REDACTED")
+	}
+	if p.RawQuery != "" {
+		p.RawQuery = "REDACTED"
+	}
+	return p.String()
+}
@@ -0,0 +1,1503 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+// Package gcs implements a Docker volume driver that mounts Google Cloud
+// Storage buckets using gcsfuse.
+package gcs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+const (
+	// Limit on the length of lines that gcsfuse prints before it reports
+	// that the file system was mounted. How long it may take is bounded
+	// by readinessTimeout instead of a number of lines, which depends on
+	// its log level.
+	maxStartupLine = 64 * 1024
+
+	// How long to wait for the mountpoint with async_mount.
+	asyncMountPoll = 2 * time.Second
+)
+
+var (
+	errDaemonDirty   = errors.New("gcsfuse did not exit cleanly, the mountpoint might still be busy; check the logs of the plugin and unmount it with fusermount -u")
+	errUnknownVolume = errors.New("volume is not mounted, no gcsfuse instance was found for its bucket; the plugin might have been restarted since it was mounted")
+	errZombie        = errors.New("gcsfuse for this bucket exited unexpectedly; check the logs of the plugin, then stop all containers using the bucket to mount it again")
+	errAccessMode    = errors.New("bucket is already mounted with a different access mode; use the same access option for all volumes of a bucket")
+	errUnsafeRemount = errors.New("refusing to change the access mode of a bucket that is in use; stop the containers using it first")
+	errNoSuchVolume  = errors.New("no such volume; create it first")
+	errVolumeExists  = errors.New("a volume with that name exists already; remove it first or pick another name")
+	errRenameInUse   = errors.New("refusing to rename a volume to another bucket or subpath while its bucket is in use; stop the containers using it first")
+	errBadName       = errors.New("volume names consist of letters, digits and the characters - _ . /, and no segment of them is . or ..")
+	errExited        = errors.New("gcsfuse exited right after starting; check the logs of the plugin for its output")
+	errDraining      = errors.New("driver is draining and does not mount further buckets; try again later or run `docker-volume-gcs drain off`")
+	errShutdown      = errors.New("driver is shutting down and does not mount buckets anymore; try again once it was restarted")
+	errLineTooLong   = fmt.Errorf("gcsfuse printed a line longer than %d bytes before mounting", maxStartupLine)
+	errStillMounted  = errors.New("mountpoint of the bucket is still mounted, refusing to remove it; unmount it with fusermount -u")
+	errConcurrency   = errors.New("mount concurrency must be at least 1")
+)
+
+type errListSource struct {
+	source string
+}
+
+func (e errListSource) Error() string {
+	return fmt.Sprintf("unknown source %q for listing volumes, use memory or filesystem", e.source)
+}
+
+type errRemoteRoot struct {
+	root string
+	fs   string
+}
+
+func (e errRemoteRoot) Error() string {
+	return fmt.Sprintf("root %s is on a file system of type %s, which does not host FUSE mounts reliably; use a local directory", e.root, e.fs)
+}
+
+type errBadRead struct {
+	cause error
+}
+
+func (e errBadRead) Error() string {
+	return fmt.Sprintf("failed to read output of gcsfuse: %s", e.cause.Error())
+}
+
+type errUnexpectedOutput struct {
+	output string
+}
+
+func (e errUnexpectedOutput) Error() string {
+	return fmt.Sprintf("gcsfuse failed to mount, its last output was %q; check the options of the volume and the credentials", e.output)
+}
+
+type errBucketNotFound struct {
+	bucket string
+}
+
+func (e errBucketNotFound) Error() string {
+	return fmt.Sprintf("no such bucket %q; check its name and that the credentials belong to its project", e.bucket)
+}
+
+type errPermissionDenied struct {
+	bucket string
+	output string
+}
+
+func (e errPermissionDenied) Error() string {
+	return fmt.Sprintf("access to bucket %q was denied, gcsfuse said %q; check the roles of the credentials", e.bucket, e.output)
+}
+
+type errTimeout struct {
+	output string
+}
+
+func (e errTimeout) Error() string {
+	return fmt.Sprintf("gcsfuse timed out connecting to Cloud Storage, its output was %q; check network connectivity or raise http_client_timeout", e.output)
+}
+
+// mount is a gcsfuse process that is shared by all containers using the
+// same bucket.
+type mount struct {
+	proc process
+
+	// Whether the bucket is mounted read-only ("ro") or read-write ("rw").
+	access string
+
+	// IDs of the mount requests that currently use the bucket. gcsfuse
+	// is stopped when the last one is unmounted.
+	refs map[string]bool
+
+	// Closed once gcsfuse is mounted or failed to, in which case err is
+	// set.
+	ready chan struct{}
+	err   error
+
+	// When refs became empty, see Config.IdleTimeout.
+	lastUsed time.Time
+
+	// How gcsfuse was started, to relaunch it.
+	cmd command
+
+	// Limits on usage, see watchUsage.
+	limits limits
+
+	// Why gcsfuse exited unexpectedly, if it did, see supervise.
+	failed string
+
+	// Where the bucket is exported to, if it is, see Config.ExportCommand.
+	export string
+
+	// Hash of the options that gcsfuse was started with, see optionsHash.
+	hash string
+
+	// Directory that caches reads and stages writes, if any, see
+	// cache_dir.
+	cache string
+
+	// Why the bucket is mounted read-only although read-write was asked
+	// for, see ro_fallback.
+	degraded string
+
+	// Closed once stop is done with hooks, nil unless they run. See
+	// awaitStop.
+	stopped chan struct{}
+}
+
+var (
+	bucketRefs   = newGauge("gcs_bucket_references", "Number of mounts that use a bucket.")
+	bucketAccess = newGauge("gcs_bucket_access_info", "Access mode of mounted buckets.")
+	mountErrors  = newCounter("gcs_mount_failures_total", "Number of failed attempts to mount a bucket.")
+)
+
+// Config holds the settings of a Driver. The flags of docker-volume-gcs
+// map to them one by one.
+type Config struct {
+	// Directory below which buckets are mounted.
+	Root string
+
+	// Arguments that are passed through to every instance of gcsfuse.
+	GcsfuseArgs []string
+
+	// Maximum number of gcsfuse instances that are started at the same
+	// time.
+	MountConcurrency int
+
+	// Default for the async_mount option of volumes.
+	AsyncMount bool
+
+	// Mount with noexec, nosuid and nodev unless volumes say otherwise.
+	SecureDefaults bool
+
+	// Mount with noatime unless volumes say otherwise. Cloud Storage has
+	// no access times, so there is nothing to update.
+	Noatime bool
+
+	// Name or id of the user and group that own all files, unless
+	// GcsfuseArgs or the user and group options of volumes say otherwise.
+	User  string
+	Group string
+
+	// Keep buckets mounted for this long after the last container stopped
+	// using them, and at most MaxIdleMounts of them (0 means no limit).
+	IdleTimeout   time.Duration
+	MaxIdleMounts int
+
+	// Look up the location of mounted buckets using the JSON API, and warn
+	// about those that are far from Region. Region defaults to the one
+	// reported by the Compute Engine metadata server.
+	LookupRegion bool
+	Region       string
+
+	// Make sure that the subpath of volumes with only_dir exists before
+	// mounting them.
+	CheckOnlyDir bool
+
+	// Command that gcsfuse is run with, e.g. "taskset", "-c", "0-3". It
+	// must exec gcsfuse, given as its first argument.
+	Wrapper []string
+
+	// Executable that is run with the bucket and mountpoint as arguments
+	// after gcsfuse mounted it, for at most HookTimeout. Unless the hook
+	// is optional, mounting fails if it does.
+	PostMountHook         string
+	PostMountHookOptional bool
+	HookTimeout           time.Duration
+
+	// Executable that is run like PostMountHook before gcsfuse is
+	// stopped. Unless it is required, unmounting proceeds if it fails.
+	PreUnmountHook         string
+	PreUnmountHookRequired bool
+
+	// Compare mounted buckets with the mount table of the kernel this
+	// often, 0 disables it. Discrepancies are logged, and with
+	// ReconcileFix, buckets that vanished from the table are unmounted, or
+	// relaunched, see Relaunch. Only supported on Linux.
+	ReconcileInterval time.Duration
+	ReconcileFix      bool
+
+	// How to unmount when gcsfuse leaves a mountpoint behind: fusermount3,
+	// fusermount, umount, or auto (the default) for the first of them
+	// that is installed.
+	UnmountTool string
+
+	// Look up the usage of mounted buckets this often, 0 disables it. See
+	// the max_size and max_objects options.
+	UsageInterval time.Duration
+
+	// Keep gcsfuse and the mountpoint as they are if mounting fails, so
+	// that they can be inspected. Removing the volume cleans up.
+	KeepFailedMounts bool
+
+	// After this many consecutive failures to mount a bucket, refuse to
+	// mount it for BreakerCooldown, 0 disables it.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// Launch gcsfuse again if it exits while containers use it, e.g.
+	// after it was killed for running out of memory.
+	Relaunch bool
+
+	// Severity of messages that gcsfuse logs: trace, debug, info, warning,
+	// error or off. If empty, gcsfuse decides. GcsfuseArgs and options of
+	// volumes take precedence. See SetLogLevel for the driver itself.
+	GcsfuseLogLevel string
+
+	// Owner of the mountpoint directories, as "uid:gid", where either may
+	// be a name or left out. They are chowned before gcsfuse mounts over
+	// them. This is independent of the owner of files, see User.
+	ChownMountpoint string
+
+	// Log a warning whenever the lock of the driver, which serializes
+	// most requests, was held for longer than this, 0 disables it.
+	LockWarnThreshold time.Duration
+
+	// Executable that is run with the bucket and mountpoint as arguments
+	// after mounting volumes with nfs_export, for at most HookTimeout, to
+	// export the mountpoint, e.g. over NFS. The first line of its output
+	// is shown in the status of volumes. If it fails, mounting does.
+	// Before unmounting, UnexportCommand is run likewise.
+	ExportCommand   string
+	UnexportCommand string
+
+	// Where List takes volumes from: "memory" (the default) for those
+	// that were created since the driver started, or "filesystem" for
+	// the directories below Root, which includes ones from earlier runs.
+	ListSource string
+
+	// Unmount mountpoints that stay busy lazily, i.e. detach them right
+	// away and let the kernel clean up once they are not busy anymore.
+	LazyUnmount bool
+
+	// Refuse to start if Root is on a network file system, or in another
+	// FUSE mount, where nested mounts misbehave. Otherwise, that is only
+	// logged. Only detected on Linux.
+	StrictRoot bool
+
+	// Path of a file that maps prefixes of volume names to other buckets
+	// or subpaths, see readPrefixes. Volumes keep their name, only their
+	// bucket and subpath are derived from the rewritten one.
+	PrefixMap string
+
+	// Signal that stops gcsfuse, "SIGINT" or "SIGTERM". With "auto", or
+	// if empty, SIGTERM is used for gcsfuse that may ignore interrupts.
+	TeardownSignal string
+
+	// Files that hold a bearer token, or basic auth credentials as
+	// "user:password", that administrative endpoints require. Either
+	// one is accepted. If neither is set, the endpoints are open.
+	AdminTokenFile     string
+	AdminBasicAuthFile string
+
+	// Require credentials for /metrics too.
+	ProtectMetrics bool
+
+	// Add user_allow_other to fuse.conf if gcsfuse is to mount with
+	// allow_other, but it is missing. Otherwise, that is only logged.
+	ManageFuseConf bool
+
+	// Ignore only_dir, so that all volumes of a bucket share one instance
+	// of gcsfuse, which mounts the whole bucket. Volumes of subpaths point
+	// into it, and it is stopped once the last of them is unmounted.
+	ShareSubpaths bool
+
+	// Base URL of an OpenTelemetry collector, e.g. "http://localhost:4318",
+	// that receives a trace of each Mount and Unmount by OTLP over HTTP.
+	// Tracing is off if empty.
+	TraceEndpoint string
+
+	// Adopt orphaned descendants, and reap any children that exit without
+	// being waited for, so that they do not linger as zombies. Useful if
+	// the driver runs as PID 1 in a container.
+	ReapZombies bool
+
+	// How long Get, List and Path may take, including waiting for the
+	// lock, before they fail so that Docker is not held up, 0 means no
+	// limit.
+	QueryTimeout time.Duration
+
+	// URL that receives an Event as JSON for each mount and unmount, and
+	// each unexpected exit of gcsfuse. Events are sent in the background
+	// and retried, but dropped if it does not keep up.
+	EventWebhook string
+
+	// How to tell that gcsfuse mounted a bucket: "output" (the default)
+	// waits for it to say so, "mounts" for the mountpoint to show up in
+	// the mount table as a FUSE file system, and "either" for whichever
+	// comes first. The latter do not depend on the wording of gcsfuse.
+	Readiness string
+
+	// Directory in the cgroup v2 hierarchy below which instances of
+	// gcsfuse for volumes with memory_limit run, in a cgroup each. It is
+	// created if need be. Defaults to "/sys/fs/cgroup/docker-volume-gcs".
+	CgroupRoot string
+}
+
+// Driver wraps multiple gcsfuse processes. It implements volume.Driver.
+type Driver struct {
+	*lock
+
+	cfg *Config
+
+	// Maps bucket to the gcsfuse command that owns the bucket.
+	cmds map[string]*mount
+
+	// Maps volume name to the options it was created with, see persist.
+	opts map[string]map[string]string
+
+	// Volumes whose options could not be loaded, see loadVolumes.
+	broken map[string]bool
+
+	// Maps bucket to its location, see Config.LookupRegion.
+	regions map[string]string
+
+	// Limits how many instances of gcsfuse are started at the same time,
+	// see Config.MountConcurrency.
+	slots chan struct{}
+
+	// Whether new buckets are refused to be mounted, see serveDrain.
+	draining *bool
+
+	// Whether any mount is refused, see Shutdown.
+	stopping *bool
+
+	// Starts gcsfuse.
+	run runner
+
+	// Arguments for gcsfuse that set Config.User and Config.Group.
+	owner []string
+
+	// Maps bucket to its circuit breaker, see Config.BreakerThreshold.
+	breakers map[string]*breaker
+
+	// Command that unmounts, see Config.UnmountTool.
+	unmount []string
+
+	// Owner of mountpoints, -1 leaves it as it is. See
+	// Config.ChownMountpoint.
+	uid, gid int
+
+	// Maps bucket, or subpath with only_dir, to the last lines of output
+	// of its gcsfuse, see serveLogs.
+	logs map[string]*ring
+
+	// Goroutines that copy the output of gcsfuse, see Shutdown.
+	copiers *sync.WaitGroup
+
+	// See Config.PrefixMap.
+	prefixes []prefix
+
+	// Stops gcsfuse, see Config.TeardownSignal.
+	teardown os.Signal
+
+	// Credentials for administrative endpoints, see guard.
+	token, basic string
+
+	// See Config.TraceEndpoint.
+	tracer *tracer
+
+	// See Config.EventWebhook.
+	events *webhook
+
+	// What options depend on, see ValidateOptions.
+	host host
+}
+
+// New returns a Driver that mounts buckets below c.Root. If buckets are
+// kept mounted while idle, it starts to evict them in the background.
+func New(c Config) (*Driver, error) {
+	if err := checkArgs(c.GcsfuseArgs); err != nil {
+		return nil, err
+	}
+	if c.MountConcurrency < 1 {
+		return nil, errConcurrency
+	}
+	if len(c.Wrapper) > 0 {
+		if _, err := exec.LookPath(c.Wrapper[0]); err != nil {
+			return nil, err
+		}
+	}
+	for _, hook := range []string{c.PostMountHook, c.PreUnmountHook, c.ExportCommand, c.UnexportCommand} {
+		if hook == "" {
+			continue
+		}
+		if _, err := exec.LookPath(hook); err != nil {
+			return nil, err
+		}
+	}
+	if c.HookTimeout <= 0 {
+		c.HookTimeout = defaultHookTimeout
+	}
+	if err := checkRoot(c.Root, c.StrictRoot); err != nil {
+		return nil, err
+	}
+	if err := checkFuseConf(c.GcsfuseArgs, c.ManageFuseConf); err != nil {
+		return nil, err
+	}
+	// Not fatal, so that the driver can still be inspected, see
+	// serveHealth.
+	if err := checkFuse(); err != nil {
+		errorf("%s", err)
+	}
+
+	if _, ok := gcsfuseLogLevels[c.GcsfuseLogLevel]; !ok {
+		return nil, errLogLevel{level: c.GcsfuseLogLevel}
+	}
+
+	unmount, err := unmountCommand(c.UnmountTool)
+	if err != nil {
+		return nil, err
+	}
+
+	uid, gid, err := parseChown(c.ChownMountpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.ListSource {
+	case "":
+		c.ListSource = "memory"
+	case "memory", "filesystem":
+	default:
+		return nil, errListSource{source: c.ListSource}
+	}
+
+	if c.CgroupRoot == "" {
+		c.CgroupRoot = defaultCgroupRoot
+	}
+
+	switch c.Readiness {
+	case "":
+		c.Readiness = "output"
+	case "output", "mounts", "either":
+	default:
+		return nil, errReadiness{readiness: c.Readiness}
+	}
+
+	if c.TeardownSignal == "" {
+		c.TeardownSignal = "auto"
+	}
+	teardown, err := teardownSignal(c.TeardownSignal, func() bool { return hasFlag("ignore-interrupts") })
+	if err != nil {
+		return nil, err
+	}
+	debugf("Stopping gcsfuse with %s.", teardown)
+
+	token, err := readSecret(c.AdminTokenFile)
+	if err != nil {
+		return nil, err
+	}
+	basic, err := readSecret(c.AdminBasicAuthFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var prefixes []prefix
+	if c.PrefixMap != "" {
+		if prefixes, err = readPrefixes(c.PrefixMap); err != nil {
+			return nil, err
+		}
+	}
+
+	var owner []string
+	if c.User != "" {
+		uid, err := lookupUser(c.User)
+		if err != nil {
+			return nil, err
+		}
+		owner = append(owner, "--uid", uid)
+	}
+	if c.Group != "" {
+		gid, err := lookupGroup(c.Group)
+		if err != nil {
+			return nil, err
+		}
+		owner = append(owner, "--gid", gid)
+	}
+	if c.LookupRegion && c.Region == "" {
+		c.Region = metadataRegion()
+	}
+
+	vols, broken, err := loadVolumes(c.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	// Last, as it starts to deliver events in the background.
+	events, err := newWebhook(c.EventWebhook)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Driver{
+		lock:     &lock{threshold: c.LockWarnThreshold},
+		cfg:      &c,
+		cmds:     make(map[string]*mount),
+		opts:     vols,
+		broken:   broken,
+		regions:  make(map[string]string),
+		slots:    make(chan struct{}, c.MountConcurrency),
+		draining: new(bool),
+		stopping: new(bool),
+		run:      execRunner{wrapper: c.Wrapper},
+		owner:    owner,
+		breakers: make(map[string]*breaker),
+		unmount:  unmount,
+		uid:      uid,
+		gid:      gid,
+		logs:     make(map[string]*ring),
+		copiers:  new(sync.WaitGroup),
+		prefixes: prefixes,
+		teardown: teardown,
+		token:    token,
+		basic:    basic,
+		tracer:   newTracer(c.TraceEndpoint),
+		events:   events,
+		host:     localHost,
+	}
+
+	if c.IdleTimeout > 0 {
+		go d.evictIdle()
+	}
+	if c.ReconcileInterval > 0 {
+		go d.reconcile()
+	}
+	if c.UsageInterval > 0 {
+		go d.watchUsage()
+	}
+	if c.ReapZombies {
+		if err := setSubreaper(); err != nil && err != errNotSupported {
+			warnf("Becoming a subreaper failed: %s", err)
+		}
+		go reapZombies()
+	}
+	return d, nil
+}
+
+// checkRoot warns about root being on a remote file system, or refuses it
+// if strict.
+func checkRoot(root string, strict bool) error {
+	fs, err := remoteFilesystem(root)
+	if err == errNotSupported {
+		return nil
+	}
+	if err != nil {
+		warnf("Checking the file system of %s failed: %s", root, err)
+		return nil
+	}
+	if fs == "" {
+		return nil
+	}
+
+	if strict {
+		return errRemoteRoot{root: root, fs: fs}
+	}
+	warnf("Root %s is on a file system of type %s, nested FUSE mounts might misbehave.", root, fs)
+	return nil
+}
+
+// Register adds the endpoints for administration and metrics to h, next
+// to the ones of the volume plugin protocol.
+func (d Driver) Register(h *volume.Handler) {
+	if d.cfg.ProtectMetrics {
+		h.HandleFunc("/metrics", d.guard(serveMetrics))
+	} else {
+		h.HandleFunc("/metrics", serveMetrics)
+	}
+	h.HandleFunc("/drain", d.guard(d.serveDrain))
+	h.HandleFunc("/orphans", d.guard(d.serveOrphans))
+	h.HandleFunc("/remount/", d.guard(d.serveRemount))
+	h.HandleFunc("/rename/", d.guard(d.serveRename))
+	h.HandleFunc("/options", d.guard(serveOptions))
+	h.HandleFunc("/config", d.guard(d.serveConfig))
+	h.HandleFunc("/logs/", d.guard(d.serveLogs))
+	h.HandleFunc("/health", d.serveHealth)
+}
+
+func (d Driver) Mount(r *volume.MountRequest) (res *volume.MountResponse, err error) {
+	d.Lock()
+	defer d.Unlock()
+
+	name := normalize(r.Name)
+
+	b := d.bucket(d.resolve(name))
+	k := d.key(name, d.opts[name])
+
+	sp := d.tracer.start("Mount", nil, "volume", name, "gcsfuse", k)
+	start := time.Now()
+	defer func() {
+		sp.finish(err)
+		d.events.emit("mount", name, k, time.Since(start), err)
+	}()
+
+	// Volumes that were not created are mounted as well.
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	if *d.stopping {
+		return nil, errShutdown
+	}
+	if d.broken[name] {
+		return nil, errLostOptions
+	}
+
+	opts, err := d.options(b, d.opts[name])
+	if err != nil {
+		return nil, err
+	}
+
+	access, err := accessMode(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := d.awaitStop(k)
+
+	if ok {
+		d.await(m)
+
+		if m.err != nil {
+			return nil, m.err
+		}
+		s := sp.child("check_alive")
+		if !m.proc.alive() {
+			s.finish(errZombie)
+			return nil, errZombie
+		}
+		s.finish(nil)
+		if m.access != access && !(m.degraded != "" && enabled(opts, "ro_fallback")) {
+			warnf("Refusing to mount %s %s, bucket is mounted %s.", name, access, m.access)
+			return nil, errAccessMode
+		}
+		if h := optionsHash(opts); h != m.hash {
+			warnf("Options of volume %s (%s) differ from those gcsfuse %s was started with (%s), they do not apply.", name, h, k, m.hash)
+			optionDrift.add(1, "bucket", k)
+		}
+		m.refs[r.ID] = true
+		bucketRefs.set(float64(len(m.refs)), "bucket", k)
+		return &volume.MountResponse{Mountpoint: d.mountpoint(name)}, nil
+	}
+
+	if *d.draining {
+		return nil, errDraining
+	}
+
+	if err := d.allow(k); err != nil {
+		return nil, err
+	}
+
+	if err := checkFDs(); err != nil {
+		return nil, err
+	}
+
+	mnt := d.target(k)
+
+	c, err := d.command(k, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeyFile(c.args); err != nil {
+		return nil, err
+	}
+	if err := d.checkExport(opts); err != nil {
+		return nil, err
+	}
+
+	// Prepared here, as the lock is not held while starting gcsfuse.
+	var fallback command
+	if access == "rw" && enabled(opts, "ro_fallback") {
+		if fallback, err = d.command(k, readOnly(opts)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := d.mkdir(mnt); err != nil {
+		return nil, err
+	}
+
+	infof("Mounting %s %s", k, access)
+
+	m = &mount{access: access, refs: map[string]bool{r.ID: true}, ready: make(chan struct{}), cmd: c, limits: usageLimits(opts), hash: optionsHash(opts), cache: opts["cache_dir"]}
+	d.cmds[k] = m
+
+	out := d.logBuffer(k)
+
+	// Do not hold the lock while waiting for a slot and for gcsfuse, so
+	// that mounts of other buckets can proceed.
+	d.Unlock()
+	var proc process
+	var degraded string
+	err = d.subpathExists(k)
+	if err == nil && fallback.args != nil {
+		// gcsfuse only notices that it may not write once it does.
+		if ok, perr := writable(b, keyFile(c.args)); perr != nil {
+			warnf("Checking whether %s may be written failed, mounting it rw: %s", b, perr)
+		} else if !ok {
+			warnf("The credentials for %s lack storage.objects.create, mounting it ro.", b)
+			degraded = fmt.Sprintf("mounted read-only, the credentials lack storage.objects.create on bucket %q", b)
+			c = fallback
+		}
+	}
+	if err == nil {
+		d.slots <- struct{}{}
+		proc, err = d.start(c, out, sp)
+		<-d.slots
+	}
+	if err == nil {
+		if c.async {
+			go d.warmCache(k, mnt, opts, nil)
+		} else {
+			d.warmCache(k, mnt, opts, sp)
+		}
+	}
+	var export string
+	if err == nil && enabled(opts, "nfs_export") {
+		export, err = d.export(b, mnt)
+	}
+	d.Lock()
+
+	m.proc, m.err, m.export = proc, err, export
+	if degraded != "" && err == nil {
+		m.access, m.cmd, m.degraded = "ro", fallback, degraded
+	}
+	close(m.ready)
+	if err == nil && *d.stopping {
+		// Shutdown waited for this, and stops gcsfuse.
+		return nil, errShutdown
+	}
+	d.record(k, m.err)
+	if m.err != nil {
+		mountErrors.add(1, "bucket", k, "reason", failure(m.err))
+		if d.cfg.KeepFailedMounts {
+			infof("Keeping what is left of %s for inspection, remove the volume to clean up.", k)
+			return nil, m.err
+		}
+		delete(d.cmds, k)
+		d.discard(k, mnt, proc)
+		return nil, m.err
+	}
+	bucketRefs.set(float64(len(m.refs)), "bucket", k)
+	bucketAccess.set(1, "bucket", k, "access", m.access)
+	go d.supervise(k, m, proc)
+
+	if d.cfg.LookupRegion {
+		go d.locate(b, keyFile(m.cmd.args))
+	}
+
+	return &volume.MountResponse{Mountpoint: d.mountpoint(name)}, nil
+}
+
+// start launches gcsfuse as described by c, with its output copied to
+// stderr and out, and waits until the file system was mounted, see
+// Config.Readiness. With async, it only waits briefly for the mountpoint to show up
+// instead. If mounting fails, the process is
+// returned along with the error as long as it might still run, see
+// discard. The steps are traced below sp, which may be nil.
+func (d Driver) start(c command, out io.Writer, sp *span) (process, error) {
+	debugf("Running gcsfuse %s", strings.Join(redact(c.args), " "))
+	s := sp.child("start_gcsfuse")
+	daemon, rc, err := d.run.start(c.args, c.env)
+	s.finish(err)
+	if err != nil {
+		return nil, err
+	}
+	w := io.MultiWriter(stderr, out)
+
+	if err := d.limitMemory(c, daemon); err != nil {
+		d.copy(w, rc)
+		return daemon, err
+	}
+
+	// The bucket and the mountpoint come last, see buildArgs.
+	b, mnt := c.args[len(c.args)-2], c.args[len(c.args)-1]
+
+	if c.async {
+		d.copy(w, rc)
+		s = sp.child("await_mountpoint")
+		err = awaitMountpoint(mnt, daemon)
+	} else {
+		s = sp.child("await_mounted")
+		err = d.awaitReady(rc, w, b, mnt, daemon)
+	}
+	s.finish(err)
+	if err != nil {
+		return daemon, err
+	}
+
+	s = sp.child("post_mount_hook")
+	daemon, err = d.hooked(daemon, b, mnt)
+	s.finish(err)
+	return daemon, err
+}
+
+// copy passes on the output of gcsfuse in the background, until it exits.
+func (d Driver) copy(w io.Writer, rc io.Reader) {
+	d.copiers.Add(1)
+	go func() {
+		defer d.copiers.Done()
+		io.Copy(w, rc)
+	}()
+}
+
+// hooked runs the post-mount hook for daemon, which mounted b at mnt. If
+// that fails, daemon is stopped again.
+func (d Driver) hooked(daemon process, b, mnt string) (process, error) {
+	err := d.postMount(b, mnt)
+	if err == nil {
+		return daemon, nil
+	}
+
+	errorf("Post-mount hook for %s failed, unmounting: %s", b, err)
+	d.interrupt(b, daemon)
+	d.release(mnt)
+	return nil, err
+}
+
+// awaitMountpoint polls until mnt is a mountpoint, for a short while. As
+// long as gcsfuse is alive, it is assumed to succeed eventually.
+func awaitMountpoint(mnt string, daemon process) error {
+	for deadline := time.Now().Add(asyncMountPoll); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if !daemon.alive() {
+			return errExited
+		}
+		if isMountpoint(mnt) {
+			return nil
+		}
+	}
+	if !daemon.alive() {
+		return errExited
+	}
+	debugf("%s is not mounted yet, assuming that gcsfuse is still busy.", mnt)
+	return nil
+}
+
+// isMountpoint tells whether a file system is mounted at path, i.e. it is
+// on a different device than its parent.
+func isMountpoint(path string) bool {
+	var st, parent syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		// A FUSE file system whose daemon is gone.
+		return err == syscall.ENOTCONN
+	}
+	if err := syscall.Stat(filepath.Dir(path), &parent); err != nil {
+		return false
+	}
+	return st.Dev != parent.Dev
+}
+
+// awaitMounted reads the output of gcsfuse line by line until it reports
+// that the file system was mounted. If gcsfuse exits instead, its output
+// is used to tell what went wrong. Also see awaitReady, which bounds how
+// long this takes.
+func awaitMounted(r io.Reader, b string) error {
+	br := bufio.NewReaderSize(r, maxStartupLine)
+	var last string
+	var known error
+	for {
+		l, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			return errLineTooLong
+		}
+		if err == io.EOF && known != nil {
+			return known
+		}
+		if err == io.EOF && last != "" {
+			return errUnexpectedOutput{output: last}
+		}
+		if err != nil {
+			return errBadRead{err}
+		}
+		if bytes.HasSuffix(l, []byte("File system has been successfully mounted.\n")) {
+			return nil
+		}
+
+		last = strings.TrimSuffix(string(l), "\n")
+		if err := classify(last, b); err != nil {
+			known = err
+		}
+	}
+}
+
+// classify recognizes lines of output of gcsfuse that explain why it
+// failed to mount bucket b.
+func classify(l, b string) error {
+	for _, s := range []string{"context deadline exceeded", "Client.Timeout exceeded", "i/o timeout"} {
+		if strings.Contains(l, s) {
+			return errTimeout{output: l}
+		}
+	}
+	for _, s := range []string{"bucket doesn't exist", "Error 404", "notFound"} {
+		if strings.Contains(l, s) {
+			return errBucketNotFound{bucket: b}
+		}
+	}
+	for _, s := range []string{"Error 403", "AccessDenied", "PermissionDenied", "does not have storage."} {
+		if strings.Contains(l, s) {
+			return errPermissionDenied{bucket: b, output: l}
+		}
+	}
+	return nil
+}
+
+// failure is used to label metrics of failed mounts.
+func failure(err error) string {
+	switch err.(type) {
+	case errTimeout:
+		return "timeout"
+	case errUnexpectedOutput:
+		return "unexpected_output"
+	case errBucketNotFound:
+		return "not_found"
+	case errPermissionDenied:
+		return "permission_denied"
+	}
+	return "other"
+}
+
+func (d Driver) Remove(r *volume.RemoveRequest) error {
+	d.Lock()
+	defer d.Unlock()
+
+	name := normalize(r.Name)
+	if err := checkName(name); err != nil {
+		return err
+	}
+
+	opts, known := d.opts[name]
+	delete(d.opts, name)
+	delete(d.broken, name)
+	d.forget(name)
+
+	// The mountpoint belongs to the bucket (or the subpath with only_dir),
+	// keep it as long as another volume that shares it is around.
+	k := d.key(name, opts)
+	for other, o := range d.opts {
+		if d.key(other, o) == k {
+			return nil
+		}
+	}
+
+	// The bucket might still be mounted while idle, see Config.IdleTimeout,
+	// or have failed to, see Config.KeepFailedMounts.
+	if m, ok := d.cmds[k]; ok && m.err != nil {
+		delete(d.cmds, k)
+		d.discard(k, d.target(k), m.proc)
+	} else if ok {
+		if m.proc == nil || len(m.refs) > 0 {
+			return nil
+		}
+		if err := d.stop(k); err != nil {
+			return err
+		}
+	}
+
+	// Unmount owns the file system, Remove only owns the directory. Not
+	// RemoveAll, the directory must be empty.
+	mnt := d.target(k)
+	if isMountpoint(mnt) {
+		if known {
+			d.opts[name] = opts
+			if err := d.persist(name, opts); err != nil {
+				warnf("Keeping the options of volume %s failed: %s", name, err)
+			}
+		}
+		return errStillMounted
+	}
+	if err := os.Remove(mnt); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if subpath(k) != "" {
+		// Fails as long as other subpaths of the bucket are around.
+		os.Remove(filepath.Dir(mnt))
+	}
+	delete(d.logs, k)
+	return nil
+}
+
+func (d Driver) Get(r *volume.GetRequest) (*volume.GetResponse, error) {
+	var res *volume.GetResponse
+	err := d.bounded("Get", func(ctx context.Context) (err error) {
+		res, err = d.get(ctx, r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (d Driver) get(ctx context.Context, r *volume.GetRequest) (*volume.GetResponse, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	name := normalize(r.Name)
+
+	v := &volume.Volume{
+		Name:       r.Name,
+		Mountpoint: d.mountpoint(name),
+	}
+
+	src := d.resolve(name)
+	b := d.bucket(src)
+	status := make(map[string]interface{})
+
+	if src != name {
+		status["source"] = src
+	}
+
+	if loc, ok := d.regions[b]; ok {
+		status["location"] = loc
+	}
+
+	// The mountpoint of subpaths is within the one of the bucket, or of
+	// their own with only_dir, see mountpoint.
+	k := d.key(name, d.opts[name])
+	if sub := subpath(src); sub != "" {
+		status["bucket"] = b
+		status["subpath"] = sub
+		status["only_dir"] = subpath(k) != ""
+	}
+	if opts, err := d.options(b, d.opts[name]); err == nil {
+		status["options_hash"] = optionsHash(opts)
+	}
+	m, ok := d.cmds[k]
+	if ok && m.err != nil {
+		status["error"] = m.err.Error()
+	}
+	if ok && m.proc != nil {
+		status["references"] = len(m.refs)
+		status["access"] = m.access
+		if m.failed != "" {
+			status["failed"] = m.failed
+		}
+		if m.degraded != "" {
+			status["degraded"] = m.degraded
+		}
+		if m.cmd.memory > 0 {
+			status["memory_limit"] = m.cmd.memory
+		}
+		status["command"] = append([]string{"gcsfuse"}, redact(m.cmd.args)...)
+		status["mounted_options_hash"] = m.hash
+		if m.export != "" {
+			status["export"] = m.export
+		}
+		// Probes are skipped once the caller gave up, see bounded.
+		if ctx.Err() == nil {
+			if rss, err := procs.rss(m.proc.pid()); err == nil {
+				status["memory"] = rss
+			}
+		}
+		if m.cmd.memory > 0 && ctx.Err() == nil {
+			// Near the limit, the kernel reclaims memory of gcsfuse, which
+			// slows it down, before killing it.
+			if events, err := memoryEvents(d.cgroup(m.cmd)); err == nil && events["max"] > 0 && m.degraded == "" {
+				status["degraded"] = fmt.Sprintf("gcsfuse reached its memory_limit %d times, which slows it down", events["max"])
+			}
+		}
+		if m.cache != "" {
+			cache := map[string]interface{}{"dir": m.cache}
+			if ctx.Err() == nil {
+				if free, err := freeSpace(m.cache); err == nil {
+					cache["free_bytes"] = free
+				}
+			}
+			status["cache"] = cache
+		}
+	}
+
+	if len(status) > 0 {
+		v.Status = status
+	}
+
+	return &volume.GetResponse{Volume: v}, nil
+}
+
+func (d Driver) List() (*volume.ListResponse, error) {
+	var res *volume.ListResponse
+	err := d.bounded("List", func(ctx context.Context) (err error) {
+		res, err = d.list(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (d Driver) list(ctx context.Context) (*volume.ListResponse, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var volumes []*volume.Volume
+	if d.cfg.ListSource == "memory" {
+		names := make([]string, 0, len(d.opts))
+		for name := range d.opts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			volumes = append(volumes, &volume.Volume{Name: name, Mountpoint: d.mountpoint(name)})
+		}
+		return &volume.ListResponse{Volumes: volumes}, nil
+	}
+
+	files, err := ioutil.ReadDir(d.cfg.Root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range files {
+		// Skip hidden directories, such as confDir.
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			volumes = append(volumes, &volume.Volume{Name: entry.Name(), Mountpoint: d.mountpoint(entry.Name())})
+		}
+	}
+
+	return &volume.ListResponse{Volumes: volumes}, nil
+}
+
+func (d Driver) Path(r *volume.PathRequest) (*volume.PathResponse, error) {
+	name := normalize(r.Name)
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+
+	var mnt string
+	err := d.bounded("Path", func(ctx context.Context) error {
+		d.Lock()
+		defer d.Unlock()
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		mnt = d.mountpoint(name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &volume.PathResponse{Mountpoint: mnt}, nil
+}
+
+func (d Driver) Create(r *volume.CreateRequest) error {
+	d.Lock()
+	defer d.Unlock()
+
+	name := normalize(r.Name)
+	if err := checkName(name); err != nil {
+		return err
+	}
+
+	opts, err := d.options(d.bucket(d.resolve(name)), r.Options)
+	if err != nil {
+		return err
+	}
+
+	args, err := d.buildArgs(d.key(name, r.Options), opts)
+	if err != nil {
+		return err
+	}
+	if err := checkKeyFile(args); err != nil {
+		return err
+	}
+	if err := d.checkExport(opts); err != nil {
+		return err
+	}
+	if err := checkFuseConf(args, d.cfg.ManageFuseConf); err != nil {
+		return err
+	}
+	if d.cfg.ShareSubpaths && onlyDir(opts) && subpath(d.resolve(name)) != "" {
+		infof("Volume %s asks for only_dir, but shares the mount of its bucket, see -share-subpaths.", name)
+	}
+
+	// Without allow_other nobody but the user running gcsfuse can access
+	// the mount, no matter which permissions the kernel enforces.
+	if hasMountOption(args, "default_permissions") && !hasMountOption(args, "allow_other") {
+		warnf("Volume %s uses default_permissions without allow_other, the mount is only accessible by the user running gcsfuse.", name)
+	}
+
+	// Volumes of the same bucket share its mountpoint, unless they use
+	// only_dir, also see Remove.
+	if err := d.mkdir(d.target(d.key(name, r.Options))); err != nil {
+		return err
+	}
+
+	if err := d.persist(name, r.Options); err != nil {
+		return err
+	}
+	d.opts[name] = r.Options
+	delete(d.broken, name)
+	return nil
+}
+
+func (d Driver) Unmount(r *volume.UnmountRequest) (err error) {
+	d.Lock()
+	defer d.Unlock()
+
+	name := normalize(r.Name)
+
+	k := d.key(name, d.opts[name])
+
+	sp := d.tracer.start("Unmount", nil, "volume", name, "gcsfuse", k)
+	start := time.Now()
+	defer func() {
+		sp.finish(err)
+		d.events.emit("unmount", name, k, time.Since(start), err)
+	}()
+
+	m, ok := d.cmds[k]
+
+	if !ok {
+		return errUnknownVolume
+	}
+
+	d.await(m)
+	if m.err != nil {
+		return m.err
+	}
+
+	delete(m.refs, r.ID)
+	bucketRefs.set(float64(len(m.refs)), "bucket", k)
+	if len(m.refs) > 0 {
+		debugf("Keeping gcsfuse %s, still in use by %d mounts.", k, len(m.refs))
+		return nil
+	}
+
+	if d.cfg.IdleTimeout > 0 {
+		debugf("Keeping gcsfuse %s while idle for %s.", k, d.cfg.IdleTimeout)
+		m.lastUsed = time.Now()
+		return nil
+	}
+
+	s := sp.child("stop_gcsfuse")
+	err = d.stop(k)
+	s.finish(err)
+	return err
+}
+
+// awaitStop waits until stop is done with hooks for the mount of k, if it
+// runs, and returns the mount of k after that. The caller must hold the
+// lock, which is released while waiting.
+func (d Driver) awaitStop(k string) (*mount, bool) {
+	m, ok := d.cmds[k]
+	for ok && m.stopped != nil {
+		stopped := m.stopped
+		d.Unlock()
+		<-stopped
+		d.Lock()
+		m, ok = d.cmds[k]
+	}
+	return m, ok
+}
+
+// await waits until gcsfuse for m was started, or failed to. The caller
+// must hold the lock, which is released while waiting.
+func (d Driver) await(m *mount) {
+	for m.proc == nil && m.err == nil {
+		ready := m.ready
+		d.Unlock()
+		<-ready
+		d.Lock()
+	}
+}
+
+// mkdir creates the mountpoint mnt, and chowns it if Config.ChownMountpoint
+// says so. Once gcsfuse mounted over it, its owner can not be changed.
+func (d Driver) mkdir(mnt string) error {
+	if err := os.MkdirAll(mnt, os.ModeTemporary); err != nil {
+		return err
+	}
+	if d.uid == -1 && d.gid == -1 || isMountpoint(mnt) {
+		return nil
+	}
+	return os.Chown(mnt, d.uid, d.gid)
+}
+
+// stop interrupts the gcsfuse process identified by k, waits for it to exit
+// and unmounts the mountpoint if it was left behind. The directory itself
+// is left for Remove. The caller must hold the lock, which is released
+// while hooks run.
+func (d Driver) stop(k string) error {
+	m := d.cmds[k]
+	if m.stopped != nil {
+		// Another caller is at it.
+		d.awaitStop(k)
+		return nil
+	}
+
+	// Like for the post-mount hook and export, do not hold the lock while
+	// hooks run, so that other buckets can be mounted. Mounts of this one
+	// wait, see awaitStop.
+	b, mnt := d.bucket(k), d.target(k)
+	failed, export := m.failed, m.export
+	m.stopped = make(chan struct{})
+	d.Unlock()
+	var err error
+	if failed == "" {
+		err = d.preUnmount(b, mnt)
+	}
+	if err == nil && export != "" {
+		d.unexport(b, mnt)
+	}
+	d.Lock()
+	close(m.stopped)
+	m.stopped = nil
+	if err != nil {
+		return err
+	}
+	delete(d.cmds, k)
+	bucketRefs.delete("bucket", k)
+	bucketAccess.delete("bucket", k, "access", m.access)
+	forgetUsage(k)
+
+	// Unmounting makes the kernel release all files, which uploads
+	// writes that are still staged, before gcsfuse gets to stop.
+	// Then, it exits by itself.
+	if m.cache != "" && m.failed == "" {
+		if err := d.umount(d.target(k)); err != nil {
+			warnf("Unmounting %s to flush staged writes failed: %s", d.target(k), err)
+		}
+	}
+
+	// There is nothing to interrupt once gcsfuse exited by itself.
+	if m.failed == "" {
+		err = d.interrupt(k, m.proc)
+	}
+	if uerr := d.release(d.target(k)); err == nil {
+		err = uerr
+	}
+	return err
+}
+
+// discard cleans up after daemon, which failed to mount k at mnt. It might
+// be nil, or still run.
+func (d Driver) discard(k, mnt string, daemon process) {
+	if daemon != nil && daemon.alive() {
+		d.interrupt(k, daemon)
+	}
+	if err := d.release(mnt); err != nil {
+		errorf("Unmounting %s failed: %s", mnt, err)
+	}
+}
+
+// release unmounts mnt if gcsfuse left it mounted.
+func (d Driver) release(mnt string) error {
+	if !isMountpoint(mnt) {
+		return nil
+	}
+	warnf("%s is still mounted, unmounting it.", mnt)
+	if err := d.umount(mnt); err != nil {
+		return errDaemonDirty
+	}
+	return nil
+}
+
+// interrupt stops daemon, which serves bucket b, and waits for it to exit.
+func (d Driver) interrupt(b string, daemon process) error {
+	infof("Interrupting gcsfuse %s with %s", b, d.teardown)
+	daemon.signal(d.teardown)
+	ps, err := daemon.wait()
+	if err != nil {
+		errorf("Waiting for gcsfuse %s errored, returning error.", b)
+		return err
+	}
+	if !ps.success() {
+		errorf("gcsfuse %s exited dirty, returning error.", b)
+		return errDaemonDirty
+	}
+
+	return nil
+}
+
+// Volume names may be given as URLs, e.g. "gs://bucket/sub".
+const scheme = "gs://"
+
+// mountpoint returns where volume name is found, which is below the
+// mountpoint of its bucket unless it uses only_dir. The caller must hold
+// the lock.
+func (d Driver) mountpoint(name string) string {
+	if k := d.key(name, d.opts[name]); subpath(k) != "" {
+		return d.target(k)
+	}
+	return filepath.Join(d.cfg.Root, strings.TrimPrefix(d.resolve(name), scheme))
+}
+
+// normalize drops trailing and duplicate slashes from a volume name, so
+// that e.g. "bucket/" is the same as "bucket", and "bucket//sub/" the same
+// as "bucket/sub".
+func normalize(name string) string {
+	prefix := ""
+	if strings.HasPrefix(name, scheme) {
+		prefix, name = scheme, strings.TrimPrefix(name, scheme)
+	}
+	parts := strings.FieldsFunc(name, func(r rune) bool { return r == '/' })
+	return prefix + strings.Join(parts, "/")
+}
+
+// checkName makes sure that name, as returned by normalize, stays below
+// the root directory when used as a path.
+func checkName(name string) error {
+	name = strings.TrimPrefix(name, scheme)
+	if !validName(name, "-_./") {
+		return errBadName
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "." || part == ".." {
+			return errBadName
+		}
+	}
+	return nil
+}
+
+func (d Driver) bucket(name string) string {
+	name = strings.TrimPrefix(name, scheme)
+	i := strings.Index(name, "/")
+	if i == -1 {
+		return name
+	}
+	return name[0:i]
+}
+
+func (d Driver) Capabilities() *volume.CapabilitiesResponse {
+	return &volume.CapabilitiesResponse{
+		Capabilities: volume.Capability{Scope: "global"},
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package gcs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestIsMountpoint(t *testing.T) {
+	dir := t.TempDir()
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink("/proc", link); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]bool{
+		"/proc":                       true,
+		"/proc/self":                  false,
+		dir:                           false,
+		filepath.Join(dir, "missing"): false,
+		link:                          true,
+	} {
+		if got := isMountpoint(path); got != want {
+			t.Errorf("isMountpoint(%s) = %t, want %t", path, got, want)
+		}
+	}
+}
+
+// Remove only removes the directory, whatever is still mounted there is
+// left alone, and the volume is kept.
+func TestRemoveStillMounted(t *testing.T) {
+	d := newTestDriver(t, Config{}, &fakeRunner{output: successLine})
+	mustCreate(t, d, "b", map[string]string{"access": "ro"})
+
+	// Stands in for a mount that gcsfuse left behind.
+	mnt := d.target("b")
+	if err := os.Remove(mnt); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/proc", mnt); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Remove(&volume.RemoveRequest{Name: "b"}); err != errStillMounted {
+		t.Fatalf("got %v, want %v", err, errStillMounted)
+	}
+	if _, err := os.Lstat(mnt); err != nil {
+		t.Errorf("mountpoint is gone: %s", err)
+	}
+	if _, err := d.Get(&volume.GetRequest{Name: "b"}); err != nil {
+		t.Errorf("volume is gone: %s", err)
+	}
+	d = restart(t, d, &fakeRunner{output: successLine})
+	if got := d.opts["b"]["access"]; got != "ro" {
+		t.Errorf("after restart, access is %q, want ro", got)
+	}
+
+	os.Remove(mnt)
+	if err := d.Remove(&volume.RemoveRequest{Name: "b"}); err != nil {
+		t.Errorf("removing once unmounted: %s", err)
+	}
+}
@@ -0,0 +1,1135 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// What gcsfuse prints once it mounted the bucket.
+const successLine = "File system has been successfully mounted.\n"
+
+func TestAwaitMounted(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		output string
+		err    error
+	}{
+		{"mounted", "Opening bucket...\n" + successLine, nil},
+		{"chatty", strings.Repeat("DEBUG: something\n", 10000) + successLine, nil},
+		{"no output", "", errBadRead{cause: io.EOF}},
+		{"exited", "Mounting file system \"b\"...\n", errUnexpectedOutput{output: "Mounting file system \"b\"..."}},
+		{"not found", "bucket doesn't exist\nexiting\n", errBucketNotFound{bucket: "b"}},
+		{"denied", "googleapi: Error 403: nope\n", errPermissionDenied{bucket: "b", output: "googleapi: Error 403: nope"}},
+		{"timeout", "dial tcp: i/o timeout\n", errTimeout{output: "dial tcp: i/o timeout"}},
+		{"long line", strings.Repeat("x", maxStartupLine+1) + "\n", errLineTooLong},
+		{"longest line", strings.Repeat("x", maxStartupLine-1) + "\n" + successLine, nil},
+		{"long line without newline", strings.Repeat("x", maxStartupLine), errLineTooLong},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := awaitMounted(strings.NewReader(tc.output), "b")
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("got %v, want %v", err, tc.err)
+			}
+		})
+	}
+}
+
+// fakeRunner simulates gcsfuse, for tests. Each started process prints
+// output. If that does not report a successful mount, the process exits
+// right away, otherwise once it is signalled. Then, its exit is ex. With
+// hang set, it never exits, with late set, it exits before its output can
+// be read. With gate set, output is only printed once gate is closed. The
+// arguments of all processes, and the signals they get, are recorded.
+type fakeRunner struct {
+	output string
+	err    error
+	ex     exit
+	hang   bool
+	late   bool
+	gate   chan struct{}
+
+	mu      sync.Mutex
+	started [][]string
+	signals []os.Signal
+}
+
+func (r *fakeRunner) start(args, env []string) (process, io.Reader, error) {
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+	r.mu.Lock()
+	r.started = append(r.started, args)
+	r.mu.Unlock()
+
+	pr, pw := io.Pipe()
+	p := &fakeProcess{r: r, once: new(sync.Once), done: make(chan struct{}), stderr: pw}
+	go func() {
+		if r.gate != nil {
+			<-r.gate
+		}
+		mounts := strings.Contains(r.output, successLine)
+		if r.late && !mounts {
+			p.once.Do(func() { close(p.done) })
+			time.Sleep(4 * readinessPoll)
+			io.Copy(pw, strings.NewReader(r.output))
+			pw.Close()
+			return
+		}
+		io.Copy(pw, strings.NewReader(r.output))
+		if !mounts {
+			p.exit()
+		}
+	}()
+	return p, pr, nil
+}
+
+// starts returns how many processes were started.
+func (r *fakeRunner) starts() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.started)
+}
+
+type fakeProcess struct {
+	r      *fakeRunner
+	once   *sync.Once
+	done   chan struct{}
+	stderr *io.PipeWriter
+}
+
+func (p *fakeProcess) exit() {
+	if p.r.hang {
+		return
+	}
+	p.once.Do(func() {
+		p.stderr.Close()
+		close(p.done)
+	})
+}
+
+func (p *fakeProcess) pid() int {
+	return 0
+}
+
+func (p *fakeProcess) alive() bool {
+	select {
+	case <-p.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (p *fakeProcess) signal(sig os.Signal) error {
+	p.r.mu.Lock()
+	p.r.signals = append(p.r.signals, sig)
+	p.r.mu.Unlock()
+	p.exit()
+	return nil
+}
+
+func (p *fakeProcess) wait() (exit, error) {
+	<-p.done
+	return p.r.ex, nil
+}
+
+// newTestDriver returns a driver below a temporary root that runs r
+// instead of gcsfuse.
+func newTestDriver(t *testing.T, c Config, r runner) *Driver {
+	t.Helper()
+	c.Root = t.TempDir()
+	if c.MountConcurrency == 0 {
+		c.MountConcurrency = 1
+	}
+	d, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.run = r
+	return d
+}
+
+func mustCreate(t *testing.T, d *Driver, name string, opts map[string]string) {
+	t.Helper()
+	if err := d.Create(&volume.CreateRequest{Name: name, Options: opts}); err != nil {
+		t.Fatalf("creating %s: %s", name, err)
+	}
+}
+
+func TestMountSharesGcsfuse(t *testing.T) {
+	run := &fakeRunner{output: successLine}
+	d := newTestDriver(t, Config{}, run)
+	mustCreate(t, d, "b", nil)
+	mustCreate(t, d, "b/sub", nil)
+
+	for _, r := range []*volume.MountRequest{{Name: "b", ID: "1"}, {Name: "b", ID: "2"}, {Name: "b/sub", ID: "3"}} {
+		res, err := d.Mount(r)
+		if err != nil {
+			t.Fatalf("mounting %s for %s: %s", r.Name, r.ID, err)
+		}
+		if want := d.cfg.Root + "/" + r.Name; res.Mountpoint != want {
+			t.Errorf("mountpoint of %s is %s, want %s", r.Name, res.Mountpoint, want)
+		}
+	}
+	if n := run.starts(); n != 1 {
+		t.Fatalf("started gcsfuse %d times, want once", n)
+	}
+	if got, want := run.started[0][len(run.started[0])-2:], []string{"b", d.cfg.Root + "/b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("gcsfuse mounts %q, want %q", got, want)
+	}
+
+	proc := d.cmds["b"].proc
+	for _, r := range []*volume.UnmountRequest{{Name: "b", ID: "1"}, {Name: "b/sub", ID: "3"}} {
+		if err := d.Unmount(r); err != nil {
+			t.Fatalf("unmounting %s for %s: %s", r.Name, r.ID, err)
+		}
+		if !proc.alive() {
+			t.Fatalf("gcsfuse stopped after unmounting %s for %s, while still in use", r.Name, r.ID)
+		}
+	}
+	if n := len(d.cmds["b"].refs); n != 1 {
+		t.Errorf("%d references left, want 1", n)
+	}
+
+	if err := d.Unmount(&volume.UnmountRequest{Name: "b", ID: "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if proc.alive() {
+		t.Error("gcsfuse still runs after the last unmount")
+	}
+	if _, ok := d.cmds["b"]; ok {
+		t.Error("bucket is still known after the last unmount")
+	}
+}
+
+func TestMountAgain(t *testing.T) {
+	run := &fakeRunner{output: successLine}
+	d := newTestDriver(t, Config{}, run)
+	mustCreate(t, d, "b", nil)
+
+	for i := 0; i < 2; i++ {
+		if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Unmount(&volume.UnmountRequest{Name: "b", ID: "1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := run.starts(); n != 2 {
+		t.Errorf("started gcsfuse %d times, want twice", n)
+	}
+}
+
+func TestMountIdle(t *testing.T) {
+	run := &fakeRunner{output: successLine}
+	d := newTestDriver(t, Config{}, run)
+	mustCreate(t, d, "b", nil)
+	d.cfg.IdleTimeout = time.Hour
+
+	if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Unmount(&volume.UnmountRequest{Name: "b", ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	m, ok := d.cmds["b"]
+	if !ok || !m.proc.alive() {
+		t.Fatal("gcsfuse was stopped although idle mounts are kept")
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := run.starts(); n != 1 {
+		t.Errorf("started gcsfuse %d times, want once", n)
+	}
+}
+
+func TestMountErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		run    *fakeRunner
+		create map[string]map[string]string
+		mount  []*volume.MountRequest
+		err    error
+	}{
+		{
+			name:   "no such bucket",
+			run:    &fakeRunner{output: "bucket doesn't exist\n"},
+			create: map[string]map[string]string{"b": nil},
+			mount:  []*volume.MountRequest{{Name: "b", ID: "1"}},
+			err:    errBucketNotFound{bucket: "b"},
+		},
+		{
+			name:   "exited",
+			run:    &fakeRunner{output: "Mounting...\n"},
+			create: map[string]map[string]string{"b": nil},
+			mount:  []*volume.MountRequest{{Name: "b", ID: "1"}},
+			err:    errUnexpectedOutput{output: "Mounting..."},
+		},
+		{
+			name:   "access mode",
+			run:    &fakeRunner{output: successLine},
+			create: map[string]map[string]string{"b": {"access": "ro"}, "b/sub": nil},
+			mount:  []*volume.MountRequest{{Name: "b", ID: "1"}, {Name: "b/sub", ID: "2"}},
+			err:    errAccessMode,
+		},
+		{
+			name:   "draining",
+			run:    &fakeRunner{output: successLine},
+			create: map[string]map[string]string{"b": nil},
+			mount:  []*volume.MountRequest{{Name: "b", ID: "1"}},
+			err:    errDraining,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newTestDriver(t, Config{}, tc.run)
+			for name, opts := range tc.create {
+				mustCreate(t, d, name, opts)
+			}
+			*d.draining = tc.err == errDraining
+
+			var err error
+			for _, r := range tc.mount {
+				if _, err = d.Mount(r); err != nil {
+					break
+				}
+			}
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Fatalf("got %v, want %v", err, tc.err)
+			}
+			if tc.err != errAccessMode && len(d.cmds) > 0 {
+				t.Error("failed mount is still known")
+			}
+		})
+	}
+}
+
+func TestUnmountUnknown(t *testing.T) {
+	d := newTestDriver(t, Config{}, &fakeRunner{output: successLine})
+	mustCreate(t, d, "b", nil)
+	if err := d.Unmount(&volume.UnmountRequest{Name: "b", ID: "1"}); err != errUnknownVolume {
+		t.Errorf("got %v, want %v", err, errUnknownVolume)
+	}
+}
+
+func TestMountROFallback(t *testing.T) {
+	serveGoogle(t, &fakeGoogle{perms: map[string][]string{
+		"rw": {"storage.objects.create"},
+		"ro": {"storage.objects.get"},
+	}})
+
+	for _, tc := range []struct {
+		bucket   string
+		access   string
+		degraded bool
+	}{
+		{"rw", "rw", false},
+		{"ro", "ro", true},
+		// Asking failed, gcsfuse reports whatever it runs into.
+		{"missing", "rw", false},
+	} {
+		t.Run(tc.bucket, func(t *testing.T) {
+			run := &fakeRunner{output: successLine}
+			d := newTestDriver(t, Config{}, run)
+			mustCreate(t, d, tc.bucket, map[string]string{"ro_fallback": "true"})
+			if _, err := d.Mount(&volume.MountRequest{Name: tc.bucket, ID: "1"}); err != nil {
+				t.Fatal(err)
+			}
+
+			m := d.cmds[tc.bucket]
+			if m.access != tc.access || (m.degraded != "") != tc.degraded {
+				t.Errorf("mounted %s and degraded %q, want %s", m.access, m.degraded, tc.access)
+			}
+			args := strings.Join(run.started[0], " ")
+			if !strings.Contains(args, ","+tc.access) {
+				t.Errorf("started gcsfuse %s, want it %s", args, tc.access)
+			}
+
+			res, err := d.Get(&volume.GetRequest{Name: tc.bucket})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := res.Volume.Status["degraded"]; ok != tc.degraded {
+				t.Errorf("got status %v", res.Volume.Status)
+			}
+
+			// Other volumes of the bucket get the same, if they accept it.
+			mustCreate(t, d, tc.bucket+"/fallback", map[string]string{"ro_fallback": "true"})
+			mustCreate(t, d, tc.bucket+"/strict", nil)
+			if _, err := d.Mount(&volume.MountRequest{Name: tc.bucket + "/fallback", ID: "2"}); err != nil {
+				t.Errorf("mounting another volume with ro_fallback: %s", err)
+			}
+			want := error(nil)
+			if tc.degraded {
+				want = errAccessMode
+			}
+			if _, err := d.Mount(&volume.MountRequest{Name: tc.bucket + "/strict", ID: "3"}); err != want {
+				t.Errorf("mounting another volume without ro_fallback: got %v, want %v", err, want)
+			}
+			if n := run.starts(); n != 1 {
+				t.Errorf("started gcsfuse %d times, want once", n)
+			}
+		})
+	}
+}
+
+// Volumes that are read-only anyway need not ask.
+func TestMountROFallbackReadOnly(t *testing.T) {
+	// Asking would report that objects may not be created.
+	serveGoogle(t, &fakeGoogle{perms: map[string][]string{"b": {"storage.objects.get"}}})
+
+	run := &fakeRunner{output: successLine}
+	d := newTestDriver(t, Config{}, run)
+	mustCreate(t, d, "b", map[string]string{"ro_fallback": "true", "access": "ro"})
+	if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if m := d.cmds["b"]; m.access != "ro" || m.degraded != "" {
+		t.Errorf("mounted %s and degraded %q", m.access, m.degraded)
+	}
+}
+
+func TestMountReadOnly(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		opts   map[string]string
+		access string
+	}{
+		{"default", nil, "rw"},
+		{"ro", map[string]string{"ro": "true"}, "ro"},
+		{"ro off", map[string]string{"ro": "false"}, "rw"},
+		{"access", map[string]string{"access": "ro"}, "ro"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			run := &fakeRunner{output: successLine}
+			d := newTestDriver(t, Config{}, run)
+			mustCreate(t, d, "b", tc.opts)
+			mustCreate(t, d, "b/sub", tc.opts)
+			for _, r := range []*volume.MountRequest{{Name: "b", ID: "1"}, {Name: "b/sub", ID: "2"}} {
+				if _, err := d.Mount(r); err != nil {
+					t.Fatalf("mounting %s: %s", r.Name, err)
+				}
+			}
+
+			if n := run.starts(); n != 1 {
+				t.Errorf("started gcsfuse %d times, want once", n)
+			}
+			if m := d.cmds["b"]; m.access != tc.access || len(m.refs) != 2 {
+				t.Errorf("mounted %s with %d references, want %s with 2", m.access, len(m.refs), tc.access)
+			}
+			if args := strings.Join(run.started[0], " "); !strings.Contains(args, ","+tc.access+" ") {
+				t.Errorf("started gcsfuse %s, want it %s", args, tc.access)
+			}
+		})
+	}
+}
+
+func TestCreateRemoveMountpoint(t *testing.T) {
+	d := newTestDriver(t, Config{}, &fakeRunner{})
+	// By bucket, or subpath with only_dir, see key.
+	exists := func(k string) bool {
+		_, err := os.Stat(d.target(k))
+		return err == nil
+	}
+
+	for _, step := range []struct {
+		op     string
+		name   string
+		opts   map[string]string
+		exists map[string]bool
+	}{
+		{"create", "b", nil, map[string]bool{"b": true}},
+		{"create", "b/sub", nil, map[string]bool{"b": true, "b/sub": false}},
+		{"create", "b/only", map[string]string{"only_dir": "true"}, map[string]bool{"b": true, "b/only": true}},
+		// Still used by b/sub.
+		{"remove", "b", nil, map[string]bool{"b": true, "b/only": true}},
+		{"remove", "b/only", nil, map[string]bool{"b": true, "b/only": false}},
+		{"remove", "b/sub", nil, map[string]bool{"b": false}},
+	} {
+		var err error
+		if step.op == "create" {
+			err = d.Create(&volume.CreateRequest{Name: step.name, Options: step.opts})
+		} else {
+			err = d.Remove(&volume.RemoveRequest{Name: step.name})
+		}
+		if err != nil {
+			t.Fatalf("%s %s: %s", step.op, step.name, err)
+		}
+		for k, want := range step.exists {
+			if got := exists(k); got != want {
+				t.Errorf("after %s %s, mountpoint of %s exists: %t, want %t", step.op, step.name, k, got, want)
+			}
+		}
+	}
+}
+
+func TestMountConcurrency(t *testing.T) {
+	for _, n := range []int{1, 2} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			run := &fakeRunner{output: successLine, gate: make(chan struct{})}
+			d := newTestDriver(t, Config{MountConcurrency: n}, run)
+			buckets := []string{"a", "b", "c"}
+			errs := make(chan error, len(buckets))
+			for _, b := range buckets {
+				mustCreate(t, d, b, nil)
+				go func(b string) {
+					_, err := d.Mount(&volume.MountRequest{Name: b, ID: "1"})
+					errs <- err
+				}(b)
+			}
+
+			// The others wait for a slot, without holding the lock.
+			deadline := time.Now().Add(time.Second)
+			for run.starts() < n && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(10 * time.Millisecond)
+			if s := run.starts(); s != n {
+				t.Errorf("started gcsfuse %d times before any mounted, want %d", s, n)
+			}
+			if _, err := d.Path(&volume.PathRequest{Name: "a"}); err != nil {
+				t.Errorf("path while mounting: %s", err)
+			}
+
+			close(run.gate)
+			for range buckets {
+				if err := <-errs; err != nil {
+					t.Error(err)
+				}
+			}
+			if s := run.starts(); s != len(buckets) {
+				t.Errorf("started gcsfuse %d times, want %d", s, len(buckets))
+			}
+		})
+	}
+}
+
+func TestReferences(t *testing.T) {
+	d := newTestDriver(t, Config{}, &fakeRunner{output: successLine})
+	mustCreate(t, d, "b", nil)
+	mustCreate(t, d, "b/sub", nil)
+
+	for _, step := range []struct {
+		mount bool
+		name  string
+		id    string
+		refs  int
+	}{
+		{true, "b", "1", 1},
+		{true, "b/sub", "2", 2},
+		// Docker mounts again for each container.
+		{true, "b", "3", 3},
+		{false, "b", "1", 2},
+		{false, "b/sub", "2", 1},
+		{false, "b", "3", 0},
+	} {
+		var err error
+		if step.mount {
+			_, err = d.Mount(&volume.MountRequest{Name: step.name, ID: step.id})
+		} else {
+			err = d.Unmount(&volume.UnmountRequest{Name: step.name, ID: step.id})
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v := bucketRefs.get("bucket", "b"); v != float64(step.refs) {
+			t.Errorf("after %s for %s, metric says %g references, want %d", step.name, step.id, v, step.refs)
+		}
+		res, err := d.Get(&volume.GetRequest{Name: step.name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs, ok := res.Volume.Status["references"]
+		if step.refs == 0 && ok || step.refs > 0 && refs != step.refs {
+			t.Errorf("after %s for %s, status says %v references, want %d", step.name, step.id, refs, step.refs)
+		}
+	}
+}
+
+func TestURLNames(t *testing.T) {
+	d := &Driver{cfg: &Config{Root: "/mnt"}}
+	for _, tc := range []struct {
+		name       string
+		bucket     string
+		mountpoint string
+	}{
+		{"b", "b", "/mnt/b"},
+		{"gs://b", "b", "/mnt/b"},
+		{"gs://b/sub/dir", "b", "/mnt/b/sub/dir"},
+		{"b/sub/dir", "b", "/mnt/b/sub/dir"},
+	} {
+		if got := d.bucket(tc.name); got != tc.bucket {
+			t.Errorf("bucket of %s is %s, want %s", tc.name, got, tc.bucket)
+		}
+		if got := d.mountpoint(tc.name); got != tc.mountpoint {
+			t.Errorf("mountpoint of %s is %s, want %s", tc.name, got, tc.mountpoint)
+		}
+	}
+
+	run := &fakeRunner{output: successLine}
+	d = newTestDriver(t, Config{}, run)
+	mustCreate(t, d, "gs://b/sub", nil)
+	if _, err := d.Mount(&volume.MountRequest{Name: "gs://b/sub", ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := run.started[0][len(run.started[0])-2:], []string{"b", d.cfg.Root + "/b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("gcsfuse mounts %q, want %q", got, want)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		line    string
+		err     error
+		failure string
+	}{
+		{"Opening GCS connection...", nil, ""},
+		{"daemonize.Run: readFromProcess: sub-process: mountWithArgs: Get \"https://storage.googleapis.com\": context deadline exceeded", errTimeout{output: "daemonize.Run: readFromProcess: sub-process: mountWithArgs: Get \"https://storage.googleapis.com\": context deadline exceeded"}, "timeout"},
+		{"net/http: request canceled (Client.Timeout exceeded while awaiting headers)", errTimeout{output: "net/http: request canceled (Client.Timeout exceeded while awaiting headers)"}, "timeout"},
+		{"dial tcp 142.250.0.1:443: i/o timeout", errTimeout{output: "dial tcp 142.250.0.1:443: i/o timeout"}, "timeout"},
+		{"mountWithArgs: bucket doesn't exist", errBucketNotFound{bucket: "b"}, "not_found"},
+		{"googleapi: Error 404: The specified bucket does not exist., notFound", errBucketNotFound{bucket: "b"}, "not_found"},
+		{"storage: bucket notFound", errBucketNotFound{bucket: "b"}, "not_found"},
+	} {
+		err := classify(tc.line, "b")
+		if !reflect.DeepEqual(err, tc.err) {
+			t.Errorf("classify(%q) = %v, want %v", tc.line, err, tc.err)
+		}
+		if err != nil && failure(err) != tc.failure {
+			t.Errorf("failure of %q is %s, want %s", tc.line, failure(err), tc.failure)
+		}
+	}
+	if f := failure(errExited); f != "other" {
+		t.Errorf("failure of other errors is %s, want other", f)
+	}
+}
+
+// Errors of Mount are shown to users by Docker as they are.
+func TestMountErrorMessages(t *testing.T) {
+	for _, tc := range []struct {
+		output string
+		msg    string
+	}{
+		{"", "failed to read output of gcsfuse: EOF"},
+		{"Mounting file system \"b\"...\n", `gcsfuse failed to mount, its last output was "Mounting file system \"b\"..."; check the options of the volume and the credentials`},
+		{"bucket doesn't exist\n", `no such bucket "b"; check its name and that the credentials belong to its project`},
+		{"googleapi: Error 403: nope\n", `access to bucket "b" was denied, gcsfuse said "googleapi: Error 403: nope"; check the roles of the credentials`},
+		{"dial tcp: i/o timeout\n", `gcsfuse timed out connecting to Cloud Storage, its output was "dial tcp: i/o timeout"; check network connectivity or raise http_client_timeout`},
+	} {
+		d := newTestDriver(t, Config{}, &fakeRunner{output: tc.output})
+		mustCreate(t, d, "b", nil)
+		_, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"})
+		if err == nil || err.Error() != tc.msg {
+			t.Errorf("output %q: got %v, want %s", tc.output, err, tc.msg)
+		}
+	}
+}
+
+func TestMountAsync(t *testing.T) {
+	notFound := "bucket doesn't exist\n"
+	for _, tc := range []struct {
+		name   string
+		config bool
+		opts   map[string]string
+		output string
+		err    error
+	}{
+		{"sync", false, nil, notFound, errBucketNotFound{bucket: "b"}},
+		{"config", true, nil, notFound, errExited},
+		{"option", false, map[string]string{"async_mount": "true"}, notFound, errExited},
+		{"option overrides config", true, map[string]string{"async_mount": "false"}, notFound, errBucketNotFound{bucket: "b"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newTestDriver(t, Config{AsyncMount: tc.config}, &fakeRunner{output: tc.output})
+			mustCreate(t, d, "b", tc.opts)
+			_, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"})
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("got %v, want %v", err, tc.err)
+			}
+		})
+	}
+}
+
+// Without a mountpoint or any output, gcsfuse is assumed to be busy as
+// long as it runs.
+func TestMountAsyncBusy(t *testing.T) {
+	gate := make(chan struct{})
+	defer close(gate)
+	d := newTestDriver(t, Config{AsyncMount: true}, &fakeRunner{output: successLine, gate: gate})
+	mustCreate(t, d, "b", nil)
+	start := time.Now()
+	if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took < asyncMountPoll {
+		t.Errorf("returned after %s, want it to poll for %s", took, asyncMountPoll)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	type bucket struct {
+		name  string
+		idle  time.Duration
+		inUse bool
+	}
+	for _, tc := range []struct {
+		name    string
+		max     int
+		buckets []bucket
+		evicted []string
+	}{
+		{"recent", 0, []bucket{{"a", time.Minute, false}}, nil},
+		{"expired", 0, []bucket{{"a", 2 * time.Hour, false}, {"b", time.Minute, false}}, []string{"a"}},
+		{"in use", 0, []bucket{{"a", 2 * time.Hour, true}}, nil},
+		{"within limit", 2, []bucket{{"a", 3 * time.Minute, false}, {"b", 2 * time.Minute, false}}, nil},
+		{"least recently used", 1, []bucket{{"a", 2 * time.Minute, false}, {"b", 3 * time.Minute, false}, {"c", time.Minute, false}}, []string{"a", "b"}},
+		{"in use does not count", 1, []bucket{{"a", 3 * time.Minute, true}, {"b", 2 * time.Minute, false}}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newTestDriver(t, Config{}, &fakeRunner{output: successLine})
+			d.cfg.IdleTimeout, d.cfg.MaxIdleMounts = time.Hour, tc.max
+			for _, b := range tc.buckets {
+				mustCreate(t, d, b.name, nil)
+				if _, err := d.Mount(&volume.MountRequest{Name: b.name, ID: "1"}); err != nil {
+					t.Fatal(err)
+				}
+				if !b.inUse {
+					if err := d.Unmount(&volume.UnmountRequest{Name: b.name, ID: "1"}); err != nil {
+						t.Fatal(err)
+					}
+				}
+				d.cmds[b.name].lastUsed = time.Now().Add(-b.idle)
+			}
+
+			d.evict()
+
+			var evicted []string
+			for _, b := range tc.buckets {
+				if _, ok := d.cmds[b.name]; !ok {
+					evicted = append(evicted, b.name)
+				}
+			}
+			if !reflect.DeepEqual(evicted, tc.evicted) {
+				t.Errorf("evicted %q, want %q", evicted, tc.evicted)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  Config
+		err  error
+	}{
+		{"defaults", Config{}, nil},
+		{"no concurrency", Config{MountConcurrency: -1}, errConcurrency},
+		{"daemonizing", Config{GcsfuseArgs: []string{"--foreground=false"}}, errDaemonizing{arg: "--foreground=false"}},
+		{"log level", Config{GcsfuseLogLevel: "loud"}, errLogLevel{level: "loud"}},
+		{"list source", Config{ListSource: "cloud"}, errListSource{source: "cloud"}},
+		{"readiness", Config{Readiness: "never"}, errReadiness{readiness: "never"}},
+		{"teardown signal", Config{TeardownSignal: "SIGKILL"}, errTeardownSignal{signal: "SIGKILL"}},
+		{"unmount tool", Config{UnmountTool: "eject"}, errUnmountTool{tool: "eject"}},
+		{"user", Config{User: "no-such-account"}, errUnknownUser{name: "no-such-account"}},
+		{"group", Config{Group: "no-such-account"}, errUnknownGroup{name: "no-such-account"}},
+		{"chown mountpoint", Config{ChownMountpoint: "1000"}, errChown{spec: "1000"}},
+		{"prefix map", Config{PrefixMap: "/no/such/prefixes"}, &os.PathError{Op: "open", Path: "/no/such/prefixes", Err: syscall.ENOENT}},
+		{"wrapper", Config{Wrapper: []string{"no-such-wrapper", "-c", "1"}}, &exec.Error{Name: "no-such-wrapper", Err: exec.ErrNotFound}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cfg.Root = t.TempDir()
+			if tc.cfg.MountConcurrency == 0 {
+				tc.cfg.MountConcurrency = 1
+			}
+			d, err := New(tc.cfg)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Fatalf("got %v, want %v", err, tc.err)
+			}
+			if err != nil {
+				return
+			}
+			if d.cfg.ListSource != "memory" || d.cfg.Readiness != "output" || d.cfg.HookTimeout != defaultHookTimeout {
+				t.Errorf("got list source %s, readiness %s and hook timeout %s", d.cfg.ListSource, d.cfg.Readiness, d.cfg.HookTimeout)
+			}
+		})
+	}
+}
+
+func TestNewOwner(t *testing.T) {
+	for _, tc := range []struct {
+		user, group string
+		owner       []string
+	}{
+		{"", "", nil},
+		{"0", "", []string{"--uid", "0"}},
+		{"", "0", []string{"--gid", "0"}},
+		{"0", "0", []string{"--uid", "0", "--gid", "0"}},
+	} {
+		d, err := New(Config{Root: t.TempDir(), MountConcurrency: 1, User: tc.user, Group: tc.group})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(d.owner, tc.owner) {
+			t.Errorf("user %q and group %q: got %q, want %q", tc.user, tc.group, d.owner, tc.owner)
+		}
+	}
+}
+
+func TestGetCommand(t *testing.T) {
+	d := newTestDriver(t, Config{}, &fakeRunner{output: successLine})
+	mustCreate(t, d, "b", map[string]string{"key_file": "/secret/key.json"})
+	mustCreate(t, d, "idle", nil)
+	if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]interface{}{
+		"b":    []string{"gcsfuse", "--foreground", "--key-file=REDACTED", "-o", "subtype=gcsfuse,rw", "b", d.target("b")},
+		"idle": nil,
+	} {
+		res, err := d.Get(&volume.GetRequest{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := res.Volume.Status["command"]
+		if want == nil {
+			if ok {
+				t.Errorf("%s: got command %q, want none", name, got)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got command %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestKeepFailedMounts(t *testing.T) {
+	failed := errBucketNotFound{bucket: "b"}
+	for _, keep := range []bool{false, true} {
+		run := &fakeRunner{output: "bucket doesn't exist\n"}
+		d := newTestDriver(t, Config{KeepFailedMounts: keep}, run)
+		mustCreate(t, d, "b", nil)
+		if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"}); err != failed {
+			t.Fatalf("keep=%t: got %v, want %v", keep, err, failed)
+		}
+
+		res, err := d.Get(&volume.GetRequest{Name: "b"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := res.Volume.Status["error"]; ok != keep || (keep && got != failed.Error()) {
+			t.Errorf("keep=%t: got error %v in the status", keep, got)
+		}
+
+		// Kept mounts fail right away.
+		if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "2"}); err != failed {
+			t.Errorf("keep=%t: mounting again got %v, want %v", keep, err, failed)
+		}
+		want := 2
+		if keep {
+			want = 1
+		}
+		if n := run.starts(); n != want {
+			t.Errorf("keep=%t: started gcsfuse %d times, want %d", keep, n, want)
+		}
+
+		// Removing cleans up.
+		if err := d.Remove(&volume.RemoveRequest{Name: "b"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := d.cmds["b"]; ok {
+			t.Errorf("keep=%t: failed mount is left after removing the volume", keep)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	for name, want := range map[string]string{
+		"b":           "b",
+		"b/":          "b",
+		"b//":         "b",
+		"/b":          "b",
+		"b/sub/":      "b/sub",
+		"b//sub///x/": "b/sub/x",
+		"gs://b/":     "gs://b",
+		"gs://b//sub": "gs://b/sub",
+		"":            "",
+	} {
+		if got := normalize(name); got != want {
+			t.Errorf("normalize(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// Names that normalize the same refer to the same volume.
+func TestNormalizedNames(t *testing.T) {
+	run := &fakeRunner{output: successLine}
+	d := newTestDriver(t, Config{}, run)
+	mustCreate(t, d, "b//sub/", nil)
+	if _, ok := d.opts["b/sub"]; !ok || len(d.opts) != 1 {
+		t.Fatalf("created %v, want b/sub", d.opts)
+	}
+	for _, name := range []string{"b/sub", "b/sub/", "b//sub"} {
+		if _, err := d.Get(&volume.GetRequest{Name: name}); err != nil {
+			t.Errorf("getting %s: %s", name, err)
+		}
+		if _, err := d.Mount(&volume.MountRequest{Name: name, ID: name}); err != nil {
+			t.Errorf("mounting %s: %s", name, err)
+		}
+	}
+	if n := run.starts(); n != 1 {
+		t.Errorf("started gcsfuse %d times, want once", n)
+	}
+	if err := d.Remove(&volume.RemoveRequest{Name: "b/sub//"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.opts["b/sub"]; ok {
+		t.Error("volume is left after removing it")
+	}
+}
+
+func TestCheckName(t *testing.T) {
+	for name, want := range map[string]error{
+		"b":               nil,
+		"my-bucket_1.a":   nil,
+		"b/sub.dir/x":     nil,
+		"gs://b/sub":      nil,
+		"b/..x/x..":       nil,
+		"":                errBadName,
+		"gs://":           errBadName,
+		".":               errBadName,
+		"..":              errBadName,
+		"../x":            errBadName,
+		"b/..":            errBadName,
+		"b/./sub":         errBadName,
+		"gs://b/../c":     errBadName,
+		"b/a b":           errBadName,
+		"b;rm":            errBadName,
+		"b\\..\\x":        errBadName,
+		"gs://gs://b/sub": errBadName,
+	} {
+		if got := checkName(name); got != want {
+			t.Errorf("checkName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// Names that would lead out of the root directory are refused, also if
+// the volume was not created.
+func TestBadNames(t *testing.T) {
+	for _, name := range []string{"..", "../x", "b/../..", "gs://../x", "/./"} {
+		t.Run(name, func(t *testing.T) {
+			run := &fakeRunner{output: successLine}
+			d := newTestDriver(t, Config{}, run)
+			outside := filepath.Join(filepath.Dir(d.cfg.Root), "x")
+
+			if err := d.Create(&volume.CreateRequest{Name: name}); err != errBadName {
+				t.Errorf("creating got %v, want %v", err, errBadName)
+			}
+			if _, err := d.Mount(&volume.MountRequest{Name: name, ID: "1"}); err != errBadName {
+				t.Errorf("mounting got %v, want %v", err, errBadName)
+			}
+			if _, err := d.Path(&volume.PathRequest{Name: name}); err != errBadName {
+				t.Errorf("getting the path got %v, want %v", err, errBadName)
+			}
+			if err := d.Remove(&volume.RemoveRequest{Name: name}); err != errBadName {
+				t.Errorf("removing got %v, want %v", err, errBadName)
+			}
+			if n := run.starts(); n != 0 {
+				t.Errorf("started gcsfuse %d times, want never", n)
+			}
+			if _, err := os.Stat(outside); !os.IsNotExist(err) {
+				t.Errorf("%s was created outside of the root directory", outside)
+			}
+			if _, err := os.Stat(d.cfg.Root); err != nil {
+				t.Errorf("root directory is gone: %v", err)
+			}
+		})
+	}
+}
+
+func TestMkdirChown(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("chowning needs root")
+	}
+	for _, tc := range []struct {
+		spec     string
+		uid, gid int
+	}{
+		{"", os.Getuid(), os.Getgid()},
+		{"1000:1001", 1000, 1001},
+		{"1000:", 1000, os.Getgid()},
+		{":1001", os.Getuid(), 1001},
+	} {
+		d, err := New(Config{Root: t.TempDir(), MountConcurrency: 1, ChownMountpoint: tc.spec})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mnt := d.target("b")
+		if err := d.mkdir(mnt); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(mnt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		if int(st.Uid) != tc.uid || int(st.Gid) != tc.gid {
+			t.Errorf("%q: owner is %d:%d, want %d:%d", tc.spec, st.Uid, st.Gid, tc.uid, tc.gid)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	for _, tc := range []struct {
+		source string
+		names  []string
+	}{
+		{"memory", []string{"a", "b", "c/sub"}},
+		// Also shows leftovers of earlier runs, and buckets of subpaths.
+		{"filesystem", []string{"a", "b", "c", "old"}},
+	} {
+		t.Run(tc.source, func(t *testing.T) {
+			d := newTestDriver(t, Config{ListSource: tc.source}, &fakeRunner{})
+			for _, name := range []string{"b", "a", "c/sub"} {
+				mustCreate(t, d, name, nil)
+			}
+			for _, dir := range []string{"old", ".hidden"} {
+				if err := os.Mkdir(d.target(dir), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := ioutil.WriteFile(d.target("file"), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := d.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, v := range res.Volumes {
+				names = append(names, v.Name)
+				if v.Mountpoint != d.mountpoint(v.Name) {
+					t.Errorf("mountpoint of %s is %s, want %s", v.Name, v.Mountpoint, d.mountpoint(v.Name))
+				}
+			}
+			if !reflect.DeepEqual(names, tc.names) {
+				t.Errorf("got %q, want %q", names, tc.names)
+			}
+		})
+	}
+}
+
+// Volumes that share gcsfuse, but not their options, are told apart.
+func TestOptionDrift(t *testing.T) {
+	d := newTestDriver(t, Config{}, &fakeRunner{output: successLine})
+	mustCreate(t, d, "b", map[string]string{"comment": "first"})
+	mustCreate(t, d, "b/sub", map[string]string{"comment": "second"})
+	mustCreate(t, d, "gs://b", map[string]string{"comment": "first"})
+	before := optionDrift.get("bucket", "b")
+
+	for i, name := range []string{"b", "b/sub", "gs://b"} {
+		if _, err := d.Mount(&volume.MountRequest{Name: name, ID: fmt.Sprint(i)}); err != nil {
+			t.Fatalf("mounting %s: %s", name, err)
+		}
+	}
+	if n := optionDrift.get("bucket", "b") - before; n != 1 {
+		t.Errorf("counted %v volumes with other options, want 1", n)
+	}
+
+	for name, drift := range map[string]bool{"b": false, "b/sub": true, "gs://b": false} {
+		res, err := d.Get(&volume.GetRequest{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := res.Volume.Status
+		if s["options_hash"] == nil || (s["options_hash"] != s["mounted_options_hash"]) != drift {
+			t.Errorf("%s: options hash %v, mounted %v, want drift %t", name, s["options_hash"], s["mounted_options_hash"], drift)
+		}
+	}
+}
+
+// With cache_dir, the mount is released before gcsfuse is stopped, so
+// that staged writes are uploaded, and the cache is shown by Get.
+func TestCacheDir(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		cache    bool
+		unmounts string
+	}{
+		{"cache", true, "1\n"},
+		{"no cache", false, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			run := &fakeRunner{output: successLine}
+			d := newTestDriver(t, Config{}, run)
+			d.host = anyHost
+			dir := tools(t, `echo 1 >> "${0%/*}/calls"`, "fusermount")
+			d.unmount = []string{"fusermount", "-u"}
+
+			opts := map[string]string{}
+			cache := t.TempDir()
+			if tc.cache {
+				opts["cache_dir"] = cache
+			}
+			mustCreate(t, d, "b", opts)
+			if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"}); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := d.Get(&volume.GetRequest{Name: "b"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			status, ok := res.Volume.Status["cache"].(map[string]interface{})
+			if ok != tc.cache {
+				t.Fatalf("got cache status %v", res.Volume.Status["cache"])
+			}
+			if ok && (status["dir"] != cache || status["free_bytes"].(uint64) == 0) {
+				t.Errorf("got cache status %v", status)
+			}
+
+			if err := d.Unmount(&volume.UnmountRequest{Name: "b", ID: "1"}); err != nil {
+				t.Fatal(err)
+			}
+			b, _ := ioutil.ReadFile(dir + "/calls")
+			if string(b) != tc.unmounts {
+				t.Errorf("unmounted %q, want %q", b, tc.unmounts)
+			}
+			if len(run.signals) != 1 {
+				t.Errorf("signalled gcsfuse %d times, want once", len(run.signals))
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Delivery of an event is attempted that often, waiting twice as long
+// after each failure, starting with webhookBackoff.
+const (
+	webhookAttempts = 5
+	webhookBackoff  = time.Second
+)
+
+var (
+	errWebhookURL = errors.New("event webhook must be an http or https URL")
+
+	droppedEvents = newCounter("gcs_events_dropped_total", "Number of events that were not delivered to the webhook.")
+)
+
+// Event is the body of requests to Config.EventWebhook.
+type Event struct {
+	Time time.Time
+
+	// "mount" or "unmount", for requests of Docker, or "exit" if gcsfuse
+	// exited unexpectedly, see supervise.
+	Op string
+
+	// Name of the volume, if the event is about one, and the instance of
+	// gcsfuse that serves it, see key.
+	Volume string `json:",omitempty"`
+	Bucket string
+
+	// "ok" or "failed", in which case Error tells why.
+	Result string
+	Error  string `json:",omitempty"`
+
+	// How long the operation took, e.g. "1.5s".
+	Duration string `json:",omitempty"`
+}
+
+// webhook posts events as JSON to a URL in the background, so that mounts
+// do not wait for it. A nil webhook drops them.
+type webhook struct {
+	url    string
+	client *http.Client
+
+	events chan Event
+
+	// Closed to deliver the remaining events without retrying, after
+	// which done is closed.
+	stop, done chan struct{}
+}
+
+func newWebhook(u string) (*webhook, error) {
+	if u == "" {
+		return nil, nil
+	}
+	if p, err := url.Parse(u); err != nil || p.Host == "" || (p.Scheme != "http" && p.Scheme != "https") {
+		return nil, errWebhookURL
+	}
+	w := &webhook{
+		url:    u,
+		client: &http.Client{Timeout: 10 * time.Second},
+		events: make(chan Event, 256),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go w.deliver()
+	return w, nil
+}
+
+// emit queues an event about op on volume name, served by k, which took
+// that long, if known, and failed if err is set.
+func (w *webhook) emit(op, name, k string, took time.Duration, err error) {
+	if w == nil {
+		return
+	}
+	e := Event{Time: time.Now(), Op: op, Volume: name, Bucket: k, Result: "ok"}
+	if took > 0 {
+		e.Duration = took.String()
+	}
+	if err != nil {
+		e.Result, e.Error = "failed", err.Error()
+	}
+	select {
+	case w.events <- e:
+	default:
+		droppedEvents.add(1)
+	}
+}
+
+// shutdown delivers the remaining events, waiting at most timeout.
+func (w *webhook) shutdown(timeout time.Duration) {
+	if w == nil {
+		return
+	}
+	close(w.stop)
+	select {
+	case <-w.done:
+	case <-time.After(timeout):
+		warnf("Delivering events did not finish within %s, some of them might be lost.", timeout)
+	}
+}
+
+func (w *webhook) deliver() {
+	defer close(w.done)
+	for {
+		select {
+		case e := <-w.events:
+			w.post(e)
+		case <-w.stop:
+			for len(w.events) > 0 {
+				w.post(<-w.events)
+			}
+			return
+		}
+	}
+}
+
+// post delivers e, retrying with backoff until the driver shuts down.
+func (w *webhook) post(e Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		errorf("Encoding event failed: %s", err)
+		return
+	}
+
+	backoff := webhookBackoff
+	err = w.send(body)
+	for attempt := 1; err != nil && attempt < webhookAttempts; attempt++ {
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-w.stop:
+			// One last attempt.
+			attempt = webhookAttempts
+		}
+		err = w.send(body)
+	}
+	if err == nil {
+		return
+	}
+	warnf("Delivering %s event of %s to the webhook failed: %s", e.Op, e.Bucket, err)
+	droppedEvents.add(1)
+}
+
+type errWebhookStatus struct {
+	status string
+}
+
+func (e errWebhookStatus) Error() string {
+	return "webhook responded with " + e.status
+}
+
+func (w *webhook) send(body []byte) error {
+	r, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	if r.StatusCode/100 != 2 {
+		return errWebhookStatus{status: r.Status}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs_test
+
+import (
+	"log"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/lorenzleutgeb/docker-volume-gcs/gcs"
+)
+
+// Serving the driver from another program, like docker-volume-gcs does.
+func ExampleNew() {
+	d, err := gcs.New(gcs.Config{Root: "/mnt/gcs", MountConcurrency: 4})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer d.Shutdown()
+
+	h := volume.NewHandler(d)
+	d.Register(h)
+	log.Fatal(h.ServeUnix("gcs", 0))
+}
@@ -0,0 +1,76 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+var errNoExport = errors.New("volume sets nfs_export, but no export command is configured; start the plugin with -export-command")
+
+// checkExport makes sure that volumes with the given options can be
+// exported, see Config.ExportCommand.
+func (d Driver) checkExport(opts map[string]string) error {
+	if enabled(opts, "nfs_export") && d.cfg.ExportCommand == "" {
+		return errNoExport
+	}
+	return nil
+}
+
+// export runs Config.ExportCommand for b, which gcsfuse mounted at mnt,
+// and returns the first line of its output. It tells clients what to
+// mount, e.g. "host:/path".
+func (d Driver) export(b, mnt string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.cfg.HookTimeout)
+	defer cancel()
+
+	var out, errOut bytes.Buffer
+	cmd := exec.CommandContext(ctx, d.cfg.ExportCommand, b, mnt)
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	// Like for hooks, see runHook.
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return "", errHook{hook: d.cfg.ExportCommand, cause: err, output: errOut.Bytes()}
+	}
+
+	line := strings.TrimSpace(strings.SplitN(out.String(), "\n", 2)[0])
+	if line == "" {
+		line = mnt
+	}
+	infof("Exported %s as %s", b, line)
+	return line, nil
+}
+
+// unexport runs Config.UnexportCommand, if any, for b at mnt, before
+// gcsfuse is stopped. Failures are only logged.
+func (d Driver) unexport(b, mnt string) {
+	if d.cfg.UnexportCommand == "" {
+		return
+	}
+	if err := runHook(d.cfg.UnexportCommand, d.cfg.HookTimeout, b, mnt); err != nil {
+		errorf("Unexporting %s failed: %s", b, err)
+	}
+}
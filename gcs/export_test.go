@@ -0,0 +1,158 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestExport(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		body   string
+		export string
+		cause  error
+		output string
+	}{
+		{"first line", `echo "host:$2"; echo more`, "host:/mnt/b", nil, ""},
+		{"no output", "true", "/mnt/b", nil, ""},
+		{"failure", "echo out; echo nope >&2; exit 3", "", nil, "nope\n"},
+		{"timeout", "sleep 5", "", context.DeadlineExceeded, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hook := script(t, tc.body)
+			d := newTestDriver(t, Config{ExportCommand: hook}, &fakeRunner{})
+			d.cfg.HookTimeout = 200 * time.Millisecond
+			start := time.Now()
+			export, err := d.export("b", "/mnt/b")
+			if time.Since(start) > 2*time.Second {
+				t.Error("export command was not killed in time")
+			}
+			if export != tc.export {
+				t.Errorf("exported as %q, want %q", export, tc.export)
+			}
+			if tc.export != "" {
+				if err != nil {
+					t.Errorf("got %v", err)
+				}
+				return
+			}
+			e, ok := err.(errHook)
+			if !ok {
+				t.Fatalf("got %v, want errHook", err)
+			}
+			if e.hook != hook || string(e.output) != tc.output || (tc.cause != nil && e.cause != tc.cause) {
+				t.Errorf("got %#v", e)
+			}
+		})
+	}
+}
+
+func TestCheckExport(t *testing.T) {
+	for _, tc := range []struct {
+		command string
+		opts    map[string]string
+		err     error
+	}{
+		{"", nil, nil},
+		{"", map[string]string{"nfs_export": "false"}, nil},
+		{"", map[string]string{"nfs_export": "true"}, errNoExport},
+		{"/bin/export", map[string]string{"nfs_export": "true"}, nil},
+	} {
+		d := Driver{cfg: &Config{ExportCommand: tc.command}}
+		if err := d.checkExport(tc.opts); err != tc.err {
+			t.Errorf("command %q, options %v: got %v, want %v", tc.command, tc.opts, err, tc.err)
+		}
+	}
+}
+
+func TestMountExport(t *testing.T) {
+	export := script(t, `echo "host:$2"`)
+	unexport := script(t, `echo "$@" > "$(dirname "$0")/args"`)
+	d := newTestDriver(t, Config{ExportCommand: export, UnexportCommand: unexport}, &fakeRunner{output: successLine})
+	mustCreate(t, d, "b", map[string]string{"nfs_export": "true"})
+	if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := d.cmds["b"].export, "host:"+d.target("b"); got != want {
+		t.Errorf("exported as %q, want %q", got, want)
+	}
+
+	if err := d.Unmount(&volume.UnmountRequest{Name: "b", ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(filepath.Join(filepath.Dir(unexport), "args"))
+	if got, want := strings.TrimSpace(string(b)), "b "+d.target("b"); got != want {
+		t.Errorf("unexport command got %q, want %q", got, want)
+	}
+}
+
+// Remounts unexport the bucket before gcsfuse is stopped, and export it
+// again once the new one runs, if the options still say so.
+func TestRemountExport(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "log")
+	export := script(t, `echo "export $1" >> `+log+`; echo "host:$2"`)
+	unexport := script(t, `echo "unexport $1" >> `+log)
+	d := newTestDriver(t, Config{ExportCommand: export, UnexportCommand: unexport}, &fakeRunner{output: successLine})
+	mustCreate(t, d, "b", nil)
+	if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		opts   map[string]string
+		export string
+		log    string
+	}{
+		{"enable", map[string]string{"nfs_export": "true"}, "host:" + d.target("b"), "export b"},
+		{"keep", map[string]string{"nfs_export": "true"}, "host:" + d.target("b"), "export b,unexport b,export b"},
+		{"disable", nil, "", "export b,unexport b,export b,unexport b"},
+		{"stay off", map[string]string{"comment": "hi"}, "", "export b,unexport b,export b,unexport b"},
+	} {
+		if err := d.remount("b", tc.opts); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := d.cmds["b"].export; got != tc.export {
+			t.Errorf("%s: exported as %q, want %q", tc.name, got, tc.export)
+		}
+		b, _ := ioutil.ReadFile(log)
+		if got := strings.Replace(strings.TrimSpace(string(b)), "\n", ",", -1); got != tc.log {
+			t.Errorf("%s: ran %q, want %q", tc.name, got, tc.log)
+		}
+	}
+
+	d.cfg.ExportCommand = ""
+	if err := d.remount("b", map[string]string{"nfs_export": "true"}); err != errNoExport {
+		t.Errorf("got %v, want %v", err, errNoExport)
+	}
+}
+
+func TestCreateExport(t *testing.T) {
+	d := newTestDriver(t, Config{}, &fakeRunner{})
+	err := d.Create(&volume.CreateRequest{Name: "b", Options: map[string]string{"nfs_export": "true"}})
+	if err != errNoExport {
+		t.Errorf("got %v, want %v", err, errNoExport)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Refuse to start gcsfuse when this share of the limit on open files is
+// in use. It inherits the limit.
+const fdThreshold = 0.9
+
+type errFDLimit struct {
+	open  int
+	limit uint64
+}
+
+func (e errFDLimit) Error() string {
+	return fmt.Sprintf("limit on open files is approaching, %d of %d are open; raise it (see -raise-fd-limit) or unmount buckets", e.open, e.limit)
+}
+
+// fdLimit returns the soft and hard limit on open files.
+func fdLimit() (uint64, uint64, error) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, 0, err
+	}
+	return uint64(rl.Cur), uint64(rl.Max), nil
+}
+
+// checkFDs returns errFDLimit if open files come close to the limit. It
+// does not stand in the way if either can not be determined.
+func checkFDs() error {
+	soft, _, err := fdLimit()
+	if err != nil {
+		return nil
+	}
+	open, err := procs.openFiles()
+	if err != nil {
+		return nil
+	}
+	if float64(open) >= fdThreshold*float64(soft) {
+		return errFDLimit{open: open, limit: soft}
+	}
+	return nil
+}
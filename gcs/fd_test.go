@@ -0,0 +1,86 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// openProcs reports open files, and otherwise behaves like procs.
+type openProcs struct {
+	procInfo
+	open int
+	err  error
+}
+
+func (p openProcs) openFiles() (int, error) {
+	return p.open, p.err
+}
+
+func TestCheckFDs(t *testing.T) {
+	soft, _, err := fdLimit()
+	if err != nil || soft > 1<<30 {
+		t.Skipf("limit on open files is %d (%v)", soft, err)
+	}
+	near := int(fdThreshold * float64(soft))
+	if float64(near) < fdThreshold*float64(soft) {
+		near++
+	}
+
+	for _, tc := range []struct {
+		name string
+		open int
+		err  error
+		want error
+	}{
+		{"none", 0, nil, nil},
+		{"below", near - 1, nil, nil},
+		{"near", near, nil, errFDLimit{open: near, limit: soft}},
+		{"above", int(soft) + 1, nil, errFDLimit{open: int(soft) + 1, limit: soft}},
+		{"unknown", int(soft), errNotSupported, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			prev := procs
+			procs = openProcs{procInfo: prev, open: tc.open, err: tc.err}
+			defer func() { procs = prev }()
+
+			if err := checkFDs(); !reflect.DeepEqual(err, tc.want) {
+				t.Errorf("got %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestMountFDLimit(t *testing.T) {
+	prev := procs
+	procs = openProcs{procInfo: prev, open: int(^uint(0) >> 1)}
+	defer func() { procs = prev }()
+
+	run := &fakeRunner{output: successLine}
+	d := newTestDriver(t, Config{}, run)
+	mustCreate(t, d, "b", nil)
+	_, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"})
+	if _, ok := err.(errFDLimit); !ok {
+		t.Errorf("got %v, want errFDLimit", err)
+	}
+	if n := run.starts(); n != 0 {
+		t.Errorf("started gcsfuse %d times", n)
+	}
+}
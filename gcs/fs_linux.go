@@ -0,0 +1,57 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package gcs
+
+import (
+	"syscall"
+)
+
+// Device that gcsfuse talks to the kernel through, see checkFuse.
+var fuseDevice = "/dev/fuse"
+
+// Types of file systems by their magic number in statfs(2), which may not
+// host FUSE mounts reliably.
+var remoteFilesystems = map[int64]string{
+	0x6969:     "nfs",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x65735546: "fuse",
+	0x00c36400: "ceph",
+	0x01021997: "9p",
+	0x47504653: "gpfs",
+	0x0bd00bd0: "lustre",
+}
+
+// freeSpace returns how many bytes are available to unprivileged users on
+// the file system of path.
+func freeSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
+
+// remoteFilesystem returns the type of the file system that path is on, if
+// it is one of remoteFilesystems, or the empty string otherwise.
+func remoteFilesystem(path string) (string, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return "", err
+	}
+	return remoteFilesystems[int64(st.Type)&0xffffffff], nil
+}
@@ -0,0 +1,97 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package gcs
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckRoot(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name   string
+		root   string
+		strict bool
+	}{
+		{"local", dir, false},
+		{"local strict", dir, true},
+		{"proc", "/proc", true},
+		// Only logged, the root is created later.
+		{"missing", filepath.Join(dir, "missing"), true},
+	} {
+		if err := checkRoot(tc.root, tc.strict); err != nil {
+			t.Errorf("%s: got %v", tc.name, err)
+		}
+	}
+}
+
+// Remote file systems are only refused if the host happens to have one.
+func TestCheckRootRemote(t *testing.T) {
+	b, err := ioutil.ReadFile("/proc/mounts")
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		f := strings.Fields(line)
+		if len(f) < 3 {
+			continue
+		}
+		typ := strings.SplitN(f[2], ".", 2)[0]
+		for _, remote := range remoteFilesystems {
+			if typ != remote {
+				continue
+			}
+			root := unescapeMount(f[1])
+			fs, err := remoteFilesystem(root)
+			if err != nil {
+				// E.g. a FUSE mount of another user.
+				continue
+			}
+			if err := checkRoot(root, false); err != nil {
+				t.Errorf("%s: got %v", root, err)
+			}
+			if want := (errRemoteRoot{root: root, fs: fs}); checkRoot(root, true) != want {
+				t.Errorf("%s: not refused as %s", root, fs)
+			}
+			return
+		}
+	}
+	t.Skip("no remote file system mounted")
+}
+
+func TestRemoteFilesystem(t *testing.T) {
+	for _, path := range []string{t.TempDir(), "/proc"} {
+		if fs, err := remoteFilesystem(path); err != nil || fs != "" {
+			t.Errorf("%s: got %q, %v", path, fs, err)
+		}
+	}
+	if _, err := remoteFilesystem("/no/such/dir"); err == nil {
+		t.Error("statfs of a missing directory succeeded")
+	}
+}
+
+func TestFreeSpace(t *testing.T) {
+	if free, err := freeSpace(t.TempDir()); err != nil || free == 0 {
+		t.Errorf("got %d, %v, want some space", free, err)
+	}
+	if _, err := freeSpace(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("got space for a missing directory")
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd netbsd openbsd solaris
+
+package gcs
+
+// Device that gcsfuse talks to the kernel through, see checkFuse. Its
+// name differs between platforms and FUSE implementations, so it is not
+// checked.
+var fuseDevice = ""
+
+// freeSpace is only implemented for Linux, statfs differs between
+// platforms.
+func freeSpace(path string) (uint64, error) {
+	return 0, errNotSupported
+}
+
+// remoteFilesystem is only implemented for Linux, the types of file
+// systems are reported differently on each platform.
+func remoteFilesystem(path string) (string, error) {
+	return "", errNotSupported
+}
@@ -0,0 +1,119 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Configuration of fusermount, which needs user_allow_other for users
+// other than root to mount with allow_other or allow_root.
+var fuseConf = "/etc/fuse.conf"
+
+const allowOther = "user_allow_other"
+
+// checkFuseConf makes sure that fuse.conf permits allow_other or
+// allow_root, if args for gcsfuse ask for either. If it does not, that is
+// logged, or with manage the file is patched.
+func checkFuseConf(args []string, manage bool) error {
+	if !hasMountOption(args, "allow_other") && !hasMountOption(args, "allow_root") {
+		return nil
+	}
+
+	ok, err := allowsOther(fuseConf)
+	if err != nil || ok {
+		return err
+	}
+	if !manage {
+		warnf("%s lacks %s, mounting with allow_other or allow_root fails unless gcsfuse runs as root. Add it, or pass -manage-fuse-conf.", fuseConf, allowOther)
+		return nil
+	}
+	return patchFuseConf(fuseConf)
+}
+
+// allowsOther reports whether the fuse.conf at path enables
+// user_allow_other. A missing file does not.
+func allowsOther(path string) (bool, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if strings.TrimSpace(s.Text()) == allowOther {
+			return true, nil
+		}
+	}
+	return false, s.Err()
+}
+
+// patchFuseConf adds user_allow_other to the fuse.conf at path, unless it
+// is there already. The previous file is kept next to it with suffix
+// ".bak". Symbolic links are followed, and the file is replaced
+// atomically, so that fusermount never reads half of it.
+func patchFuseConf(path string) error {
+	if ok, err := allowsOther(path); err != nil || ok {
+		return err
+	}
+
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		path = p
+	}
+
+	var mode os.FileMode = 0644
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		infof("Creating %s with %s.", path, allowOther)
+	case err != nil:
+		return err
+	default:
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		mode = fi.Mode().Perm()
+		if err := ioutil.WriteFile(path+".bak", b, mode); err != nil {
+			return err
+		}
+		infof("Adding %s to %s, the previous version is kept as %s.bak.", allowOther, path, path)
+	}
+
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		b = append(b, '\n')
+	}
+	b = append(b, allowOther+"\n"...)
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, mode); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
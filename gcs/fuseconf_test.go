@@ -0,0 +1,148 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAllowsOther(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{"empty", "", false},
+		{"enabled", "# mount_max = 1000\nuser_allow_other\n", true},
+		{"indented", "  user_allow_other  ", true},
+		{"commented out", "#user_allow_other\n", false},
+		{"other option", "mount_max = 1000\n", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "fuse.conf")
+			if err := ioutil.WriteFile(path, []byte(tc.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := allowsOther(path); got != tc.want || err != nil {
+				t.Errorf("got %t, %v, want %t", got, err, tc.want)
+			}
+		})
+	}
+	if got, err := allowsOther(filepath.Join(t.TempDir(), "missing")); got || err != nil {
+		t.Errorf("missing file: got %t, %v", got, err)
+	}
+}
+
+func TestPatchFuseConf(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content *string
+		want    string
+		backup  *string
+	}{
+		{"missing", nil, "user_allow_other\n", nil},
+		{"empty", str(""), "user_allow_other\n", str("")},
+		{"appended", str("mount_max = 1000\n"), "mount_max = 1000\nuser_allow_other\n", str("mount_max = 1000\n")},
+		{"no trailing newline", str("mount_max = 1000"), "mount_max = 1000\nuser_allow_other\n", str("mount_max = 1000")},
+		{"already there", str("user_allow_other\n"), "user_allow_other\n", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "fuse.conf")
+			if tc.content != nil {
+				if err := ioutil.WriteFile(path, []byte(*tc.content), 0640); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := patchFuseConf(path); err != nil {
+				t.Fatal(err)
+			}
+			if b, _ := ioutil.ReadFile(path); string(b) != tc.want {
+				t.Errorf("got %q, want %q", b, tc.want)
+			}
+			b, err := ioutil.ReadFile(path + ".bak")
+			if tc.backup == nil {
+				if !os.IsNotExist(err) {
+					t.Errorf("kept a backup: %q, %v", b, err)
+				}
+			} else if string(b) != *tc.backup {
+				t.Errorf("backup is %q, want %q", b, *tc.backup)
+			}
+			if tc.content != nil {
+				if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0640 {
+					t.Errorf("mode changed: %v, %v", fi.Mode(), err)
+				}
+			}
+			if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+				t.Errorf("left the temporary file behind: %v", err)
+			}
+		})
+	}
+}
+
+// The target of a symbolic link is patched, the link stays.
+func TestPatchFuseConfSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target, link := filepath.Join(dir, "fuse.conf.real"), filepath.Join(dir, "fuse.conf")
+	if err := ioutil.WriteFile(target, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := patchFuseConf(link); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("link was replaced: %v", err)
+	}
+	if b, _ := ioutil.ReadFile(target); string(b) != "user_allow_other\n" {
+		t.Errorf("target is %q", b)
+	}
+}
+
+func TestCheckFuseConf(t *testing.T) {
+	prev := fuseConf
+	defer func() { fuseConf = prev }()
+	for _, tc := range []struct {
+		name    string
+		args    []string
+		manage  bool
+		patched bool
+	}{
+		{"not needed", []string{"b", "/mnt/b"}, true, false},
+		{"warned", []string{"-o", "allow_other", "b", "/mnt/b"}, false, false},
+		{"managed", []string{"-o", "allow_other", "b", "/mnt/b"}, true, true},
+		{"allow_root", []string{"-o", "allow_root", "b", "/mnt/b"}, true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fuseConf = filepath.Join(t.TempDir(), "fuse.conf")
+			if err := checkFuseConf(tc.args, tc.manage); err != nil {
+				t.Fatal(err)
+			}
+			if ok, _ := allowsOther(fuseConf); ok != tc.patched {
+				t.Errorf("patched %t, want %t", ok, tc.patched)
+			}
+		})
+	}
+}
+
+func str(s string) *string {
+	return &s
+}
@@ -0,0 +1,82 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Output of `gcsfuse --help`, see requireFlag.
+var gcsfuseHelp struct {
+	once sync.Once
+	text string
+}
+
+type errGcsfuseFlag struct {
+	option string
+	flag   string
+}
+
+func (e errGcsfuseFlag) Error() string {
+	return fmt.Sprintf("option %q requires a gcsfuse that supports --%s; upgrade gcsfuse", e.option, e.flag)
+}
+
+type errTeardownSignal struct {
+	signal string
+}
+
+func (e errTeardownSignal) Error() string {
+	return fmt.Sprintf("unknown teardown signal %q; use auto, SIGINT or SIGTERM", e.signal)
+}
+
+// Signals that Config.TeardownSignal may name.
+var teardownSignals = map[string]os.Signal{
+	"SIGINT":  os.Interrupt,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// hasFlag reports whether the installed gcsfuse knows flag.
+func hasFlag(flag string) bool {
+	gcsfuseHelp.once.Do(func() {
+		// gcsfuse might exit with an error after printing its usage.
+		out, _ := exec.Command("gcsfuse", "--help").CombinedOutput()
+		gcsfuseHelp.text = string(out)
+	})
+	return strings.Contains(gcsfuseHelp.text, "--"+flag)
+}
+
+// teardownSignal returns the signal named s, which stops gcsfuse. With
+// "auto", that is SIGTERM if gcsfuse knows --ignore-interrupts, since it
+// might ignore SIGINT then, and SIGINT otherwise.
+func teardownSignal(s string, ignoresInterrupts func() bool) (os.Signal, error) {
+	if s == "auto" {
+		if ignoresInterrupts() {
+			return syscall.SIGTERM, nil
+		}
+		return os.Interrupt, nil
+	}
+	sig, ok := teardownSignals[strings.ToUpper(s)]
+	if !ok {
+		return nil, errTeardownSignal{signal: s}
+	}
+	return sig, nil
+}
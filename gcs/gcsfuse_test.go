@@ -0,0 +1,75 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestTeardownSignal(t *testing.T) {
+	for _, tc := range []struct {
+		s       string
+		ignores bool
+		sig     os.Signal
+		err     error
+	}{
+		{"auto", false, os.Interrupt, nil},
+		{"auto", true, syscall.SIGTERM, nil},
+		{"SIGINT", true, os.Interrupt, nil},
+		{"SIGTERM", false, syscall.SIGTERM, nil},
+		{"sigterm", false, syscall.SIGTERM, nil},
+		{"SIGKILL", false, nil, errTeardownSignal{signal: "SIGKILL"}},
+		{"TERM", false, nil, errTeardownSignal{signal: "TERM"}},
+		{"", false, nil, errTeardownSignal{signal: ""}},
+	} {
+		sig, err := teardownSignal(tc.s, func() bool { return tc.ignores })
+		if sig != tc.sig || !reflect.DeepEqual(err, tc.err) {
+			t.Errorf("teardownSignal(%q) with ignores=%t = %v, %v, want %v, %v", tc.s, tc.ignores, sig, err, tc.sig, tc.err)
+		}
+	}
+}
+
+// gcsfuse is stopped with the configured signal.
+func TestStopSignal(t *testing.T) {
+	for _, tc := range []struct {
+		s   string
+		sig os.Signal
+	}{
+		{"SIGINT", os.Interrupt},
+		{"SIGTERM", syscall.SIGTERM},
+	} {
+		t.Run(tc.s, func(t *testing.T) {
+			run := &fakeRunner{output: successLine}
+			d := newTestDriver(t, Config{TeardownSignal: tc.s}, run)
+			mustCreate(t, d, "b", nil)
+			if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Unmount(&volume.UnmountRequest{Name: "b", ID: "1"}); err != nil {
+				t.Fatal(err)
+			}
+			if want := []os.Signal{tc.sig}; !reflect.DeepEqual(run.signals, want) {
+				t.Errorf("sent %v, want %v", run.signals, want)
+			}
+		})
+	}
+}
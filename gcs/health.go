@@ -0,0 +1,148 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+var errNoFuseDevice = errors.New("not a character device")
+
+type errFuse struct {
+	err error
+}
+
+func (e errFuse) Error() string {
+	return fmt.Sprintf("FUSE is not available: %s; load the fuse kernel module, or pass --device /dev/fuse to the container of the plugin", e.err)
+}
+
+// checkFuse makes sure that the FUSE device exists and can be opened,
+// without which every mount fails. It is only checked on Linux.
+func checkFuse() error {
+	if fuseDevice == "" {
+		return nil
+	}
+
+	fi, err := os.Stat(fuseDevice)
+	if err != nil {
+		return errFuse{err: err}
+	}
+	if fi.Mode()&os.ModeCharDevice == 0 {
+		return errFuse{err: &os.PathError{Op: "stat", Path: fuseDevice, Err: errNoFuseDevice}}
+	}
+
+	f, err := os.OpenFile(fuseDevice, os.O_RDWR, 0)
+	if err != nil {
+		return errFuse{err: err}
+	}
+	return f.Close()
+}
+
+// HealthResponse is the body of responses to /health?format=json.
+type HealthResponse struct {
+	// Whether the driver is able to mount, i.e. both gcsfuse and FUSE
+	// are available. Otherwise, Errors tell why not.
+	Healthy bool
+	Gcsfuse bool
+	Fuse    bool
+	Errors  []string
+
+	// Number of instances of gcsfuse that serve mounts, and of those that
+	// failed, see Config.KeepFailedMounts and Config.Relaunch.
+	Mounts       int
+	FailedMounts int
+
+	// State of the circuit breakers of buckets that failed to mount: one
+	// of "closed", "open" and "half-open". See Config.BreakerThreshold.
+	Breakers map[string]string
+}
+
+func (d Driver) health() HealthResponse {
+	res := HealthResponse{Gcsfuse: true, Fuse: true, Breakers: make(map[string]string)}
+	if _, err := exec.LookPath("gcsfuse"); err != nil {
+		res.Gcsfuse = false
+		res.Errors = append(res.Errors, "gcsfuse: "+err.Error())
+	}
+	if err := checkFuse(); err != nil {
+		res.Fuse = false
+		res.Errors = append(res.Errors, err.Error())
+	}
+	res.Healthy = res.Gcsfuse && res.Fuse
+
+	d.Lock()
+	defer d.Unlock()
+
+	for _, m := range d.cmds {
+		switch {
+		case m.err != nil || m.failed != "":
+			res.FailedMounts++
+		case m.proc != nil:
+			res.Mounts++
+		}
+	}
+	for k, b := range d.breakers {
+		switch {
+		case b.failures < d.cfg.BreakerThreshold:
+			res.Breakers[k] = "closed"
+		case time.Now().Before(b.until):
+			res.Breakers[k] = "open"
+		default:
+			res.Breakers[k] = "half-open"
+		}
+	}
+	return res
+}
+
+// serveHealth responds with 200 if the driver is able to mount, and with
+// 503 and the reasons otherwise (GET /health). With ?format=json, the
+// body is a HealthResponse instead of plain text, and the status code is
+// the same. As it names buckets, it requires credentials like other
+// administrative endpoints, see guard.
+func (d Driver) serveHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	res := d.health()
+	code := http.StatusOK
+	if !res.Healthy {
+		code = http.StatusServiceUnavailable
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		d.guard(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			json.NewEncoder(w).Encode(res)
+		})(w, r)
+		return
+	}
+
+	if !res.Healthy {
+		http.Error(w, strings.Join(res.Errors, "\n"), code)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,163 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// withFuseDevice makes checkFuse look at path.
+func withFuseDevice(t *testing.T, path string) {
+	prev := fuseDevice
+	fuseDevice = path
+	t.Cleanup(func() { fuseDevice = prev })
+}
+
+func TestCheckFuse(t *testing.T) {
+	if fuseDevice == "" {
+		t.Skip("FUSE is not checked on this platform")
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "fuse")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+	for _, tc := range []struct {
+		path string
+		err  error
+	}{
+		{"/dev/null", nil},
+		{file, errFuse{err: &os.PathError{Op: "stat", Path: file, Err: errNoFuseDevice}}},
+	} {
+		withFuseDevice(t, tc.path)
+		if err := checkFuse(); !reflect.DeepEqual(err, tc.err) {
+			t.Errorf("%s: got %v, want %v", tc.path, err, tc.err)
+		}
+	}
+
+	withFuseDevice(t, missing)
+	if err, ok := checkFuse().(errFuse); !ok || !os.IsNotExist(err.err) {
+		t.Errorf("%s: got %v, want it to be missing", missing, err)
+	}
+}
+
+func TestServeHealth(t *testing.T) {
+	if fuseDevice == "" {
+		t.Skip("FUSE is not checked on this platform")
+	}
+	for _, tc := range []struct {
+		name    string
+		gcsfuse bool
+		device  string
+		method  string
+		status  int
+		body    string
+	}{
+		{"healthy", true, "/dev/null", http.MethodGet, http.StatusOK, "ok\n"},
+		{"no gcsfuse", false, "/dev/null", http.MethodGet, http.StatusServiceUnavailable, "gcsfuse: exec: \"gcsfuse\": executable file not found in $PATH\n"},
+		{"no fuse", true, "/no/fuse", http.MethodGet, http.StatusServiceUnavailable, errFuse{err: &os.PathError{Op: "stat", Path: "/no/fuse", Err: syscall.ENOENT}}.Error() + "\n"},
+		{"post", true, "/dev/null", http.MethodPost, http.StatusMethodNotAllowed, "method not allowed\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newTestDriver(t, Config{}, &fakeRunner{})
+			var names []string
+			if tc.gcsfuse {
+				names = append(names, "gcsfuse")
+			}
+			tools(t, "exit 0", names...)
+			withFuseDevice(t, tc.device)
+
+			w := httptest.NewRecorder()
+			d.serveHealth(w, httptest.NewRequest(tc.method, "/health", nil))
+			if w.Code != tc.status || w.Body.String() != tc.body {
+				t.Errorf("got %d %q, want %d %q", w.Code, w.Body.String(), tc.status, tc.body)
+			}
+		})
+	}
+}
+
+func TestServeHealthJSON(t *testing.T) {
+	if fuseDevice == "" {
+		t.Skip("FUSE is not checked on this platform")
+	}
+	for _, tc := range []struct {
+		name    string
+		gcsfuse bool
+		token   string
+		status  int
+		want    HealthResponse
+	}{
+		{"healthy", true, "", http.StatusOK, HealthResponse{Healthy: true, Gcsfuse: true, Fuse: true}},
+		{"unhealthy", false, "", http.StatusServiceUnavailable, HealthResponse{Fuse: true, Errors: []string{"gcsfuse: exec: \"gcsfuse\": executable file not found in $PATH"}}},
+		{"unauthorized", true, "t", http.StatusUnauthorized, HealthResponse{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newTestDriver(t, Config{BreakerThreshold: 2}, &fakeRunner{})
+			d.token = tc.token
+			var names []string
+			if tc.gcsfuse {
+				names = append(names, "gcsfuse")
+			}
+			tools(t, "exit 0", names...)
+			withFuseDevice(t, "/dev/null")
+
+			// Mounts that are starting count for neither.
+			d.cmds["a"] = &mount{proc: &fakeProcess{}}
+			d.cmds["b"] = &mount{proc: &fakeProcess{}}
+			d.cmds["c"] = &mount{proc: &fakeProcess{}, failed: "oom"}
+			d.cmds["d"] = &mount{err: errors.New("no such bucket")}
+			d.cmds["e"] = &mount{}
+			d.breakers["c"] = &breaker{failures: 1}
+			d.breakers["d"] = &breaker{failures: 2, until: time.Now().Add(time.Hour)}
+			d.breakers["f"] = &breaker{failures: 3, until: time.Now().Add(-time.Second)}
+
+			w := httptest.NewRecorder()
+			d.serveHealth(w, httptest.NewRequest(http.MethodGet, "/health?format=json", nil))
+			if w.Code != tc.status {
+				t.Fatalf("got status %d, want %d", w.Code, tc.status)
+			}
+			if tc.status == http.StatusUnauthorized {
+				return
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("got Content-Type %q", ct)
+			}
+
+			var got HealthResponse
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			want := tc.want
+			want.Mounts, want.FailedMounts = 2, 2
+			want.Breakers = map[string]string{"c": "closed", "d": "open", "f": "half-open"}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
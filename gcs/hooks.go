@@ -0,0 +1,87 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// How long hooks may run if Config.HookTimeout is not set.
+const defaultHookTimeout = 30 * time.Second
+
+type errHook struct {
+	hook   string
+	cause  error
+	output []byte
+}
+
+func (e errHook) Error() string {
+	return fmt.Sprintf("hook %s failed: %s, its output was %q", e.hook, e.cause, bytes.TrimSpace(e.output))
+}
+
+// runHook runs the executable hook with the bucket and mountpoint as
+// arguments, and kills it after timeout.
+func runHook(hook string, timeout time.Duration, b, mnt string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, hook, b, mnt)
+	// Children of the hook might keep its output open after it was killed.
+	cmd.WaitDelay = time.Second
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return errHook{hook: hook, cause: err, output: out}
+	}
+	return nil
+}
+
+// postMount runs Config.PostMountHook, if any, once gcsfuse mounted b at
+// mnt. Failures only count if the hook is not optional.
+func (d Driver) postMount(b, mnt string) error {
+	if d.cfg.PostMountHook == "" {
+		return nil
+	}
+
+	err := runHook(d.cfg.PostMountHook, d.cfg.HookTimeout, b, mnt)
+	if err != nil && d.cfg.PostMountHookOptional {
+		warnf("Ignoring failure of optional post-mount hook: %s", err)
+		return nil
+	}
+	return err
+}
+
+// preUnmount runs Config.PreUnmountHook, if any, before gcsfuse that
+// mounted b at mnt is stopped. Failures only count if the hook is required.
+func (d Driver) preUnmount(b, mnt string) error {
+	if d.cfg.PreUnmountHook == "" {
+		return nil
+	}
+
+	err := runHook(d.cfg.PreUnmountHook, d.cfg.HookTimeout, b, mnt)
+	if err != nil && !d.cfg.PreUnmountHookRequired {
+		warnf("Ignoring failure of pre-unmount hook: %s", err)
+		return nil
+	}
+	return err
+}
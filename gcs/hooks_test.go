@@ -0,0 +1,179 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// script writes an executable shell script with body, and returns its
+// path.
+func script(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hook")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunHook(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		body   string
+		failed bool
+		cause  error
+		output string
+	}{
+		{"success", `test "$1 $2" = "b /mnt/b"`, false, nil, ""},
+		{"failure", "echo nope; exit 3", true, nil, "nope\n"},
+		{"timeout", "sleep 5", true, context.DeadlineExceeded, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hook := script(t, tc.body)
+			start := time.Now()
+			err := runHook(hook, 200*time.Millisecond, "b", "/mnt/b")
+			if time.Since(start) > 2*time.Second {
+				t.Error("hook was not killed in time")
+			}
+			if !tc.failed {
+				if err != nil {
+					t.Errorf("got %v", err)
+				}
+				return
+			}
+			e, ok := err.(errHook)
+			if !ok {
+				t.Fatalf("got %v, want errHook", err)
+			}
+			if e.hook != hook || string(e.output) != tc.output || (tc.cause != nil && e.cause != tc.cause) {
+				t.Errorf("got %#v", e)
+			}
+		})
+	}
+}
+
+func TestPostMountHook(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		body     string
+		optional bool
+		mounted  bool
+	}{
+		{"success", `echo "$@" > "$(dirname "$0")/args"`, false, true},
+		{"failure", "exit 1", false, false},
+		{"optional failure", "exit 1", true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hook := script(t, tc.body)
+			d := newTestDriver(t, Config{PostMountHook: hook, PostMountHookOptional: tc.optional}, &fakeRunner{output: successLine})
+			mustCreate(t, d, "b", nil)
+			_, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"})
+			if tc.mounted != (err == nil) {
+				t.Fatalf("got %v, want mounted %t", err, tc.mounted)
+			}
+			if !tc.mounted {
+				if _, ok := err.(errHook); !ok {
+					t.Errorf("got %v, want errHook", err)
+				}
+				if m, ok := d.cmds["b"]; ok && m.proc != nil && m.proc.alive() {
+					t.Error("gcsfuse is still running")
+				}
+				return
+			}
+			if tc.name == "success" {
+				b, _ := ioutil.ReadFile(filepath.Join(filepath.Dir(hook), "args"))
+				if got, want := strings.TrimSpace(string(b)), "b "+d.target("b"); got != want {
+					t.Errorf("hook got %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPreUnmountHook(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		body     string
+		required bool
+		stopped  bool
+	}{
+		{"success", `echo "$@" > "$(dirname "$0")/args"`, false, true},
+		{"failure", "exit 1", false, true},
+		{"required failure", "exit 1", true, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hook := script(t, tc.body)
+			d := newTestDriver(t, Config{PreUnmountHook: hook, PreUnmountHookRequired: tc.required}, &fakeRunner{output: successLine})
+			mustCreate(t, d, "b", nil)
+			if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"}); err != nil {
+				t.Fatal(err)
+			}
+			proc := d.cmds["b"].proc
+			err := d.Unmount(&volume.UnmountRequest{Name: "b", ID: "1"})
+			if tc.stopped != (err == nil) {
+				t.Fatalf("got %v, want stopped %t", err, tc.stopped)
+			}
+			if proc.alive() == tc.stopped {
+				t.Errorf("gcsfuse alive is %t, want %t", proc.alive(), !tc.stopped)
+			}
+			if tc.name == "success" {
+				b, _ := ioutil.ReadFile(filepath.Join(filepath.Dir(hook), "args"))
+				if got, want := strings.TrimSpace(string(b)), "b "+d.target("b"); got != want {
+					t.Errorf("hook got %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+// Other buckets can be mounted while the hook runs.
+func TestPreUnmountHookUnlocked(t *testing.T) {
+	hook := script(t, `while [ ! -e "$(dirname "$0")/go" ]; do sleep 0.05; done`)
+	d := newTestDriver(t, Config{PreUnmountHook: hook}, &fakeRunner{output: successLine})
+	for _, b := range []string{"a", "b"} {
+		mustCreate(t, d, b, nil)
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "a", ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() { done <- d.Unmount(&volume.UnmountRequest{Name: "a", ID: "1"}) }()
+	if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"}); err != nil {
+		t.Error(err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("unmounted before the hook was done: %v", err)
+	default:
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(filepath.Dir(hook), "go"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
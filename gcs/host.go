@@ -0,0 +1,50 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+// host answers what options of volumes depend on besides their syntax:
+// accounts, the installed gcsfuse and the kernel. See ValidateOptions.
+type host struct {
+	// Resolve names of users and groups to numeric ids, see lookupUser.
+	lookupUser, lookupGroup func(name string) (string, error)
+
+	// Tells whether gcsfuse knows a flag, see hasFlag.
+	hasFlag func(flag string) bool
+
+	// Tells whether cgroup v2 is available, see limitMemory.
+	cgroup2 func() bool
+}
+
+// The host that the driver runs on.
+var localHost = host{lookupUser: lookupUser, lookupGroup: lookupGroup, hasFlag: hasFlag, cgroup2: cgroup2}
+
+// A host that has everything, to check options without looking around.
+// Names of users and groups are left as they are.
+var anyHost = host{
+	lookupUser:  func(name string) (string, error) { return name, nil },
+	lookupGroup: func(name string) (string, error) { return name, nil },
+	hasFlag:     func(string) bool { return true },
+	cgroup2:     func() bool { return true },
+}
+
+// requireFlag checks that the installed gcsfuse knows flag, for option.
+func (h host) requireFlag(option, flag string) error {
+	if !h.hasFlag(flag) {
+		return errGcsfuseFlag{option: option, flag: flag}
+	}
+	return nil
+}
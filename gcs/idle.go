@@ -0,0 +1,74 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"sort"
+	"time"
+)
+
+// evictIdle periodically stops gcsfuse for buckets that have not been used
+// for longer than Config.IdleTimeout, and for the least recently used ones
+// beyond Config.MaxIdleMounts. They are mounted again on demand.
+func (d Driver) evictIdle() {
+	interval := d.cfg.IdleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	for range time.Tick(interval) {
+		d.evict()
+	}
+}
+
+// evict stops gcsfuse for buckets that are unused for too long, or in
+// excess, once.
+func (d Driver) evict() {
+	d.Lock()
+	defer d.Unlock()
+
+	var idle []string
+	for b, m := range d.cmds {
+		if m.proc != nil && len(m.refs) == 0 {
+			idle = append(idle, b)
+		}
+	}
+
+	// Least recently used first.
+	sort.Slice(idle, func(i, j int) bool {
+		return d.cmds[idle[i]].lastUsed.Before(d.cmds[idle[j]].lastUsed)
+	})
+
+	for i, b := range idle {
+		// Stopping releases the lock, others might have been mounted
+		// or stopped meanwhile.
+		m, ok := d.cmds[b]
+		if !ok || m.proc == nil || len(m.refs) > 0 {
+			continue
+		}
+		expired := time.Since(m.lastUsed) > d.cfg.IdleTimeout
+		excess := d.cfg.MaxIdleMounts > 0 && len(idle)-i > d.cfg.MaxIdleMounts
+		if !expired && !excess {
+			continue
+		}
+
+		infof("Evicting %s, unused since %s", b, m.lastUsed.Format(time.RFC3339))
+		if err := d.stop(b); err != nil {
+			errorf("Evicting %s failed: %s", b, err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+var lockHeld = newCounter("gcs_lock_held_too_long_total", "Number of times the lock of the driver was held for longer than the threshold, by the function that held it.")
+
+// lock is the mutex of the driver. With a threshold, it logs a warning
+// whenever it was held for longer than that, naming the function that
+// acquired it. See Config.LockWarnThreshold.
+type lock struct {
+	sync.Mutex
+	threshold time.Duration
+
+	// When the lock was acquired, and by which function.
+	since  time.Time
+	holder string
+}
+
+func (l *lock) Lock() {
+	l.Mutex.Lock()
+	if l.threshold <= 0 {
+		return
+	}
+	l.since = time.Now()
+	l.holder = "unknown"
+	if pc, _, _, ok := runtime.Caller(1); ok {
+		if f := runtime.FuncForPC(pc); f != nil {
+			l.holder = f.Name()[strings.LastIndex(f.Name(), "/")+1:]
+		}
+	}
+}
+
+func (l *lock) Unlock() {
+	if l.threshold <= 0 {
+		l.Mutex.Unlock()
+		return
+	}
+	held, holder := time.Since(l.since), l.holder
+	l.Mutex.Unlock()
+
+	if held > l.threshold {
+		lockHeld.add(1, "holder", holder)
+		warnf("%s held the lock of the driver for %s, other requests had to wait.", holder, held.Round(time.Millisecond))
+	}
+}
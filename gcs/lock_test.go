@@ -0,0 +1,66 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// holdLock holds l for d.
+func holdLock(l *lock, d time.Duration) {
+	l.Lock()
+	time.Sleep(d)
+	l.Unlock()
+}
+
+func TestLock(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	for _, tc := range []struct {
+		name      string
+		threshold time.Duration
+		held      time.Duration
+		warned    bool
+	}{
+		{"disabled", 0, 20 * time.Millisecond, false},
+		{"short", time.Second, 0, false},
+		{"long", 5 * time.Millisecond, 20 * time.Millisecond, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			buf.Reset()
+			before := lockHeld.get("holder", "gcs.holdLock")
+
+			holdLock(&lock{threshold: tc.threshold}, tc.held)
+
+			warned := strings.Contains(buf.String(), "gcs.holdLock held the lock of the driver for")
+			if warned != tc.warned {
+				t.Errorf("warned %v, want %v: %q", warned, tc.warned, buf.String())
+			}
+			n := lockHeld.get("holder", "gcs.holdLock") - before
+			if want := map[bool]float64{true: 1}[tc.warned]; n != want {
+				t.Errorf("counted %v, want %v", n, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"fmt"
+	"log"
+)
+
+type level int
+
+const (
+	levelDebug level = iota
+	levelInfo
+	levelWarning
+	levelError
+)
+
+// Levels that the driver logs at, by name. See SetLogLevel.
+var logLevels = map[string]level{
+	"debug":   levelDebug,
+	"info":    levelInfo,
+	"warning": levelWarning,
+	"error":   levelError,
+}
+
+func (l level) String() string {
+	for name, other := range logLevels {
+		if other == l {
+			return name
+		}
+	}
+	return ""
+}
+
+// Messages below this level are dropped.
+var logLevel = levelInfo
+
+type errLogLevel struct {
+	level string
+}
+
+func (e errLogLevel) Error() string {
+	return fmt.Sprintf("unknown log level %q", e.level)
+}
+
+// SetLogLevel drops messages of the driver below level, one of debug,
+// info (the default), warning or error. It does not affect gcsfuse, see
+// Config.GcsfuseLogLevel, and must be called before New.
+func SetLogLevel(level string) error {
+	l, ok := logLevels[level]
+	if !ok {
+		return errLogLevel{level: level}
+	}
+	logLevel = l
+	return nil
+}
+
+func logf(l level, format string, v ...interface{}) {
+	if l >= logLevel {
+		log.Output(3, fmt.Sprintf(format, v...))
+	}
+}
+
+func debugf(format string, v ...interface{}) { logf(levelDebug, format, v...) }
+func infof(format string, v ...interface{})  { logf(levelInfo, format, v...) }
+func warnf(format string, v ...interface{})  { logf(levelWarning, format, v...) }
+func errorf(format string, v ...interface{}) { logf(levelError, format, v...) }
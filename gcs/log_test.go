@@ -0,0 +1,75 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSetLogLevel(t *testing.T) {
+	var buf bytes.Buffer
+	flags := log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+		logLevel = levelInfo
+	}()
+
+	for _, tc := range []struct {
+		level  string
+		logged []string
+		err    error
+	}{
+		{"debug", []string{"d", "i", "w", "e"}, nil},
+		{"info", []string{"i", "w", "e"}, nil},
+		{"warning", []string{"w", "e"}, nil},
+		{"error", []string{"e"}, nil},
+		{"loud", nil, errLogLevel{level: "loud"}},
+	} {
+		t.Run(tc.level, func(t *testing.T) {
+			logLevel = levelInfo
+			if err := SetLogLevel(tc.level); err != tc.err {
+				t.Fatalf("got %v, want %v", err, tc.err)
+			}
+			if tc.err != nil {
+				if logLevel != levelInfo {
+					t.Errorf("level changed to %s", logLevel)
+				}
+				return
+			}
+			if logLevel.String() != tc.level {
+				t.Errorf("level is %s", logLevel)
+			}
+
+			buf.Reset()
+			debugf("d")
+			infof("i")
+			warnf("w")
+			errorf("e")
+			if got := strings.Fields(buf.String()); !reflect.DeepEqual(got, tc.logged) {
+				t.Errorf("logged %q, want %q", got, tc.logged)
+			}
+		})
+	}
+}
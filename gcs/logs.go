@@ -0,0 +1,131 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Number of lines of output that are kept per instance of gcsfuse, and how
+// long each may be, see serveLogs.
+const (
+	logLines   = 100
+	logLineLen = 1024
+)
+
+// ring keeps the last logLines lines that were written to it.
+type ring struct {
+	*sync.Mutex
+	lines []string
+
+	// Index of the oldest line, once there are logLines.
+	next int
+
+	// The last line, as long as it is not terminated.
+	partial []byte
+}
+
+func newRing() *ring {
+	return &ring{Mutex: new(sync.Mutex)}
+}
+
+func (r *ring) Write(p []byte) (int, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i == -1 {
+			r.partial = appendLine(r.partial, p)
+			break
+		}
+		r.add(string(appendLine(r.partial, p[:i])))
+		r.partial = r.partial[:0]
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+func (r *ring) add(line string) {
+	if len(r.lines) < logLines {
+		r.lines = append(r.lines, line)
+		return
+	}
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % logLines
+}
+
+// get returns the lines, oldest first.
+func (r *ring) get() []string {
+	r.Lock()
+	defer r.Unlock()
+
+	lines := append(append([]string{}, r.lines[r.next:]...), r.lines[:r.next]...)
+	if len(r.partial) > 0 {
+		lines = append(lines, string(r.partial))
+	}
+	return lines
+}
+
+// appendLine appends p to line, but not beyond logLineLen.
+func appendLine(line, p []byte) []byte {
+	if room := logLineLen - len(line); len(p) > room {
+		p = p[:room]
+	}
+	return append(line, p...)
+}
+
+// logBuffer returns the buffer for the output of gcsfuse for k. The caller
+// must hold the lock.
+func (d Driver) logBuffer(k string) *ring {
+	l, ok := d.logs[k]
+	if !ok {
+		l = newRing()
+		d.logs[k] = l
+	}
+	return l
+}
+
+// serveLogs returns the last lines that gcsfuse printed for a bucket, or
+// for the subpath of volumes with only_dir (GET /logs/<bucket>). They are
+// kept until the last volume of the bucket is removed.
+func (d Driver) serveLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	k := strings.TrimPrefix(normalize(strings.TrimPrefix(r.URL.Path, "/logs/")), scheme)
+
+	d.Lock()
+	l, ok := d.logs[k]
+	d.Unlock()
+	if !ok {
+		http.Error(w, "gcsfuse was not started for this bucket", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, line := range l.get() {
+		fmt.Fprintln(w, line)
+	}
+}
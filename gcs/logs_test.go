@@ -0,0 +1,107 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestRing(t *testing.T) {
+	var many []string
+	for i := 0; i < logLines+5; i++ {
+		many = append(many, fmt.Sprintf("line %d\n", i))
+	}
+	var last []string
+	for _, l := range many[5:] {
+		last = append(last, strings.TrimSuffix(l, "\n"))
+	}
+	long := strings.Repeat("x", logLineLen+10)
+
+	for _, tc := range []struct {
+		name   string
+		writes []string
+		lines  []string
+	}{
+		{"empty", nil, []string{}},
+		{"one line", []string{"hello\n"}, []string{"hello"}},
+		{"split", []string{"hel", "lo\nwor", "ld\n"}, []string{"hello", "world"}},
+		{"partial", []string{"a\nb"}, []string{"a", "b"}},
+		{"empty lines", []string{"\n\na\n"}, []string{"", "", "a"}},
+		{"wraps around", many, last},
+		{"long line", []string{long + "\n"}, []string{long[:logLineLen]}},
+		{"long line in parts", []string{long[:logLineLen-1], long[logLineLen-1:], "\nnext\n"}, []string{long[:logLineLen], "next"}},
+		{"long partial", []string{long}, []string{long[:logLineLen]}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newRing()
+			for _, w := range tc.writes {
+				if n, err := r.Write([]byte(w)); n != len(w) || err != nil {
+					t.Fatalf("wrote %d of %d bytes: %v", n, len(w), err)
+				}
+			}
+			if got := r.get(); !reflect.DeepEqual(got, tc.lines) {
+				t.Errorf("got %q, want %q", got, tc.lines)
+			}
+		})
+	}
+}
+
+func TestServeLogs(t *testing.T) {
+	d := newTestDriver(t, Config{}, &fakeRunner{output: "starting\n" + successLine})
+	mustCreate(t, d, "b", nil)
+	if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		method, path string
+		status       int
+		body         string
+	}{
+		{http.MethodGet, "/logs/b", http.StatusOK, "starting\n" + successLine},
+		{http.MethodGet, "/logs/gs://b", http.StatusOK, "starting\n" + successLine},
+		{http.MethodGet, "/logs/other", http.StatusNotFound, "gcsfuse was not started for this bucket\n"},
+		{http.MethodPost, "/logs/b", http.StatusMethodNotAllowed, "method not allowed\n"},
+	} {
+		w := httptest.NewRecorder()
+		d.serveLogs(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.status || w.Body.String() != tc.body {
+			t.Errorf("%s %s: got %d %q, want %d %q", tc.method, tc.path, w.Code, w.Body.String(), tc.status, tc.body)
+		}
+	}
+
+	// Logs are kept until the bucket is removed.
+	if err := d.Unmount(&volume.UnmountRequest{Name: "b", ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.logs["b"]; !ok {
+		t.Error("logs are gone after unmounting")
+	}
+	if err := d.Remove(&volume.RemoveRequest{Name: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.logs["b"]; ok {
+		t.Error("logs are kept after removing")
+	}
+}
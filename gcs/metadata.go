@@ -0,0 +1,87 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Comments that hold metadata start with this, followed by the metadata
+// as JSON, encoded as unpadded base64url. That survives the list of mount
+// options and /proc/mounts, see sanitizeComment.
+const metadataPrefix = "json:"
+
+// Encoded metadata larger than that is rejected, all mount options have
+// to fit into a page.
+const maxMetadataSize = 512
+
+var (
+	errNoMetadata    = errors.New("comment does not hold metadata")
+	errMetadataSize  = fmt.Errorf("metadata takes up more than %d bytes when encoded", maxMetadataSize)
+	errMetadataValue = errors.New("want a JSON object with string values, e.g. {\"purpose\": \"logs\"}")
+)
+
+// encodeMetadata turns m into a comment, see metadataPrefix. Keys are
+// sorted, so that equal metadata is encoded equally.
+func encodeMetadata(m map[string]string) (string, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	s := metadataPrefix + base64.RawURLEncoding.EncodeToString(b)
+	if len(s) > maxMetadataSize {
+		return "", errMetadataSize
+	}
+	return s, nil
+}
+
+// decodeMetadata is the inverse of encodeMetadata.
+func decodeMetadata(comment string) (map[string]string, error) {
+	if !strings.HasPrefix(comment, metadataPrefix) {
+		return nil, errNoMetadata
+	}
+	b, err := base64.RawURLEncoding.DecodeString(comment[len(metadataPrefix):])
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// parseMetadata parses the value of the option metadata, and returns it
+// encoded.
+func parseMetadata(v string) (string, error) {
+	var m map[string]string
+	if err := json.Unmarshal([]byte(v), &m); err != nil || m == nil {
+		return "", errMetadataValue
+	}
+	return encodeMetadata(m)
+}
+
+func isMetadata(v string) string {
+	if _, err := parseMetadata(v); err != nil {
+		return err.Error()
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMetadata(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		m    map[string]string
+	}{
+		{"empty", map[string]string{}},
+		{"one", map[string]string{"purpose": "logs"}},
+		{"several", map[string]string{"team": "data", "purpose": "logs", "owner": "a@b.c"}},
+		// These would break the list of mount options if not encoded.
+		{"special characters", map[string]string{"a,b": "c=d", "path": "/x y\n", "ü": "\\"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := encodeMetadata(tc.m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(c, metadataPrefix) || sanitizeComment(c) != c {
+				t.Errorf("comment %q does not survive as a mount option", c)
+			}
+			got, err := decodeMetadata(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.m) {
+				t.Errorf("decoded %v, want %v", got, tc.m)
+			}
+		})
+	}
+}
+
+// Equal metadata is encoded equally, regardless of how it was written.
+func TestParseMetadata(t *testing.T) {
+	want, _ := encodeMetadata(map[string]string{"a": "1", "b": "2"})
+	for _, tc := range []struct {
+		v       string
+		comment string
+		err     error
+	}{
+		{`{"a":"1","b":"2"}`, want, nil},
+		{`{ "b": "2", "a": "1" }`, want, nil},
+		{`{}`, metadataPrefix + "e30", nil},
+		{`null`, "", errMetadataValue},
+		{`{"a":1}`, "", errMetadataValue},
+		{`{"a":{"b":"c"}}`, "", errMetadataValue},
+		{`["a"]`, "", errMetadataValue},
+		{`{"a":"1"`, "", errMetadataValue},
+		{`{"a":"` + strings.Repeat("x", maxMetadataSize) + `"}`, "", errMetadataSize},
+	} {
+		c, err := parseMetadata(tc.v)
+		if c != tc.comment || err != tc.err {
+			t.Errorf("parseMetadata(%.40s) = %q, %v, want %q, %v", tc.v, c, err, tc.comment, tc.err)
+		}
+	}
+}
+
+func TestDecodeMetadata(t *testing.T) {
+	for _, c := range []string{"", "logs", "json", metadataPrefix + "!!!", metadataPrefix + "e30=", metadataPrefix + "WyJhIl0"} {
+		if m, err := decodeMetadata(c); err == nil {
+			t.Errorf("decodeMetadata(%q) = %v, want an error", c, m)
+		}
+	}
+	if _, err := decodeMetadata("logs"); err != errNoMetadata {
+		t.Errorf("plain comment: got %v, want %v", err, errNoMetadata)
+	}
+}
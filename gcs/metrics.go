@@ -0,0 +1,137 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metric is a family of values that share a name and differ by labels.
+// It is exported in the Prometheus text format.
+type metric struct {
+	name string
+	help string
+	kind string
+
+	// Maps rendered labels, e.g. `bucket="foo"`, to the value.
+	values map[string]float64
+}
+
+var metrics = struct {
+	*sync.Mutex
+	all []*metric
+
+	// Labels that are added to all values, see SetCommonLabels.
+	common string
+}{Mutex: new(sync.Mutex)}
+
+// SetCommonLabels sets labels that are added to all values.
+func SetCommonLabels(kv ...string) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	metrics.common = labels(kv)
+}
+
+func newMetric(kind, name, help string) *metric {
+	m := &metric{name: name, help: help, kind: kind, values: make(map[string]float64)}
+	metrics.Lock()
+	defer metrics.Unlock()
+	metrics.all = append(metrics.all, m)
+	return m
+}
+
+func newGauge(name, help string) *metric {
+	return newMetric("gauge", name, help)
+}
+
+func newCounter(name, help string) *metric {
+	return newMetric("counter", name, help)
+}
+
+// labels renders pairs of label names and values.
+func labels(kv []string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", kv[i], kv[i+1])
+	}
+	return b.String()
+}
+
+func (m *metric) set(v float64, kv ...string) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	m.values[labels(kv)] = v
+}
+
+func (m *metric) add(v float64, kv ...string) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	m.values[labels(kv)] += v
+}
+
+func (m *metric) get(kv ...string) float64 {
+	metrics.Lock()
+	defer metrics.Unlock()
+	return m.values[labels(kv)]
+}
+
+func (m *metric) delete(kv ...string) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	delete(m.values, labels(kv))
+}
+
+func writeMetrics(w io.Writer) {
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	for _, m := range metrics.all {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		keys := make([]string, 0, len(m.values))
+		for k := range m.values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			l := k
+			if metrics.common != "" && l != "" {
+				l = metrics.common + "," + l
+			} else if l == "" {
+				l = metrics.common
+			}
+
+			if l == "" {
+				fmt.Fprintf(w, "%s %g\n", m.name, m.values[k])
+			} else {
+				fmt.Fprintf(w, "%s{%s} %g\n", m.name, l, m.values[k])
+			}
+		}
+	}
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(w)
+}
@@ -0,0 +1,105 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// isolate makes the metrics that the test creates the only ones, until it
+// is done.
+func isolate(t *testing.T) {
+	metrics.Lock()
+	all, common := metrics.all, metrics.common
+	metrics.all, metrics.common = nil, ""
+	metrics.Unlock()
+	t.Cleanup(func() {
+		metrics.Lock()
+		metrics.all, metrics.common = all, common
+		metrics.Unlock()
+	})
+}
+
+func TestLabels(t *testing.T) {
+	for _, tc := range []struct {
+		kv   []string
+		want string
+	}{
+		{nil, ""},
+		{[]string{"bucket", "b"}, `bucket="b"`},
+		{[]string{"bucket", "b", "access", "ro"}, `bucket="b",access="ro"`},
+		{[]string{"bucket", `a"b\c`}, `bucket="a\"b\\c"`},
+		// Without a value, the name is dropped.
+		{[]string{"bucket", "b", "access"}, `bucket="b"`},
+	} {
+		if got := labels(tc.kv); got != tc.want {
+			t.Errorf("labels(%q) = %s, want %s", tc.kv, got, tc.want)
+		}
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		common []string
+		want   string
+	}{
+		{"plain", nil, `# HELP gcs_test_mounts Mounts.
+# TYPE gcs_test_mounts gauge
+gcs_test_mounts 2
+gcs_test_mounts{bucket="a"} 1.5
+gcs_test_mounts{bucket="b"} 3
+# HELP gcs_test_errors_total Errors.
+# TYPE gcs_test_errors_total counter
+`},
+		{"common labels", []string{"instance", "h1"}, `# HELP gcs_test_mounts Mounts.
+# TYPE gcs_test_mounts gauge
+gcs_test_mounts{instance="h1"} 2
+gcs_test_mounts{instance="h1",bucket="a"} 1.5
+gcs_test_mounts{instance="h1",bucket="b"} 3
+# HELP gcs_test_errors_total Errors.
+# TYPE gcs_test_errors_total counter
+`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			isolate(t)
+			SetCommonLabels(tc.common...)
+			g := newGauge("gcs_test_mounts", "Mounts.")
+			c := newCounter("gcs_test_errors_total", "Errors.")
+			g.set(3, "bucket", "b")
+			g.set(1, "bucket", "a")
+			g.add(0.5, "bucket", "a")
+			g.set(2)
+			c.add(1, "bucket", "a")
+			c.delete("bucket", "a")
+
+			w := httptest.NewRecorder()
+			serveMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			if got := w.Body.String(); got != tc.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tc.want)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4" {
+				t.Errorf("got content type %s", ct)
+			}
+			if v := g.get("bucket", "a"); v != 1.5 {
+				t.Errorf("got %g for bucket a, want 1.5", v)
+			}
+		})
+	}
+}
@@ -0,0 +1,846 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errMode = errors.New("permission bits must be octal, from 0 to 777")
+
+var optionDrift = newCounter("gcs_option_drift_total", "Number of times a volume was mounted with other options than gcsfuse for its bucket was started with.")
+
+type errUnknownOption struct {
+	key string
+}
+
+func (e errUnknownOption) Error() string {
+	return fmt.Sprintf("unknown option %q", e.key)
+}
+
+type errBadOption struct {
+	key    string
+	value  string
+	reason string
+}
+
+func (e errBadOption) Error() string {
+	return fmt.Sprintf("bad value %q for option %q: %s", e.value, e.key, e.reason)
+}
+
+type errUnknownUser struct {
+	name string
+}
+
+func (e errUnknownUser) Error() string {
+	return fmt.Sprintf("unknown user %q", e.name)
+}
+
+type errUnknownGroup struct {
+	name string
+}
+
+func (e errUnknownGroup) Error() string {
+	return fmt.Sprintf("unknown group %q", e.name)
+}
+
+type errDaemonizing struct {
+	arg string
+}
+
+func (e errDaemonizing) Error() string {
+	return fmt.Sprintf("gcsfuse must run in the foreground, refusing to pass %q", e.arg)
+}
+
+// checkArgs rejects arguments for gcsfuse that would make it daemonize.
+// The driver relies on gcsfuse staying in the foreground, to read its
+// output and to stop it.
+func checkArgs(args []string) error {
+	for _, a := range args {
+		name := strings.TrimLeft(a, "-")
+		if name == a || !strings.HasPrefix(name, "foreground=") {
+			continue
+		}
+
+		if on, err := strconv.ParseBool(strings.TrimPrefix(name, "foreground=")); err != nil || !on {
+			return errDaemonizing{arg: a}
+		}
+	}
+	return nil
+}
+
+// OptionSpec documents an option of volumes.
+type OptionSpec struct {
+	Key         string
+	Type        string
+	Default     string `json:",omitempty"`
+	Description string
+}
+
+// Options of volumes that are understood by mountOptions. Options that are
+// missing here are rejected, even if mountOptions knows about them.
+var optionSpecs = []OptionSpec{
+	{"access", "ro|rw", "rw", "mount read-only or read-write"},
+	{"ro", "bool", "false", "shorthand for access=ro"},
+	{"ro_fallback", "bool", "false", "mount read-only if the credentials may not create objects"},
+	{"user", "string", "", "name or id of the user that owns all files"},
+	{"group", "string", "", "name or id of the group that owns all files"},
+	{"squash", "user:group", "", "owner of all files, instead of user and group"},
+	{"file_mode", "octal", "644", "permission bits of all files"},
+	{"dir_mode", "octal", "755", "permission bits of all directories"},
+	{"umask", "octal", "022", "permission bits to clear from 666 for files and 777 for directories, instead of file_mode and dir_mode"},
+	{"default_permissions", "bool", "false", "let the kernel check permissions"},
+	{"access_scope", "private|root|all", "", "who may access the mount besides the user running gcsfuse: nobody, root or all users"},
+	{"comment", "string", "", "note that is attached to the mount"},
+	{"metadata", "json", "", "JSON object of strings that is attached to the mount, instead of comment"},
+	{"fsname", "string", "", "name of the file system in mount tables"},
+	{"subtype", "string", "gcsfuse", "subtype of the file system in mount tables"},
+	{"http_client_timeout", "duration", "", "timeout for requests to Cloud Storage"},
+	{"max_retry_duration", "duration", "", "how long to retry failed requests to Cloud Storage"},
+	{"refresh_interval", "duration", "", "how long gcsfuse caches metadata before looking it up again, 0 disables its cache"},
+	{"max_read", "int", "", "maximum size of read requests in bytes"},
+	{"entry_timeout", "duration", "", "how long gcsfuse caches the types of names, overrides refresh_interval"},
+	{"attr_timeout", "duration", "", "how long gcsfuse caches the attributes of objects, overrides refresh_interval"},
+	{"async_mount", "bool", "false", "do not wait for gcsfuse to report that the bucket is mounted"},
+	{"kernel_cache", "bool|force", "false", "cache metadata and listings for as long as gcsfuse runs, for objects that never change (requires access=ro unless forced)"},
+	{"nfs_export", "bool", "false", "export the mountpoint with the export command of the plugin"},
+	{"nonempty", "bool", "false", "allow mounting over a mountpoint that is not empty"},
+	{"only_dir", "bool", "false", "mount only the subpath of the volume, with its own gcsfuse"},
+	{"noexec", "bool", "false", "forbid executing files"},
+	{"nosuid", "bool", "false", "ignore setuid and setgid bits"},
+	{"nodev", "bool", "false", "ignore device files"},
+	{"noatime", "bool", "false", "do not update access times"},
+	{"max_size", "int", "", "raise an alarm once the objects take up more bytes"},
+	{"max_objects", "int", "", "raise an alarm once there are more objects"},
+	{"gomaxprocs", "int", "", "number of threads gcsfuse runs Go code in at the same time"},
+	{"memory_limit", "int", "", "bytes of memory that gcsfuse may use, enforced by a cgroup (Linux with cgroup v2 only)"},
+	{"key_file", "path", "", "key file with the credentials for the bucket"},
+	{"http_proxy", "url", "", "proxy for requests over HTTP"},
+	{"https_proxy", "url", "", "proxy for requests over HTTPS, which includes those to Cloud Storage"},
+	{"no_proxy", "list", "", "comma-separated hosts, domains and networks that are reached without a proxy"},
+	{"cache_dir", "path", "", "local directory that caches reads and stages writes"},
+	{"warm_cache", "int", "", "list directories this many levels deep after mounting, so that gcsfuse caches them"},
+	{"client_protocol", "http1|http2|grpc", "", "protocol that gcsfuse talks to Cloud Storage with"},
+	{"grpc_conn_pool_size", "int", "", "number of gRPC connections that gcsfuse opens (requires client_protocol=grpc)"},
+}
+
+func knownOption(k string) bool {
+	for _, spec := range optionSpecs {
+		if spec.Key == k {
+			return true
+		}
+	}
+	return false
+}
+
+// validator checks the value of an option, and returns why it is bad, or
+// an empty string if it is fine.
+type validator func(v string) string
+
+// Validators of options by key, see checkOption. Options that are missing
+// here take any value.
+var validators = map[string]validator{
+	"access":              oneOf("ro", "rw"),
+	"ro":                  isBool,
+	"ro_fallback":         isBool,
+	"user":                isAccount,
+	"group":               isAccount,
+	"squash":              isSquash,
+	"file_mode":           isMode,
+	"dir_mode":            isMode,
+	"umask":               isMode,
+	"default_permissions": isBool,
+	"access_scope":        oneOf("private", "root", "all"),
+	"fsname":              isName("-_.:/@"),
+	"metadata":            isMetadata,
+	"subtype":             isName("-_"),
+	"http_client_timeout": isDuration,
+	"max_retry_duration":  isDuration,
+	"refresh_interval":    isDuration,
+	// The kernel splits reads into requests of at most 128 KiB, or 1 MiB
+	// on Linux 4.20 and later, anything above is moot.
+	"max_read":        isInt(4096, 1<<20),
+	"entry_timeout":   isDuration,
+	"attr_timeout":    isDuration,
+	"async_mount":     isBool,
+	"kernel_cache":    isKernelCache,
+	"nfs_export":      isBool,
+	"nonempty":        isBool,
+	"only_dir":        isBool,
+	"noexec":          isBool,
+	"nosuid":          isBool,
+	"nodev":           isBool,
+	"noatime":         isBool,
+	"max_size":        isInt(1, math.MaxInt64),
+	"max_objects":     isInt(1, math.MaxInt64),
+	"gomaxprocs":      isInt(1, 1024),
+	"memory_limit":    isInt(minMemoryLimit, math.MaxInt64),
+	"key_file":        isPath,
+	"http_proxy":      isProxy,
+	"https_proxy":     isProxy,
+	"no_proxy":        isNoProxy,
+	"cache_dir":       isPath,
+	"warm_cache":      isInt(1, maxWarmDepth),
+	"client_protocol": oneOf("http1", "http2", "grpc"),
+	// Each connection is a socket, see checkFDs.
+	"grpc_conn_pool_size": isInt(1, 1024),
+}
+
+func isBool(v string) string {
+	if _, err := parseBool(v); err != nil {
+		return "want true or false"
+	}
+	return ""
+}
+
+func isKernelCache(v string) string {
+	if v != "force" && isBool(v) != "" {
+		return "want true, false or force"
+	}
+	return ""
+}
+
+func isDuration(v string) string {
+	if t, err := time.ParseDuration(v); err != nil || t < 0 {
+		return "want a duration like 90s or 5m"
+	}
+	return ""
+}
+
+func isPath(v string) string {
+	if !filepath.IsAbs(v) {
+		return "want an absolute path"
+	}
+	return ""
+}
+
+func isProxy(v string) string {
+	u, err := url.Parse(v)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+		return "want a URL like http://proxy:3128"
+	}
+	return ""
+}
+
+func isNoProxy(v string) string {
+	for _, h := range strings.Split(v, ",") {
+		if h == "" || (strings.ContainsAny(h, " \t/") && !isCIDR(h)) {
+			return "want hosts, domains and networks separated by commas, e.g. localhost,.internal,10.0.0.0/8"
+		}
+	}
+	return ""
+}
+
+func isCIDR(v string) bool {
+	_, _, err := net.ParseCIDR(v)
+	return err == nil
+}
+
+// isAccount checks the name or numeric id of a user or group. Whether it
+// exists is up to the host, see mountOptions.
+func isAccount(v string) string {
+	if v == "" || v[0] == '-' || isName("-_.")(v) != "" {
+		return "want a name or numeric id"
+	}
+	return ""
+}
+
+func isSquash(v string) string {
+	i := strings.Index(v, ":")
+	if i == -1 || isAccount(v[:i]) != "" || isAccount(v[i+1:]) != "" {
+		return "want user:group, e.g. nobody:nogroup"
+	}
+	return ""
+}
+
+func isMode(v string) string {
+	if _, err := parseMode(v); err != nil {
+		return "want octal permission bits like 644"
+	}
+	return ""
+}
+
+func isInt(min, max int64) validator {
+	return func(v string) string {
+		if _, err := parseInt(v, min, max); err != nil {
+			return fmt.Sprintf("want an integer from %d to %d", min, max)
+		}
+		return ""
+	}
+}
+
+func oneOf(values ...string) validator {
+	return func(v string) string {
+		for _, value := range values {
+			if v == value {
+				return ""
+			}
+		}
+		return "want one of " + strings.Join(values, ", ")
+	}
+}
+
+// isName accepts letters, digits and the characters in extra, see
+// validName.
+func isName(extra string) validator {
+	return func(v string) string {
+		if !validName(v, extra) {
+			return "want letters, digits and " + strings.Join(strings.Split(extra, ""), " ")
+		}
+		return ""
+	}
+}
+
+// checkOption checks both the key and the value of an option.
+func checkOption(k, v string) error {
+	if !knownOption(k) {
+		return errUnknownOption{key: k}
+	}
+	if check, ok := validators[k]; ok {
+		if reason := check(v); reason != "" {
+			return errBadOption{key: k, value: v, reason: reason}
+		}
+	}
+	return nil
+}
+
+// mountOptions translates the options of a volume, as passed to Create
+// (e.g. via `docker volume create -o`), to arguments for gcsfuse on h.
+// Options are translated in order of their keys, so that the same options
+// always give the same arguments, and the same error.
+func mountOptions(opts map[string]string, h host) ([]string, error) {
+	keys := make([]string, 0, len(opts))
+	for k := range opts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var args []string
+	for _, k := range keys {
+		v := opts[k]
+		if err := checkOption(k, v); err != nil {
+			return nil, err
+		}
+
+		switch k {
+		case "nonempty":
+			// Mounting over a directory that has content hides that
+			// content, only do it if asked to.
+			if on, _ := parseBool(v); on {
+				args = append(args, "-o", "nonempty")
+			}
+		case "access", "ro":
+			// See below.
+		case "default_permissions":
+			if on, _ := parseBool(v); on {
+				args = append(args, "-o", "default_permissions")
+			}
+		case "access_scope":
+			// Also see buildArgs, which drops both for private.
+			switch v {
+			case "root":
+				args = append(args, "-o", "allow_root")
+			case "all":
+				args = append(args, "-o", "allow_other")
+			}
+		case "comment":
+			args = append(args, "-o", "comment="+sanitizeComment(v))
+		case "metadata":
+			c, _ := parseMetadata(v)
+			args = append(args, "-o", "comment="+c)
+		case "http_client_timeout", "max_retry_duration":
+			args = append(args, "--"+strings.Replace(k, "_", "-", -1), v)
+		case "max_size", "max_objects":
+			// Handled by watchUsage.
+		case "warm_cache":
+			// Handled by warmCache.
+		case "memory_limit":
+			// Handled by limitMemory.
+			if !h.cgroup2() {
+				return nil, errBadOption{key: k, value: v, reason: "requires cgroup v2, which is only available on Linux"}
+			}
+		case "gomaxprocs", "http_proxy", "https_proxy", "no_proxy":
+			// Handled by command, as part of the environment.
+		case "client_protocol":
+			args = append(args, "--client-protocol", v)
+		case "grpc_conn_pool_size":
+			// Also see buildArgs, which checks the protocol.
+			if err := h.requireFlag(k, "experimental-grpc-conn-pool-size"); err != nil {
+				return nil, err
+			}
+			args = append(args, "--experimental-grpc-conn-pool-size", v)
+		case "key_file":
+			// Also see command, which keeps credentials of the driver
+			// out of the environment.
+			args = append(args, "--key-file", v)
+		case "max_read":
+			args = append(args, "-o", "max_read="+v)
+		case "fsname", "subtype":
+			// These show up in mount tables, as "fsname" and "fuse.subtype".
+			args = append(args, "-o", k+"="+v)
+		case "noexec", "nosuid", "nodev", "noatime":
+			// Turning them off is explicit, to override secure defaults
+			// and global flags.
+			if on, _ := parseBool(v); on {
+				args = append(args, "-o", k)
+			} else {
+				args = append(args, "-o", strings.TrimPrefix(k, "no"))
+			}
+		case "async_mount", "only_dir":
+			// Handled by asyncMount and key.
+		case "ro_fallback":
+			// Handled by Mount.
+		case "nfs_export":
+			// Handled by export.
+		case "user":
+			uid, err := h.lookupUser(v)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, "--uid", uid)
+		case "group":
+			gid, err := h.lookupGroup(v)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, "--gid", gid)
+		case "refresh_interval", "entry_timeout", "attr_timeout", "kernel_cache":
+			// See below.
+		case "cache_dir":
+			if err := h.requireFlag(k, "cache-dir"); err != nil {
+				return nil, err
+			}
+			args = append(args, "--cache-dir", v, "--temp-dir", v)
+		case "squash":
+			uid, gid, err := parseSquash(v, h)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, "--uid", uid, "--gid", gid)
+		case "file_mode", "dir_mode":
+			args = append(args, "--"+strings.Replace(k, "_", "-", -1), v)
+		case "umask":
+			// Explicit modes take precedence.
+			mask, _ := parseMode(v)
+			if _, ok := opts["file_mode"]; !ok {
+				args = append(args, "--file-mode", fmt.Sprintf("%o", 0666&^mask))
+			}
+			if _, ok := opts["dir_mode"]; !ok {
+				args = append(args, "--dir-mode", fmt.Sprintf("%o", 0777&^mask))
+			}
+		default:
+			return nil, errUnknownOption{key: k}
+		}
+	}
+
+	// Both end up as the comment of the mount.
+	if _, ok := opts["metadata"]; ok {
+		if _, ok := opts["comment"]; ok {
+			return nil, errBadOption{key: "metadata", value: opts["metadata"], reason: "conflicts with comment"}
+		}
+	}
+
+	// Squashing decides on both owner and group.
+	if _, ok := opts["squash"]; ok {
+		for _, other := range []string{"user", "group"} {
+			if _, ok := opts[other]; ok {
+				return nil, errBadOption{key: "squash", value: opts["squash"], reason: "conflicts with " + other}
+			}
+		}
+	}
+
+	// Unless dir_mode is given, the umask decides on directories too.
+	if v, ok := opts["umask"]; ok && opts["dir_mode"] == "" {
+		if m, _ := parseMode(v); m&0111 == 0111 {
+			return nil, errBadOption{key: "umask", value: v, reason: "clears all execute bits, directories could not be entered"}
+		}
+	}
+
+	// Directories that nobody may search are of no use.
+	if v, ok := opts["dir_mode"]; ok {
+		if m, _ := parseMode(v); m&0111 == 0 {
+			return nil, errBadOption{key: "dir_mode", value: v, reason: "lacks execute bits, directories could not be entered"}
+		}
+	}
+
+	args = append(args, cacheTTLArgs(opts, h.hasFlag)...)
+
+	// Always be explicit about the access mode instead of relying on
+	// the default of gcsfuse.
+	mode, err := accessMode(opts)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, "-o", mode)
+
+	// gcsfuse keeps serving what it cached, even after objects changed
+	// through this mount or another one.
+	if kernelCache(opts) {
+		v := opts["kernel_cache"]
+		if mode == "rw" && v != "force" {
+			return nil, errBadOption{key: "kernel_cache", value: v, reason: "requires access=ro, or kernel_cache=force if objects never change"}
+		}
+	}
+
+	return args, nil
+}
+
+// optionsHash returns a stable hash of effective options of a volume, i.e.
+// after those from files were merged, to tell whether two volumes are meant
+// to be mounted the same.
+func optionsHash(opts map[string]string) string {
+	if opts == nil {
+		opts = map[string]string{}
+	}
+	// Keys of maps are sorted when encoded.
+	b, _ := json.Marshal(opts)
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])[:12]
+}
+
+// asyncMount tells whether a volume with the given options is mounted
+// without waiting for gcsfuse to report success. Options are validated
+// already, also see mountOptions.
+func (d Driver) asyncMount(opts map[string]string) bool {
+	v, ok := opts["async_mount"]
+	if !ok {
+		return d.cfg.AsyncMount
+	}
+	on, _ := parseBool(v)
+	return on
+}
+
+// enabled tells whether the boolean option k is set and true. Options are
+// validated already.
+func enabled(opts map[string]string, k string) bool {
+	v, ok := opts[k]
+	if !ok {
+		return false
+	}
+	on, _ := parseBool(v)
+	return on
+}
+
+// kernelCache tells whether a volume with the given options asks for
+// kernel_cache, either way.
+func kernelCache(opts map[string]string) bool {
+	return opts["kernel_cache"] == "force" || enabled(opts, "kernel_cache")
+}
+
+// hasMountOption tells whether the system-specific mount option opt is
+// among args, which are arguments for gcsfuse, e.g. "-o", "allow_other".
+func hasMountOption(args []string, opt string) bool {
+	for i, a := range args {
+		var v string
+		switch {
+		case (a == "-o" || a == "--o") && i+1 < len(args):
+			v = args[i+1]
+		case strings.HasPrefix(a, "-o="), strings.HasPrefix(a, "--o="):
+			v = a[strings.Index(a, "=")+1:]
+		default:
+			continue
+		}
+		for _, o := range strings.Split(v, ",") {
+			if o == opt {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Stands in for TTLs that never expire with versions of gcsfuse that have
+// no notion of that, see cacheTTLArgs.
+const maxTTL = 100 * 365 * 24 * time.Hour
+
+// cacheTTLArgs returns the flags of gcsfuse that make it look up metadata
+// of a volume with the given options again once it is older than asked
+// for. refresh_interval sets the TTL of both attributes and types,
+// attr_timeout and entry_timeout override one of them. With kernel_cache,
+// what is not set never expires, and neither do listings that the kernel
+// caches. Newer versions of gcsfuse have one TTL for all metadata in whole
+// seconds, which gets the shorter one. has tells whether gcsfuse knows a
+// flag, see hasFlag.
+func cacheTTLArgs(opts map[string]string, has func(flag string) bool) []string {
+	stat, hasStat := ttl(opts, "attr_timeout", "refresh_interval")
+	typ, hasType := ttl(opts, "entry_timeout", "refresh_interval")
+	immutable := kernelCache(opts)
+	if immutable {
+		if !hasStat {
+			stat, hasStat = maxTTL, true
+		}
+		if !hasType {
+			typ, hasType = maxTTL, true
+		}
+	}
+
+	if has("metadata-cache-ttl-secs") {
+		if !hasStat && !hasType {
+			return nil
+		}
+		if !hasStat || hasType && typ < stat {
+			stat = typ
+		}
+		secs := strconv.Itoa(int(math.Ceil(stat.Seconds())))
+		if stat == maxTTL {
+			secs = "-1"
+		}
+		args := []string{"--metadata-cache-ttl-secs", secs}
+		if immutable && has("kernel-list-cache-ttl-secs") {
+			args = append(args, "--kernel-list-cache-ttl-secs", "-1")
+		}
+		return args
+	}
+
+	var args []string
+	if hasStat {
+		args = append(args, "--stat-cache-ttl", stat.String())
+	}
+	if hasType {
+		args = append(args, "--type-cache-ttl", typ.String())
+	}
+	return args
+}
+
+// ttl returns the duration of the first of keys that is among opts.
+// Options are validated already.
+func ttl(opts map[string]string, keys ...string) (time.Duration, bool) {
+	for _, k := range keys {
+		if v, ok := opts[k]; ok {
+			t, _ := time.ParseDuration(v)
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// readOnly returns a copy of opts that asks for access=ro.
+func readOnly(opts map[string]string) map[string]string {
+	ro := make(map[string]string, len(opts))
+	for k, v := range opts {
+		ro[k] = v
+	}
+	delete(ro, "ro")
+	ro["access"] = "ro"
+	return ro
+}
+
+// accessMode tells whether a volume with the given options is mounted
+// read-only ("ro") or read-write ("rw"), which is the default. The option
+// "ro" is a shorthand for "access=ro".
+func accessMode(opts map[string]string) (string, error) {
+	mode := "rw"
+	if v, ok := opts["access"]; ok {
+		if reason := oneOf("ro", "rw")(v); reason != "" {
+			return "", errBadOption{key: "access", value: v, reason: reason}
+		}
+		mode = v
+	}
+
+	v, ok := opts["ro"]
+	if !ok {
+		return mode, nil
+	}
+
+	on, err := parseBool(v)
+	if err != nil {
+		return "", errBadOption{key: "ro", value: v, reason: isBool(v)}
+	}
+	if (on && mode == "rw" && opts["access"] != "") || (!on && mode == "ro") {
+		return "", errBadOption{key: "ro", value: v, reason: "conflicts with access=" + mode}
+	}
+	if on {
+		return "ro", nil
+	}
+	return mode, nil
+}
+
+type errChown struct {
+	spec string
+}
+
+func (e errChown) Error() string {
+	return fmt.Sprintf("bad owner %q for mountpoints, want uid:gid", e.spec)
+}
+
+// parseSquash resolves "user:group" to numeric ids on h, both of which
+// must be given, like for parseChown.
+func parseSquash(spec string, h host) (string, string, error) {
+	i := strings.Index(spec, ":")
+	if i == -1 || i == 0 || i == len(spec)-1 {
+		return "", "", errChown{spec: spec}
+	}
+	uid, err := h.lookupUser(spec[:i])
+	if err != nil {
+		return "", "", err
+	}
+	gid, err := h.lookupGroup(spec[i+1:])
+	if err != nil {
+		return "", "", err
+	}
+	return uid, gid, nil
+}
+
+// parseMode parses octal permission bits, e.g. "644" or "0644".
+func parseMode(v string) (uint32, error) {
+	m, err := strconv.ParseUint(v, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, errMode
+	}
+	return uint32(m), nil
+}
+
+// parseChown resolves "uid:gid" to numeric ids, where either may be a name.
+// The ids of parts that are left out, or of an empty spec, are -1.
+func parseChown(spec string) (int, int, error) {
+	if spec == "" {
+		return -1, -1, nil
+	}
+
+	i := strings.Index(spec, ":")
+	if i == -1 || spec == ":" {
+		return 0, 0, errChown{spec: spec}
+	}
+
+	ids := []int{-1, -1}
+	for j, part := range []string{spec[:i], spec[i+1:]} {
+		if part == "" {
+			continue
+		}
+		lookup := lookupUser
+		if j == 1 {
+			lookup = lookupGroup
+		}
+		id, err := lookup(part)
+		if err != nil {
+			return 0, 0, err
+		}
+		ids[j], _ = strconv.Atoi(id)
+	}
+	return ids[0], ids[1], nil
+}
+
+// lookupUser resolves the name of a user to its numeric id. Numeric ids
+// are accepted as well.
+func lookupUser(name string) (string, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return name, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", errUnknownUser{name: name}
+	}
+	return u.Uid, nil
+}
+
+// lookupGroup resolves the name of a group to its numeric id. Numeric
+// ids are accepted as well.
+func lookupGroup(name string) (string, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return name, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return "", errUnknownGroup{name: name}
+	}
+	return g.Gid, nil
+}
+
+// mountOption returns the value of the system-specific mount option key
+// among args, see hasMountOption. The last one wins.
+func mountOption(args []string, key string) (string, bool) {
+	value, found := "", false
+	for i, a := range args {
+		var v string
+		switch {
+		case (a == "-o" || a == "--o") && i+1 < len(args):
+			v = args[i+1]
+		case strings.HasPrefix(a, "-o="), strings.HasPrefix(a, "--o="):
+			v = a[strings.Index(a, "=")+1:]
+		default:
+			continue
+		}
+		for _, o := range strings.Split(v, ",") {
+			if strings.HasPrefix(o, key+"=") {
+				value, found = o[len(key)+1:], true
+			}
+		}
+	}
+	return value, found
+}
+
+// sanitizeComment replaces characters that would break the list of mount
+// options, or its representation in /proc/mounts, by underscores.
+func sanitizeComment(v string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case strings.ContainsRune("-_.:/@+", r):
+			return r
+		}
+		return '_'
+	}, v)
+}
+
+// validName tells whether v is not empty and consists of letters, digits
+// and the characters in extra only.
+func validName(v, extra string) bool {
+	if v == "" {
+		return false
+	}
+	for _, r := range v {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune(extra, r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseInt parses v as an integer between min and max, inclusively.
+func parseInt(v string, min, max int64) (int64, error) {
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if i < min || i > max {
+		return 0, strconv.ErrRange
+	}
+	return i, nil
+}
+
+// parseBool is like strconv.ParseBool, but an empty value means true so
+// that `-o nonempty` works just like `-o nonempty=true`.
+func parseBool(v string) (bool, error) {
+	if v == "" {
+		return true, nil
+	}
+	return strconv.ParseBool(v)
+}
@@ -0,0 +1,910 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCacheTTLArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		opts    map[string]string
+		unified []string
+		split   []string
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:    "refresh_interval",
+			opts:    map[string]string{"refresh_interval": "30s"},
+			unified: []string{"--metadata-cache-ttl-secs", "30"},
+			split:   []string{"--stat-cache-ttl", "30s", "--type-cache-ttl", "30s"},
+		},
+		{
+			name:    "whole seconds",
+			opts:    map[string]string{"refresh_interval": "1500ms"},
+			unified: []string{"--metadata-cache-ttl-secs", "2"},
+			split:   []string{"--stat-cache-ttl", "1.5s", "--type-cache-ttl", "1.5s"},
+		},
+		{
+			name:    "rounded up",
+			opts:    map[string]string{"refresh_interval": "1ms"},
+			unified: []string{"--metadata-cache-ttl-secs", "1"},
+			split:   []string{"--stat-cache-ttl", "1ms", "--type-cache-ttl", "1ms"},
+		},
+		{
+			name:    "a day",
+			opts:    map[string]string{"refresh_interval": "24h"},
+			unified: []string{"--metadata-cache-ttl-secs", "86400"},
+			split:   []string{"--stat-cache-ttl", "24h0m0s", "--type-cache-ttl", "24h0m0s"},
+		},
+		{
+			name:    "disabled",
+			opts:    map[string]string{"refresh_interval": "0"},
+			unified: []string{"--metadata-cache-ttl-secs", "0"},
+			split:   []string{"--stat-cache-ttl", "0s", "--type-cache-ttl", "0s"},
+		},
+		{
+			name:    "attr_timeout",
+			opts:    map[string]string{"attr_timeout": "1m"},
+			unified: []string{"--metadata-cache-ttl-secs", "60"},
+			split:   []string{"--stat-cache-ttl", "1m0s"},
+		},
+		{
+			name:    "entry_timeout",
+			opts:    map[string]string{"entry_timeout": "1m"},
+			unified: []string{"--metadata-cache-ttl-secs", "60"},
+			split:   []string{"--type-cache-ttl", "1m0s"},
+		},
+		{
+			name:    "shorter wins",
+			opts:    map[string]string{"attr_timeout": "1m", "entry_timeout": "10s"},
+			unified: []string{"--metadata-cache-ttl-secs", "10"},
+			split:   []string{"--stat-cache-ttl", "1m0s", "--type-cache-ttl", "10s"},
+		},
+		{
+			name:    "override refresh_interval",
+			opts:    map[string]string{"refresh_interval": "5s", "attr_timeout": "1m"},
+			unified: []string{"--metadata-cache-ttl-secs", "5"},
+			split:   []string{"--stat-cache-ttl", "1m0s", "--type-cache-ttl", "5s"},
+		},
+		{
+			name:    "kernel_cache",
+			opts:    map[string]string{"kernel_cache": "true"},
+			unified: []string{"--metadata-cache-ttl-secs", "-1", "--kernel-list-cache-ttl-secs", "-1"},
+			split:   []string{"--stat-cache-ttl", "876000h0m0s", "--type-cache-ttl", "876000h0m0s"},
+		},
+		{
+			name: "kernel_cache off",
+			opts: map[string]string{"kernel_cache": "false"},
+		},
+		{
+			name:    "kernel_cache with attr_timeout",
+			opts:    map[string]string{"kernel_cache": "force", "attr_timeout": "1h"},
+			unified: []string{"--metadata-cache-ttl-secs", "3600", "--kernel-list-cache-ttl-secs", "-1"},
+			split:   []string{"--stat-cache-ttl", "1h0m0s", "--type-cache-ttl", "876000h0m0s"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			unified := func(string) bool { return true }
+			if got := cacheTTLArgs(tc.opts, unified); !reflect.DeepEqual(got, tc.unified) {
+				t.Errorf("unified: got %q, want %q", got, tc.unified)
+			}
+			split := func(string) bool { return false }
+			if got := cacheTTLArgs(tc.opts, split); !reflect.DeepEqual(got, tc.split) {
+				t.Errorf("split: got %q, want %q", got, tc.split)
+			}
+		})
+	}
+}
+
+func TestMountOptionsOwnership(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts map[string]string
+		want []string
+		err  error
+	}{
+		{
+			name: "squash",
+			opts: map[string]string{"squash": "nobody:nogroup"},
+			want: []string{"--uid", "nobody", "--gid", "nogroup"},
+		},
+		{
+			name: "squash with modes",
+			opts: map[string]string{"squash": "65534:65534", "file_mode": "444", "dir_mode": "555"},
+			want: []string{"--dir-mode", "555", "--file-mode", "444", "--uid", "65534", "--gid", "65534"},
+		},
+		{
+			name: "squash with umask",
+			opts: map[string]string{"squash": "1:2", "umask": "027"},
+			want: []string{"--uid", "1", "--gid", "2", "--file-mode", "640", "--dir-mode", "750"},
+		},
+		{
+			name: "umask",
+			opts: map[string]string{"umask": "022"},
+			want: []string{"--file-mode", "644", "--dir-mode", "755"},
+		},
+		{
+			name: "umask nothing",
+			opts: map[string]string{"umask": "0"},
+			want: []string{"--file-mode", "666", "--dir-mode", "777"},
+		},
+		{
+			name: "umask keeping some execute bits",
+			opts: map[string]string{"umask": "0776"},
+			want: []string{"--file-mode", "0", "--dir-mode", "1"},
+		},
+		{
+			name: "umask not octal",
+			opts: map[string]string{"umask": "099"},
+			err:  errBadOption{key: "umask", value: "099", reason: "want octal permission bits like 644"},
+		},
+		{
+			name: "umask with file_mode",
+			opts: map[string]string{"umask": "077", "file_mode": "600"},
+			want: []string{"--file-mode", "600", "--dir-mode", "700"},
+		},
+		{
+			name: "umask with dir_mode",
+			opts: map[string]string{"umask": "777", "dir_mode": "711"},
+			want: []string{"--dir-mode", "711", "--file-mode", "0"},
+		},
+		{
+			name: "umask without execute bits",
+			opts: map[string]string{"umask": "777"},
+			err:  errBadOption{key: "umask", value: "777", reason: "clears all execute bits, directories could not be entered"},
+		},
+		{
+			name: "dir_mode without execute bits",
+			opts: map[string]string{"dir_mode": "644"},
+			err:  errBadOption{key: "dir_mode", value: "644", reason: "lacks execute bits, directories could not be entered"},
+		},
+		{
+			name: "squash with user",
+			opts: map[string]string{"squash": "1:2", "user": "3"},
+			err:  errBadOption{key: "squash", value: "1:2", reason: "conflicts with user"},
+		},
+		{
+			name: "squash with group",
+			opts: map[string]string{"squash": "1:2", "group": "3"},
+			err:  errBadOption{key: "squash", value: "1:2", reason: "conflicts with group"},
+		},
+		{
+			name: "file_mode not octal",
+			opts: map[string]string{"file_mode": "rw-r--r--"},
+			err:  errBadOption{key: "file_mode", value: "rw-r--r--", reason: "want octal permission bits like 644"},
+		},
+		{
+			name: "dir_mode with setuid",
+			opts: map[string]string{"dir_mode": "4755"},
+			err:  errBadOption{key: "dir_mode", value: "4755", reason: "want octal permission bits like 644"},
+		},
+		{
+			name: "squash without group",
+			opts: map[string]string{"squash": "1:"},
+			err:  errBadOption{key: "squash", value: "1:", reason: "want user:group, e.g. nobody:nogroup"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			args, err := mountOptions(tc.opts, anyHost)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Fatalf("got error %v, want %v", err, tc.err)
+			}
+			if err == nil {
+				// Drop the access mode, which always comes last.
+				args = args[:len(args)-2]
+			}
+			if !reflect.DeepEqual(args, tc.want) {
+				t.Errorf("got %q, want %q", args, tc.want)
+			}
+		})
+	}
+}
+
+// Options that translate to arguments for gcsfuse one by one, before the
+// access mode, which always comes last.
+func TestMountOptions(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts map[string]string
+		want []string
+		err  error
+	}{
+		{
+			name: "none",
+		},
+		{
+			name: "unknown",
+			opts: map[string]string{"nope": "1"},
+			err:  errUnknownOption{key: "nope"},
+		},
+		{
+			name: "nonempty",
+			opts: map[string]string{"nonempty": "true"},
+			want: []string{"-o", "nonempty"},
+		},
+		{
+			name: "nonempty off",
+			opts: map[string]string{"nonempty": "false"},
+		},
+		{
+			name: "nonempty bad",
+			opts: map[string]string{"nonempty": "sometimes"},
+			err:  errBadOption{key: "nonempty", value: "sometimes", reason: "want true or false"},
+		},
+		{
+			name: "default_permissions",
+			opts: map[string]string{"default_permissions": "true"},
+			want: []string{"-o", "default_permissions"},
+		},
+		{
+			name: "default_permissions off",
+			opts: map[string]string{"default_permissions": "0"},
+		},
+		{
+			name: "comment",
+			opts: map[string]string{"comment": "team=data, owner: me"},
+			want: []string{"-o", "comment=team_data__owner:_me"},
+		},
+		{
+			name: "timeouts",
+			opts: map[string]string{"http_client_timeout": "30s", "max_retry_duration": "2m"},
+			want: []string{"--http-client-timeout", "30s", "--max-retry-duration", "2m"},
+		},
+		{
+			name: "timeout bad",
+			opts: map[string]string{"http_client_timeout": "-1s"},
+			err:  errBadOption{key: "http_client_timeout", value: "-1s", reason: "want a duration like 90s or 5m"},
+		},
+		{
+			name: "user and group",
+			opts: map[string]string{"user": "1000", "group": "staff"},
+			want: []string{"--gid", "staff", "--uid", "1000"},
+		},
+		{
+			name: "user bad",
+			opts: map[string]string{"user": "-1"},
+			err:  errBadOption{key: "user", value: "-1", reason: "want a name or numeric id"},
+		},
+		{
+			name: "group empty",
+			opts: map[string]string{"group": ""},
+			err:  errBadOption{key: "group", value: "", reason: "want a name or numeric id"},
+		},
+		{
+			name: "max_read",
+			opts: map[string]string{"max_read": "131072"},
+			want: []string{"-o", "max_read=131072"},
+		},
+		{
+			name: "max_read too small",
+			opts: map[string]string{"max_read": "1024"},
+			err:  errBadOption{key: "max_read", value: "1024", reason: "want an integer from 4096 to 1048576"},
+		},
+		{
+			name: "max_read not a number",
+			opts: map[string]string{"max_read": "128k"},
+			err:  errBadOption{key: "max_read", value: "128k", reason: "want an integer from 4096 to 1048576"},
+		},
+		{
+			name: "fsname and subtype",
+			opts: map[string]string{"fsname": "gs://b", "subtype": "gcs-data"},
+			want: []string{"-o", "fsname=gs://b", "-o", "subtype=gcs-data"},
+		},
+		{
+			name: "fsname bad",
+			opts: map[string]string{"fsname": "a,b"},
+			err:  errBadOption{key: "fsname", value: "a,b", reason: "want letters, digits and - _ . : / @"},
+		},
+		{
+			name: "subtype bad",
+			opts: map[string]string{"subtype": "a.b"},
+			err:  errBadOption{key: "subtype", value: "a.b", reason: "want letters, digits and - _"},
+		},
+		{
+			name: "subtype empty",
+			opts: map[string]string{"subtype": ""},
+			err:  errBadOption{key: "subtype", value: "", reason: "want letters, digits and - _"},
+		},
+		{
+			// gcsfuse refuses to mount with it.
+			name: "async_read",
+			opts: map[string]string{"async_read": "false"},
+			err:  errUnknownOption{key: "async_read"},
+		},
+		{
+			name: "attr_timeout bad",
+			opts: map[string]string{"attr_timeout": "60"},
+			err:  errBadOption{key: "attr_timeout", value: "60", reason: "want a duration like 90s or 5m"},
+		},
+		{
+			name: "entry_timeout negative",
+			opts: map[string]string{"entry_timeout": "-1m"},
+			err:  errBadOption{key: "entry_timeout", value: "-1m", reason: "want a duration like 90s or 5m"},
+		},
+		{
+			// gcsfuse refuses to mount with it.
+			name: "writeback_cache",
+			opts: map[string]string{"writeback_cache": "true"},
+			err:  errUnknownOption{key: "writeback_cache"},
+		},
+		{
+			// Neither does it accept this one.
+			name: "direct_io",
+			opts: map[string]string{"direct_io": "true"},
+			err:  errUnknownOption{key: "direct_io"},
+		},
+		{
+			// Only libfuse knows it, the kernel refuses to mount with it.
+			name: "max_write",
+			opts: map[string]string{"max_write": "1048576"},
+			err:  errUnknownOption{key: "max_write"},
+		},
+		{
+			// Set in the environment, see command.
+			name: "gomaxprocs",
+			opts: map[string]string{"gomaxprocs": "2"},
+		},
+		{
+			name: "gomaxprocs zero",
+			opts: map[string]string{"gomaxprocs": "0"},
+			err:  errBadOption{key: "gomaxprocs", value: "0", reason: "want an integer from 1 to 1024"},
+		},
+		{
+			name: "noexec nosuid nodev",
+			opts: map[string]string{"noexec": "true", "nosuid": "1", "nodev": "true"},
+			want: []string{"-o", "nodev", "-o", "noexec", "-o", "nosuid"},
+		},
+		{
+			name: "noexec off",
+			opts: map[string]string{"noexec": "false"},
+			want: []string{"-o", "exec"},
+		},
+		{
+			name: "nosuid bad",
+			opts: map[string]string{"nosuid": "yes please"},
+			err:  errBadOption{key: "nosuid", value: "yes please", reason: "want true or false"},
+		},
+		{
+			// Handled by watchUsage.
+			name: "max_size and max_objects",
+			opts: map[string]string{"max_size": "1073741824", "max_objects": "1000"},
+		},
+		{
+			name: "max_size zero",
+			opts: map[string]string{"max_size": "0"},
+			err:  errBadOption{key: "max_size", value: "0", reason: "want an integer from 1 to 9223372036854775807"},
+		},
+		{
+			name: "key_file",
+			opts: map[string]string{"key_file": "/volume.json"},
+			want: []string{"--key-file", "/volume.json"},
+		},
+		{
+			name: "key_file relative",
+			opts: map[string]string{"key_file": "volume.json"},
+			err:  errBadOption{key: "key_file", value: "volume.json", reason: "want an absolute path"},
+		},
+		{
+			name: "client_protocol",
+			opts: map[string]string{"client_protocol": "grpc"},
+			want: []string{"--client-protocol", "grpc"},
+		},
+		{
+			name: "client_protocol unknown",
+			opts: map[string]string{"client_protocol": "http3"},
+			err:  errBadOption{key: "client_protocol", value: "http3", reason: "want one of http1, http2, grpc"},
+		},
+		{
+			// The protocol is checked by buildArgs, see TestBuildArgs.
+			name: "grpc_conn_pool_size",
+			opts: map[string]string{"grpc_conn_pool_size": "4"},
+			want: []string{"--experimental-grpc-conn-pool-size", "4"},
+		},
+		{
+			name: "grpc_conn_pool_size zero",
+			opts: map[string]string{"grpc_conn_pool_size": "0"},
+			err:  errBadOption{key: "grpc_conn_pool_size", value: "0", reason: "want an integer from 1 to 1024"},
+		},
+		{
+			name: "noatime",
+			opts: map[string]string{"noatime": "true"},
+			want: []string{"-o", "noatime"},
+		},
+		{
+			name: "noatime off",
+			opts: map[string]string{"noatime": "false"},
+			want: []string{"-o", "atime"},
+		},
+		{
+			name: "metadata",
+			opts: map[string]string{"metadata": `{"purpose":"logs"}`},
+			want: []string{"-o", "comment=json:eyJwdXJwb3NlIjoibG9ncyJ9"},
+		},
+		{
+			name: "metadata and comment",
+			opts: map[string]string{"metadata": `{"purpose":"logs"}`, "comment": "hi"},
+			err:  errBadOption{key: "metadata", value: `{"purpose":"logs"}`, reason: "conflicts with comment"},
+		},
+		{
+			name: "metadata bad",
+			opts: map[string]string{"metadata": `{"purpose":1}`},
+			err:  errBadOption{key: "metadata", value: `{"purpose":1}`, reason: errMetadataValue.Error()},
+		},
+		{
+			name: "cache_dir",
+			opts: map[string]string{"cache_dir": "/var/cache/gcs"},
+			want: []string{"--cache-dir", "/var/cache/gcs", "--temp-dir", "/var/cache/gcs"},
+		},
+		{
+			name: "cache_dir relative",
+			opts: map[string]string{"cache_dir": "cache"},
+			err:  errBadOption{key: "cache_dir", value: "cache", reason: "want an absolute path"},
+		},
+		{
+			name: "ro_fallback",
+			opts: map[string]string{"ro_fallback": "true"},
+		},
+		{
+			name: "ro_fallback bad",
+			opts: map[string]string{"ro_fallback": "maybe"},
+			err:  errBadOption{key: "ro_fallback", value: "maybe", reason: "want true or false"},
+		},
+		{
+			// Set in the environment of gcsfuse only.
+			name: "proxy",
+			opts: map[string]string{"https_proxy": "http://proxy:3128", "no_proxy": "localhost"},
+		},
+		{
+			name: "proxy without scheme",
+			opts: map[string]string{"https_proxy": "proxy:3128"},
+			err:  errBadOption{key: "https_proxy", value: "proxy:3128", reason: "want a URL like http://proxy:3128"},
+		},
+		{
+			// Handled once mounted.
+			name: "warm_cache",
+			opts: map[string]string{"warm_cache": "3"},
+		},
+		{
+			name: "warm_cache too deep",
+			opts: map[string]string{"warm_cache": "17"},
+			err:  errBadOption{key: "warm_cache", value: "17", reason: "want an integer from 1 to 16"},
+		},
+		{
+			// Read-only data that changes now and then.
+			name: "refresh_interval read-only",
+			opts: map[string]string{"refresh_interval": "5m", "access": "ro"},
+			want: []string{"--metadata-cache-ttl-secs", "300"},
+		},
+		{
+			name: "refresh_interval negative",
+			opts: map[string]string{"refresh_interval": "-5m"},
+			err:  errBadOption{key: "refresh_interval", value: "-5m", reason: "want a duration like 90s or 5m"},
+		},
+		{
+			name: "kernel_cache read-only",
+			opts: map[string]string{"kernel_cache": "true", "access": "ro"},
+			want: []string{"--metadata-cache-ttl-secs", "-1", "--kernel-list-cache-ttl-secs", "-1"},
+		},
+		{
+			name: "kernel_cache bare",
+			opts: map[string]string{"kernel_cache": "", "ro": "true"},
+			want: []string{"--metadata-cache-ttl-secs", "-1", "--kernel-list-cache-ttl-secs", "-1"},
+		},
+		{
+			name: "kernel_cache forced",
+			opts: map[string]string{"kernel_cache": "force"},
+			want: []string{"--metadata-cache-ttl-secs", "-1", "--kernel-list-cache-ttl-secs", "-1"},
+		},
+		{
+			name: "kernel_cache off read-write",
+			opts: map[string]string{"kernel_cache": "false"},
+		},
+		{
+			name: "kernel_cache read-write",
+			opts: map[string]string{"kernel_cache": ""},
+			err:  errBadOption{key: "kernel_cache", value: "", reason: "requires access=ro, or kernel_cache=force if objects never change"},
+		},
+		{
+			name: "kernel_cache bad",
+			opts: map[string]string{"kernel_cache": "always", "access": "ro"},
+			err:  errBadOption{key: "kernel_cache", value: "always", reason: "want true, false or force"},
+		},
+		{
+			name: "access_scope all",
+			opts: map[string]string{"access_scope": "all"},
+			want: []string{"-o", "allow_other"},
+		},
+		{
+			name: "access_scope root",
+			opts: map[string]string{"access_scope": "root"},
+			want: []string{"-o", "allow_root"},
+		},
+		{
+			// Handled by buildArgs.
+			name: "access_scope private",
+			opts: map[string]string{"access_scope": "private"},
+		},
+		{
+			name: "access_scope bad",
+			opts: map[string]string{"access_scope": "other"},
+			err:  errBadOption{key: "access_scope", value: "other", reason: "want one of private, root, all"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			args, err := mountOptions(tc.opts, anyHost)
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Fatalf("got error %v, want %v", err, tc.err)
+			}
+			if err == nil {
+				args = args[:len(args)-2]
+			}
+			if len(args) == 0 {
+				args = nil
+			}
+			if !reflect.DeepEqual(args, tc.want) {
+				t.Errorf("got %q, want %q", args, tc.want)
+			}
+		})
+	}
+}
+
+func TestLookupAccounts(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		uid  string
+		gid  string
+		errs [2]error
+	}{
+		{name: "0", uid: "0", gid: "0"},
+		{name: "4294967295", uid: "4294967295", gid: "4294967295"},
+		{name: "no-such-account", errs: [2]error{errUnknownUser{name: "no-such-account"}, errUnknownGroup{name: "no-such-account"}}},
+		// Too large for an id, so it is taken for a name.
+		{name: "4294967296", errs: [2]error{errUnknownUser{name: "4294967296"}, errUnknownGroup{name: "4294967296"}}},
+	} {
+		uid, err := lookupUser(tc.name)
+		if uid != tc.uid || !reflect.DeepEqual(err, tc.errs[0]) {
+			t.Errorf("lookupUser(%s) = %q, %v, want %q, %v", tc.name, uid, err, tc.uid, tc.errs[0])
+		}
+		gid, err := lookupGroup(tc.name)
+		if gid != tc.gid || !reflect.DeepEqual(err, tc.errs[1]) {
+			t.Errorf("lookupGroup(%s) = %q, %v, want %q, %v", tc.name, gid, err, tc.gid, tc.errs[1])
+		}
+	}
+}
+
+func TestAccessMode(t *testing.T) {
+	for _, tc := range []struct {
+		opts map[string]string
+		want string
+		err  error
+	}{
+		{nil, "rw", nil},
+		{map[string]string{"access": "ro"}, "ro", nil},
+		{map[string]string{"access": "rw"}, "rw", nil},
+		{map[string]string{"access": "wo"}, "", errBadOption{key: "access", value: "wo", reason: "want one of ro, rw"}},
+		{map[string]string{"ro": "true"}, "ro", nil},
+		{map[string]string{"ro": "false"}, "rw", nil},
+		{map[string]string{"ro": "yes please"}, "", errBadOption{key: "ro", value: "yes please", reason: "want true or false"}},
+		{map[string]string{"access": "ro", "ro": "true"}, "ro", nil},
+		{map[string]string{"access": "rw", "ro": "false"}, "rw", nil},
+		{map[string]string{"access": "rw", "ro": "true"}, "", errBadOption{key: "ro", value: "true", reason: "conflicts with access=rw"}},
+		{map[string]string{"access": "ro", "ro": "false"}, "", errBadOption{key: "ro", value: "false", reason: "conflicts with access=ro"}},
+	} {
+		got, err := accessMode(tc.opts)
+		if got != tc.want || !reflect.DeepEqual(err, tc.err) {
+			t.Errorf("accessMode(%v) = %q, %v, want %q, %v", tc.opts, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestSanitizeComment(t *testing.T) {
+	for _, tc := range []struct {
+		v    string
+		want string
+	}{
+		{"", ""},
+		{"logs-v1.2", "logs-v1.2"},
+		{"me@example.com:/data+more", "me@example.com:/data+more"},
+		// Would end the option, or start another one.
+		{"a,b=c", "a_b_c"},
+		// Would be escaped in /proc/mounts.
+		{"a b\tc\nd\\e", "a_b_c_d_e"},
+		{"ünïcode", "_n_code"},
+	} {
+		if got := sanitizeComment(tc.v); got != tc.want {
+			t.Errorf("sanitizeComment(%q) = %q, want %q", tc.v, got, tc.want)
+		}
+	}
+}
+
+func TestCheckArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		err  error
+	}{
+		{nil, nil},
+		{[]string{"--implicit-dirs", "--foreground"}, nil},
+		{[]string{"--foreground=true"}, nil},
+		{[]string{"-foreground=1"}, nil},
+		{[]string{"--foreground=false"}, errDaemonizing{arg: "--foreground=false"}},
+		{[]string{"-foreground=0"}, errDaemonizing{arg: "-foreground=0"}},
+		{[]string{"--foreground=maybe"}, errDaemonizing{arg: "--foreground=maybe"}},
+		// Not a flag, but the value of one.
+		{[]string{"--temp-dir", "foreground=false"}, nil},
+	} {
+		if err := checkArgs(tc.args); !reflect.DeepEqual(err, tc.err) {
+			t.Errorf("checkArgs(%q) = %v, want %v", tc.args, err, tc.err)
+		}
+	}
+}
+
+// The options that are advertised are the ones that are checked.
+func TestOptionSpecs(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, spec := range optionSpecs {
+		if seen[spec.Key] {
+			t.Errorf("%s is advertised twice", spec.Key)
+		}
+		seen[spec.Key] = true
+
+		if spec.Default != "" {
+			if err := checkOption(spec.Key, spec.Default); err != nil {
+				t.Errorf("default of %s: %s", spec.Key, err)
+			}
+		}
+		if strings.Contains(spec.Type, "|") {
+			for _, v := range strings.Split(spec.Type, "|") {
+				if v == "bool" {
+					v = "true"
+				}
+				if err := checkOption(spec.Key, v); err != nil {
+					t.Errorf("value %s of %s: %s", v, spec.Key, err)
+				}
+			}
+		}
+		if spec.Type == "bool" {
+			if err := checkOption(spec.Key, "maybe"); err == nil {
+				t.Errorf("%s is a bool, but takes maybe", spec.Key)
+			}
+		}
+	}
+	for k := range validators {
+		if !seen[k] {
+			t.Errorf("%s is validated, but not advertised", k)
+		}
+	}
+}
+
+func TestValidators(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		v    validator
+		good []string
+		bad  []string
+	}{
+		// A bare flag such as -o kernel_cache means true.
+		{"isBool", isBool, []string{"", "true", "false", "1", "0"}, []string{"yes", "on"}},
+		{"isKernelCache", isKernelCache, []string{"", "true", "false", "force"}, []string{"always"}},
+		{"isDuration", isDuration, []string{"0", "90s", "1h30m", "1.5s"}, []string{"", "90", "-1s", "soon"}},
+		{"isPath", isPath, []string{"/", "/etc/key.json"}, []string{"", "key.json", "./key.json"}},
+		{"isProxy", isProxy, []string{"http://proxy:3128", "https://proxy", "socks5://127.0.0.1:1080"}, []string{"", "proxy:3128", "ftp://proxy", "http://"}},
+		{"isNoProxy", isNoProxy, []string{"localhost", "localhost,.internal,10.0.0.0/8", "*"}, []string{"", "a,,b", "a b", "a/b"}},
+		{"isAccount", isAccount, []string{"1000", "nobody", "www-data", "first.last"}, []string{"", "-1", "a b", "a:b"}},
+		{"isSquash", isSquash, []string{"nobody:nogroup", "65534:65534"}, []string{"", "nobody", ":", "nobody:", ":nogroup", "-1:0"}},
+		{"isMode", isMode, []string{"644", "0644", "7", "777"}, []string{"", "8", "rw", "1777x"}},
+		{"isInt", isInt(1, 10), []string{"1", "10"}, []string{"", "0", "11", "1.5", "ten"}},
+		{"oneOf", oneOf("a", "b"), []string{"a", "b"}, []string{"", "c", "A"}},
+		{"isName", isName("-_"), []string{"a", "a-b_c", "A1"}, []string{"", "a.b", "a b", "a/b"}},
+		{"isMetadata", isMetadata, []string{`{"a":"b"}`, `{}`}, []string{"", "null", `{"a":1}`, `["a"]`}},
+	} {
+		for _, v := range tc.good {
+			if reason := tc.v(v); reason != "" {
+				t.Errorf("%s(%q) = %q, want it accepted", tc.name, v, reason)
+			}
+		}
+		for _, v := range tc.bad {
+			if reason := tc.v(v); reason == "" {
+				t.Errorf("%s(%q) accepted, want it rejected", tc.name, v)
+			}
+		}
+	}
+}
+
+func TestCheckOption(t *testing.T) {
+	for _, tc := range []struct {
+		k, v string
+		err  error
+	}{
+		{"access", "ro", nil},
+		{"access", "r", errBadOption{key: "access", value: "r", reason: "want one of ro, rw"}},
+		// Options without a validator take any value.
+		{"comment", "anything at all", nil},
+		{"nope", "1", errUnknownOption{key: "nope"}},
+	} {
+		if err := checkOption(tc.k, tc.v); !reflect.DeepEqual(err, tc.err) {
+			t.Errorf("checkOption(%s, %s) = %v, want %v", tc.k, tc.v, err, tc.err)
+		}
+	}
+}
+
+func TestParseChown(t *testing.T) {
+	for _, tc := range []struct {
+		spec     string
+		uid, gid int
+		err      error
+	}{
+		{"", -1, -1, nil},
+		{"1000:1000", 1000, 1000, nil},
+		{"1000:", 1000, -1, nil},
+		{":1000", -1, 1000, nil},
+		{"root:root", 0, 0, nil},
+		{"1000", 0, 0, errChown{spec: "1000"}},
+		{":", 0, 0, errChown{spec: ":"}},
+		{"-1:0", 0, 0, errUnknownUser{name: "-1"}},
+		{"nosuchuser:0", 0, 0, errUnknownUser{name: "nosuchuser"}},
+		{"0:nosuchgroup", 0, 0, errUnknownGroup{name: "nosuchgroup"}},
+	} {
+		uid, gid, err := parseChown(tc.spec)
+		if !reflect.DeepEqual(err, tc.err) {
+			t.Errorf("parseChown(%q): got %v, want %v", tc.spec, err, tc.err)
+			continue
+		}
+		if uid != tc.uid || gid != tc.gid {
+			t.Errorf("parseChown(%q) = %d, %d, want %d, %d", tc.spec, uid, gid, tc.uid, tc.gid)
+		}
+	}
+}
+
+func TestKernelCache(t *testing.T) {
+	for v, want := range map[string]bool{"": true, "true": true, "1": true, "force": true, "false": false, "0": false} {
+		if got := kernelCache(map[string]string{"kernel_cache": v}); got != want {
+			t.Errorf("kernelCache(%q) = %t, want %t", v, got, want)
+		}
+	}
+	if kernelCache(nil) {
+		t.Error("kernelCache without the option")
+	}
+}
+
+// Maps are iterated in random order, but the arguments follow the keys.
+func TestMountOptionsOrder(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts map[string]string
+		want []string
+		err  error
+	}{
+		{
+			name: "arguments",
+			opts: map[string]string{"umask": "022", "nonempty": "true", "max_read": "65536", "default_permissions": "true", "cache_dir": "/c", "squash": "1:2"},
+			want: []string{"--cache-dir", "/c", "--temp-dir", "/c", "-o", "default_permissions", "-o", "max_read=65536", "-o", "nonempty", "--uid", "1", "--gid", "2", "--file-mode", "644", "--dir-mode", "755", "-o", "rw"},
+		},
+		{
+			name: "first error",
+			opts: map[string]string{"z_nope": "1", "access": "rx", "b_nope": "1", "max_read": "1"},
+			err:  errBadOption{key: "access", value: "rx", reason: "want one of ro, rw"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for i := 0; i < 50; i++ {
+				args, err := mountOptions(tc.opts, anyHost)
+				if !reflect.DeepEqual(err, tc.err) {
+					t.Fatalf("attempt %d: got error %v, want %v", i, err, tc.err)
+				}
+				if !reflect.DeepEqual(args, tc.want) {
+					t.Fatalf("attempt %d: got %q, want %q", i, args, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseSquash(t *testing.T) {
+	for _, tc := range []struct {
+		spec     string
+		uid, gid string
+		err      error
+	}{
+		{"0:0", "0", "0", nil},
+		{"root:root", "0", "0", nil},
+		{"65534:root", "65534", "0", nil},
+		// Unlike for parseChown, both are required.
+		{"1000:", "", "", errChown{spec: "1000:"}},
+		{":1000", "", "", errChown{spec: ":1000"}},
+		{"1000", "", "", errChown{spec: "1000"}},
+		{"", "", "", errChown{spec: ""}},
+		{"nosuchuser:0", "", "", errUnknownUser{name: "nosuchuser"}},
+		{"0:nosuchgroup", "", "", errUnknownGroup{name: "nosuchgroup"}},
+	} {
+		uid, gid, err := parseSquash(tc.spec, localHost)
+		if uid != tc.uid || gid != tc.gid || !reflect.DeepEqual(err, tc.err) {
+			t.Errorf("parseSquash(%q) = %q, %q, %v, want %q, %q, %v", tc.spec, uid, gid, err, tc.uid, tc.gid, tc.err)
+		}
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for _, tc := range []struct {
+		v    string
+		mode uint32
+		err  error
+	}{
+		{"644", 0644, nil},
+		{"0644", 0644, nil},
+		{"0", 0, nil},
+		{"777", 0777, nil},
+		{"1777", 0, errMode},
+		{"888", 0, errMode},
+		{"-1", 0, errMode},
+		{"rw-r--r--", 0, errMode},
+		{"", 0, errMode},
+	} {
+		if m, err := parseMode(tc.v); m != tc.mode || err != tc.err {
+			t.Errorf("parseMode(%q) = %o, %v, want %o, %v", tc.v, m, err, tc.mode, tc.err)
+		}
+	}
+}
+
+// Options that need flags of newer versions of gcsfuse fail early.
+func TestMountOptionsRequireFlag(t *testing.T) {
+	old := anyHost
+	old.hasFlag = func(string) bool { return false }
+	for _, tc := range []struct {
+		opts map[string]string
+		err  error
+	}{
+		{map[string]string{"client_protocol": "grpc"}, nil},
+		{map[string]string{"grpc_conn_pool_size": "4"}, errGcsfuseFlag{option: "grpc_conn_pool_size", flag: "experimental-grpc-conn-pool-size"}},
+	} {
+		if _, err := mountOptions(tc.opts, old); !reflect.DeepEqual(err, tc.err) {
+			t.Errorf("%v: got %v, want %v", tc.opts, err, tc.err)
+		}
+	}
+}
+
+func TestOptionsHash(t *testing.T) {
+	base := optionsHash(map[string]string{"access": "ro", "comment": "hi"})
+	for _, tc := range []struct {
+		name string
+		opts map[string]string
+		same bool
+	}{
+		{"same", map[string]string{"comment": "hi", "access": "ro"}, true},
+		{"other value", map[string]string{"access": "rw", "comment": "hi"}, false},
+		{"fewer", map[string]string{"access": "ro"}, false},
+		{"more", map[string]string{"access": "ro", "comment": "hi", "max_read": "4096"}, false},
+		// Keys and values do not run into each other.
+		{"shifted", map[string]string{"access": "ro\",\"comment\":\"hi"}, false},
+	} {
+		h := optionsHash(tc.opts)
+		if len(h) != 12 {
+			t.Errorf("%s: hash %q has %d characters, want 12", tc.name, h, len(h))
+		}
+		if (h == base) != tc.same {
+			t.Errorf("%s: hash %s, base %s, want same %t", tc.name, h, base, tc.same)
+		}
+	}
+	if optionsHash(nil) != optionsHash(map[string]string{}) {
+		t.Error("no options hash differently from empty options")
+	}
+}
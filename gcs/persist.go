@@ -0,0 +1,114 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	errBadVolumeFile = errors.New("file is named after another volume")
+	errLostOptions   = errors.New("options of the volume could not be read when the plugin started, refusing to mount it without them; check the logs of the plugin, then create the volume again")
+)
+
+// Directory below root that holds the options of volumes, one file per
+// volume named like "<name>.json", so they survive a restart of the
+// plugin. Docker does not create volumes again, it only mounts them.
+const volumesDir = ".volumes"
+
+type volumeFile struct {
+	Name    string            `json:"name"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+func volumePath(root, name string) string {
+	return filepath.Join(root, volumesDir, url.PathEscape(name)+".json")
+}
+
+// persist writes the options of volume name, replacing the file
+// atomically.
+func (d Driver) persist(name string, opts map[string]string) error {
+	b, err := json.Marshal(volumeFile{Name: name, Options: opts})
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(d.cfg.Root, volumesDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	path := volumePath(d.cfg.Root, name)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// forget removes the options of volume name.
+func (d Driver) forget(name string) {
+	if err := os.Remove(volumePath(d.cfg.Root, name)); err != nil && !os.IsNotExist(err) {
+		warnf("Removing the options of volume %s failed: %s", name, err)
+	}
+}
+
+// loadVolumes reads the options that persist wrote below root. Files that
+// cannot be read are skipped, Mount refuses those volumes.
+func loadVolumes(root string) (opts map[string]map[string]string, broken map[string]bool, err error) {
+	opts, broken = make(map[string]map[string]string), make(map[string]bool)
+	files, err := ioutil.ReadDir(filepath.Join(root, volumesDir))
+	if os.IsNotExist(err) {
+		return opts, broken, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	for _, fi := range files {
+		if !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		name, err := url.PathUnescape(strings.TrimSuffix(fi.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(root, volumesDir, fi.Name())
+		var v volumeFile
+		b, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(b, &v)
+		}
+		if err == nil && v.Name != name {
+			err = errBadVolumeFile
+		}
+		if err != nil {
+			errorf("Reading the options of volume %s from %s failed: %s", name, path, err)
+			broken[name] = true
+			continue
+		}
+		opts[name] = v.Options
+	}
+	return opts, broken, nil
+}
@@ -0,0 +1,118 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// restart creates a driver on the root of d, like the plugin does when it
+// is started again.
+func restart(t *testing.T, d *Driver, r runner) *Driver {
+	t.Helper()
+	n, err := New(*d.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.run = r
+	return n
+}
+
+func TestPersistOptions(t *testing.T) {
+	vols := map[string]map[string]string{
+		"b":        nil,
+		"b/ro":     {"access": "ro"},
+		"b/sub":    {"only_dir": "true", "access": "ro"},
+		"gs://c/x": {"key_file": "/k.json"},
+	}
+	d := newTestDriver(t, Config{}, &fakeRunner{})
+	for name, opts := range vols {
+		mustCreate(t, d, name, opts)
+	}
+	mustCreate(t, d, "gone", nil)
+	if err := d.Remove(&volume.RemoveRequest{Name: "gone"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.rename("b/ro", "b/renamed"); err != nil {
+		t.Fatal(err)
+	}
+	vols["b/renamed"] = vols["b/ro"]
+	delete(vols, "b/ro")
+
+	d = restart(t, d, &fakeRunner{})
+	want := make(map[string]map[string]string)
+	for name, opts := range vols {
+		want[normalize(name)] = opts
+	}
+	if !reflect.DeepEqual(d.opts, want) {
+		t.Errorf("options after restart are %v, want %v", d.opts, want)
+	}
+}
+
+func TestPersistRemount(t *testing.T) {
+	d := newTestDriver(t, Config{}, &fakeRunner{})
+	mustCreate(t, d, "b", nil)
+	if err := d.remount("b", map[string]string{"access": "ro"}); err != nil {
+		t.Fatal(err)
+	}
+
+	run := &fakeRunner{output: successLine}
+	d = restart(t, d, run)
+	if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if m := d.cmds["b"]; m.access != "ro" {
+		t.Errorf("mounted %s after restart, want ro", m.access)
+	}
+}
+
+func TestPersistBroken(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+	}{
+		{"garbage", "{"},
+		{"other name", `{"name":"c"}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newTestDriver(t, Config{}, &fakeRunner{})
+			mustCreate(t, d, "b", map[string]string{"access": "ro"})
+			if err := ioutil.WriteFile(volumePath(d.cfg.Root, "b"), []byte(tc.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			run := &fakeRunner{output: successLine}
+			d = restart(t, d, run)
+			if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"}); err != errLostOptions {
+				t.Errorf("mounting got %v, want %v", err, errLostOptions)
+			}
+			if n := run.starts(); n != 0 {
+				t.Errorf("started gcsfuse %d times, want never", n)
+			}
+
+			// Creating it again fixes it.
+			mustCreate(t, d, "b", map[string]string{"access": "ro"})
+			if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"}); err != nil {
+				t.Errorf("mounting after creating again: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// prefix rewrites volume names starting with from to start with to
+// instead, see Config.PrefixMap.
+type prefix struct {
+	from, to string
+}
+
+// readPrefixes parses a file that contains one mapping "<from> <to>" per
+// line, e.g. "old-name/ new-bucket/archive/", with blank lines and lines
+// starting with '#' being ignored. Longer prefixes come first, so that
+// the most specific one wins.
+func readPrefixes(path string) ([]prefix, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ps []prefix
+	s := bufio.NewScanner(io.LimitReader(f, maxConfSize+1))
+	n, size := 0, 0
+	for s.Scan() {
+		n++
+		size += len(s.Bytes()) + 1
+		if size > maxConfSize {
+			return nil, errBadConf{path: path, line: n, msg: fmt.Sprintf("file is larger than %d bytes", maxConfSize)}
+		}
+
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		fields := strings.Fields(l)
+		if len(fields) != 2 {
+			return nil, errBadConf{path: path, line: n, msg: "expected a prefix and its replacement"}
+		}
+		from, to := strings.TrimPrefix(normalize(fields[0]), scheme), normalize(fields[1])
+		if from == "" || strings.TrimPrefix(to, scheme) == "" {
+			return nil, errBadConf{path: path, line: n, msg: "empty prefix or replacement"}
+		}
+		ps = append(ps, prefix{from: from, to: to})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(ps, func(i, j int) bool { return len(ps[i].from) > len(ps[j].from) })
+	return ps, nil
+}
+
+// resolve applies the first matching prefix to volume name, which must be
+// normalized. Prefixes match whole path segments, so "old" rewrites
+// "old/sub" but not "older", with or without scheme. The result is what
+// the bucket and subpath are derived from.
+func (d Driver) resolve(name string) string {
+	n := strings.TrimPrefix(name, scheme)
+	for _, p := range d.prefixes {
+		if n == p.from {
+			return p.to
+		}
+		if strings.HasPrefix(n, p.from+"/") {
+			return p.to + n[len(p.from):]
+		}
+	}
+	return name
+}
@@ -0,0 +1,115 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestReadPrefixes(t *testing.T) {
+	big := strings.Repeat("# padding\n", maxConfSize/10+1)
+	for _, tc := range []struct {
+		name    string
+		content string
+		want    []prefix
+		err     func(path string) error
+	}{
+		{"empty", "", nil, nil},
+		{"comments and blanks", "# moved\n\n  old new  \n", []prefix{{"old", "new"}}, nil},
+		{"normalized", "gs://old/ gs://new//archive/\n", []prefix{{"old", "gs://new/archive"}}, nil},
+		{"longest first", "a x\na/b/c z\na/b y\n", []prefix{{"a/b/c", "z"}, {"a/b", "y"}, {"a", "x"}}, nil},
+		{"missing replacement", "old\n", nil, func(p string) error {
+			return errBadConf{path: p, line: 1, msg: "expected a prefix and its replacement"}
+		}},
+		{"too many fields", "\nold new extra\n", nil, func(p string) error {
+			return errBadConf{path: p, line: 2, msg: "expected a prefix and its replacement"}
+		}},
+		{"empty prefix", "/ new\n", nil, func(p string) error { return errBadConf{path: p, line: 1, msg: "empty prefix or replacement"} }},
+		{"empty replacement", "old gs://\n", nil, func(p string) error { return errBadConf{path: p, line: 1, msg: "empty prefix or replacement"} }},
+		{"too large", big, nil, func(p string) error {
+			return errBadConf{path: p, line: maxConfSize/10 + 1, msg: "file is larger than 65536 bytes"}
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "prefixes")
+			if err := ioutil.WriteFile(path, []byte(tc.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			var want error
+			if tc.err != nil {
+				want = tc.err(path)
+			}
+			got, err := readPrefixes(path)
+			if !reflect.DeepEqual(err, want) {
+				t.Fatalf("got error %v, want %v", err, want)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := readPrefixes(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("reading a missing file succeeded")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	d := Driver{prefixes: []prefix{{"old/sub", "other"}, {"old", "new/archive"}}}
+	for name, want := range map[string]string{
+		"old":          "new/archive",
+		"old/x":        "new/archive/x",
+		"gs://old/x":   "new/archive/x",
+		"old/sub":      "other",
+		"old/sub/x":    "other/x",
+		"old/subx":     "new/archive/subx",
+		"older":        "older",
+		"gs://older":   "gs://older",
+		"unrelated/ol": "unrelated/ol",
+	} {
+		if got := d.resolve(name); got != want {
+			t.Errorf("resolve(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestMountPrefixMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prefixes")
+	if err := ioutil.WriteFile(path, []byte("old new/archive\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	run := &fakeRunner{output: successLine}
+	d := newTestDriver(t, Config{PrefixMap: path}, run)
+	mustCreate(t, d, "old/x", nil)
+	res, err := d.Mount(&volume.MountRequest{Name: "old/x", ID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(d.cfg.Root, "new/archive/x"); res.Mountpoint != want {
+		t.Errorf("mounted at %s, want %s", res.Mountpoint, want)
+	}
+	args := run.started[0]
+	if got := args[len(args)-2]; got != "new" {
+		t.Errorf("gcsfuse mounts bucket %s, want new", got)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import "errors"
+
+var errNotSupported = errors.New("not supported on this platform")
+
+// procInfo gives insight into running processes. Its implementation
+// depends on the platform, on Linux it is backed by /proc.
+type procInfo interface {
+	// alive tells whether the process with the given pid is running.
+	alive(pid int) bool
+
+	// rss returns the resident set size of the process in bytes, or
+	// errNotSupported.
+	rss(pid int) (uint64, error)
+
+	// list returns all processes that are running.
+	list() ([]procEntry, error)
+
+	// openFiles returns the number of files the driver has open.
+	openFiles() (int, error)
+
+	// mounts returns the file systems that are mounted, or
+	// errNotSupported.
+	mounts() ([]mountEntry, error)
+
+	// zombies returns the children of the driver that exited, but were
+	// not waited for yet.
+	zombies() ([]int, error)
+}
+
+type procEntry struct {
+	pid  int
+	args []string
+}
+
+type mountEntry struct {
+	point  string
+	fstype string
+}
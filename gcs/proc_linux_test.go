@@ -0,0 +1,94 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package gcs
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestUnescapeMount(t *testing.T) {
+	for s, want := range map[string]string{
+		"/mnt/b":                "/mnt/b",
+		`/mnt/some\040dir`:      "/mnt/some dir",
+		`/mnt/tab\011and\012nl`: "/mnt/tab\tand\nnl",
+		`/mnt/back\134slash`:    `/mnt/back\slash`,
+		`/mnt/short\04`:         `/mnt/short\04`,
+		`/mnt/not\999octal`:     `/mnt/not\999octal`,
+		`/mnt/trailing\`:        `/mnt/trailing\`,
+	} {
+		if got := unescapeMount(s); got != want {
+			t.Errorf("unescapeMount(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestProcfs(t *testing.T) {
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	child := cmd.Process.Pid
+	defer cmd.Process.Kill()
+
+	// The arguments of the child only show once it is done with execve,
+	// which Start does not wait for.
+	var args []string
+	for deadline := time.Now().Add(time.Second); args == nil && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		ps, err := procs.list()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range ps {
+			if p.pid == child {
+				args = p.args
+			}
+		}
+	}
+	if len(args) != 2 || args[1] != "60" {
+		t.Errorf("listed the child with arguments %q, want sleep 60", args)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		pid   int
+		alive bool
+	}{
+		{"self", os.Getpid(), true},
+		{"child", child, true},
+		{"nonexistent", 1 << 30, false},
+	} {
+		if got := procs.alive(tc.pid); got != tc.alive {
+			t.Errorf("%s is alive: %t, want %t", tc.name, got, tc.alive)
+		}
+	}
+	if rss, err := procs.rss(os.Getpid()); err != nil || rss == 0 {
+		t.Errorf("got rss %d and error %v, want some memory", rss, err)
+	}
+	if _, err := procs.rss(1 << 30); err == nil {
+		t.Error("got rss of a nonexistent process")
+	}
+
+	// Once it exits, it is a zombie until waited for.
+	cmd.Process.Kill()
+	cmd.Wait()
+	if procs.alive(child) {
+		t.Error("child is alive after it was killed and waited for")
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd netbsd openbsd solaris
+
+package gcs
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var procs procInfo = portable{}
+
+// portable makes do with system calls that are available everywhere.
+type portable struct{}
+
+func (portable) alive(pid int) bool {
+	// Note that this also holds for zombies.
+	return syscall.Kill(pid, 0) == nil
+}
+
+func (portable) rss(pid int) (uint64, error) {
+	return 0, errNotSupported
+}
+
+func (portable) openFiles() (int, error) {
+	fds, err := ioutil.ReadDir("/dev/fd")
+	if err != nil {
+		return 0, err
+	}
+	return len(fds), nil
+}
+
+// zombies relies on ps.
+func (portable) zombies() ([]int, error) {
+	out, err := exec.Command("ps", "-axo", "pid=,ppid=,stat=").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	self := strconv.Itoa(os.Getpid())
+	var pids []int
+	for _, l := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(l)
+		if len(fields) < 3 || fields[1] != self || !strings.HasPrefix(fields[2], "Z") {
+			continue
+		}
+		if pid, err := strconv.Atoi(fields[0]); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// setSubreaper is only implemented for Linux.
+func setSubreaper() error {
+	return errNotSupported
+}
+
+func (portable) mounts() ([]mountEntry, error) {
+	return nil, errNotSupported
+}
+
+// list relies on ps, arguments that contain spaces are split.
+func (portable) list() ([]procEntry, error) {
+	out, err := exec.Command("ps", "-axo", "pid=,command=").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var ps []procEntry
+	for _, l := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(l)
+		if len(fields) < 2 {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		ps = append(ps, procEntry{pid: pid, args: fields[1:]})
+	}
+	return ps, nil
+}
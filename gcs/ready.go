@@ -0,0 +1,127 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	// How long to wait for the mountpoint to show up in the mount table,
+	// see Config.Readiness.
+	readinessTimeout = 2 * time.Minute
+
+	// How often to look it up.
+	readinessPoll = 50 * time.Millisecond
+
+	// How long the output of gcsfuse may take to end once it exited, to
+	// tell why.
+	readinessDrain = time.Second
+)
+
+var errNotMounted = fmt.Errorf("gcsfuse did not mount the bucket within %s; check the logs of the plugin for its output", readinessTimeout)
+
+type errReadiness struct {
+	readiness string
+}
+
+func (e errReadiness) Error() string {
+	return fmt.Sprintf("unknown readiness check %q, use output, mounts or either", e.readiness)
+}
+
+// awaitReady waits until gcsfuse, which reads from r and mounts at mnt,
+// is ready according to Config.Readiness, for up to readinessTimeout. Its
+// output is copied to w. With "mounts", the mount table decides, with
+// "either", the mount table or the output of gcsfuse that awaitMounted
+// looks for. The output still tells why gcsfuse failed if it exits.
+func (d Driver) awaitReady(r io.Reader, w io.Writer, b, mnt string, daemon process) error {
+	out := make(chan error, 1)
+	d.copiers.Add(1)
+	go func() {
+		defer d.copiers.Done()
+		out <- awaitMounted(io.TeeReader(r, w), b)
+		io.Copy(w, r)
+	}()
+
+	if d.cfg.Readiness == "output" {
+		timeout := time.NewTimer(readinessTimeout)
+		defer timeout.Stop()
+		select {
+		case err := <-out:
+			return err
+		case <-timeout.C:
+			return errNotMounted
+		}
+	}
+
+	tick := time.NewTicker(readinessPoll)
+	defer tick.Stop()
+
+	// Why gcsfuse failed according to its output, if it exits.
+	failed := errExited
+	for deadline := time.Now().Add(readinessTimeout); ; {
+		select {
+		case err := <-out:
+			if err == nil && d.cfg.Readiness == "either" {
+				return nil
+			}
+			if err != nil {
+				failed = err
+			}
+			// Only the mount table counts from now on.
+			out = nil
+		case <-tick.C:
+		}
+
+		if mounted(mnt) {
+			return nil
+		}
+		if !daemon.alive() {
+			if out != nil {
+				select {
+				case err := <-out:
+					if err != nil {
+						failed = err
+					}
+				case <-time.After(readinessDrain):
+				}
+			}
+			return failed
+		}
+		if time.Now().After(deadline) {
+			return errNotMounted
+		}
+	}
+}
+
+// mounted tells whether a FUSE file system is mounted at mnt according to
+// the mount table, or, where there is none, whether anything is.
+func mounted(mnt string) bool {
+	ms, err := procs.mounts()
+	if err != nil {
+		return isMountpoint(mnt)
+	}
+	for _, m := range ms {
+		if m.point == mnt && strings.HasPrefix(m.fstype, "fuse") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestAwaitReadyExplains(t *testing.T) {
+	for _, readiness := range []string{"output", "mounts", "either"} {
+		for _, tc := range []struct {
+			output string
+			late   bool
+			err    error
+		}{
+			{"bucket doesn't exist\n", false, errBucketNotFound{bucket: "b"}},
+			{"bucket doesn't exist\n", true, errBucketNotFound{bucket: "b"}},
+			{"Error 403: denied\n", true, errPermissionDenied{bucket: "b", output: "Error 403: denied"}},
+			{"", true, errBadRead{cause: io.EOF}},
+		} {
+			t.Run(readiness, func(t *testing.T) {
+				d := newTestDriver(t, Config{Readiness: readiness}, &fakeRunner{output: tc.output, late: tc.late})
+				mustCreate(t, d, "b", nil)
+				_, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"})
+				if !reflect.DeepEqual(err, tc.err) {
+					t.Errorf("got %v, want %v", err, tc.err)
+				}
+			})
+		}
+	}
+}
+
+// fakeMounts makes the mount table consist of ms, or fail with err.
+type fakeMounts struct {
+	procInfo
+	ms  []mountEntry
+	err error
+}
+
+func (f fakeMounts) mounts() ([]mountEntry, error) {
+	return f.ms, f.err
+}
+
+func withMounts(t *testing.T, ms []mountEntry, err error) {
+	orig := procs
+	procs = fakeMounts{orig, ms, err}
+	t.Cleanup(func() { procs = orig })
+}
+
+func TestMounted(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name    string
+		ms      []mountEntry
+		err     error
+		mounted bool
+	}{
+		{"fuse", []mountEntry{{"/", "ext4"}, {dir, "fuse.gcsfuse"}}, nil, true},
+		{"plain fuse", []mountEntry{{dir, "fuse"}}, nil, true},
+		{"other file system", []mountEntry{{dir, "tmpfs"}}, nil, false},
+		{"elsewhere", []mountEntry{{dir + "/sub", "fuse.gcsfuse"}}, nil, false},
+		{"empty", nil, nil, false},
+		// The directory is not a mountpoint either.
+		{"no mount table", []mountEntry{{dir, "fuse.gcsfuse"}}, errNotSupported, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withMounts(t, tc.ms, tc.err)
+			if got := mounted(dir); got != tc.mounted {
+				t.Errorf("got %t, want %t", got, tc.mounted)
+			}
+		})
+	}
+}
+
+func TestAwaitReady(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		readiness string
+		output    string
+		mounted   bool
+		exits     bool
+		err       error
+	}{
+		{name: "output", readiness: "output", output: successLine},
+		{name: "mounts", readiness: "mounts", mounted: true},
+		// gcsfuse claims so, but the mount table decides.
+		{name: "mounts despite output", readiness: "mounts", output: successLine, exits: true, err: errExited},
+		{name: "either by output", readiness: "either", output: successLine},
+		{name: "either by mounts", readiness: "either", mounted: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mnt := t.TempDir()
+			var ms []mountEntry
+			if tc.mounted {
+				ms = []mountEntry{{mnt, "fuse.gcsfuse"}}
+			}
+			withMounts(t, ms, nil)
+
+			d := newTestDriver(t, Config{Readiness: tc.readiness}, &fakeRunner{})
+			pr, pw := io.Pipe()
+			daemon := &fakeProcess{r: &fakeRunner{}, once: new(sync.Once), done: make(chan struct{}), stderr: pw}
+			output, exits := tc.output, tc.exits
+			go func() {
+				io.Copy(pw, strings.NewReader(output))
+				if exits {
+					time.Sleep(2 * readinessPoll)
+					daemon.exit()
+				}
+			}()
+
+			var out bytes.Buffer
+			err := d.awaitReady(pr, &out, "b", mnt, daemon)
+			if err != tc.err {
+				t.Errorf("got %v, want %v", err, tc.err)
+			}
+
+			// All of the output is copied, once gcsfuse exits.
+			daemon.exit()
+			d.copiers.Wait()
+			if out.String() != tc.output {
+				t.Errorf("copied %q, want %q", out.String(), tc.output)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Zombies are only reaped once they were seen for that long. Until then,
+// they might be processes that the driver started itself, and that are
+// about to be waited for.
+const reapGrace = time.Second
+
+var reapedZombies = newCounter("gcs_zombies_reaped_total", "Number of exited processes that the driver reaped without having started them.")
+
+// reapZombies waits for children that nobody else waits for, whenever a
+// child exits, see Config.ReapZombies. Instances of gcsfuse are waited for
+// by supervise, which also updates the state of their mounts.
+func reapZombies() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGCHLD)
+
+	seen := make(map[int]time.Time)
+	var again <-chan time.Time
+	for {
+		select {
+		case <-sigs:
+		case <-again:
+		}
+
+		seen, again = reap(seen, time.Now()), nil
+		if len(seen) > 0 {
+			again = time.After(reapGrace)
+		}
+	}
+}
+
+// reap waits for the zombies that were first seen at least reapGrace
+// before now, according to seen. It returns when the others were first
+// seen.
+func reap(seen map[int]time.Time, now time.Time) map[int]time.Time {
+	pids, err := procs.zombies()
+	if err != nil {
+		warnf("Looking for zombies failed: %s", err)
+		return seen
+	}
+
+	next := make(map[int]time.Time)
+	for _, pid := range pids {
+		first, ok := seen[pid]
+		if !ok {
+			first = now
+		}
+		if now.Sub(first) < reapGrace {
+			next[pid] = first
+			continue
+		}
+		var ws syscall.WaitStatus
+		if p, err := syscall.Wait4(pid, &ws, syscall.WNOHANG, nil); err == nil && p == pid {
+			debugf("Reaped process %d, which exited with %d.", pid, ws.ExitStatus())
+			reapedZombies.add(1)
+		}
+	}
+	return next
+}
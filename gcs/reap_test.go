@@ -0,0 +1,136 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// zombie starts a child that exits right away, without waiting for it.
+func zombie(t *testing.T) int {
+	t.Helper()
+	pid, err := syscall.ForkExec("/bin/sh", []string{"sh", "-c", "exit 3"}, &syscall.ProcAttr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		var ws syscall.WaitStatus
+		syscall.Wait4(pid, &ws, syscall.WNOHANG, nil)
+	})
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if isZombie(t, pid) {
+			return pid
+		}
+	}
+	t.Fatalf("process %d did not become a zombie", pid)
+	return 0
+}
+
+func isZombie(t *testing.T, pid int) bool {
+	t.Helper()
+	pids, err := procs.zombies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range pids {
+		if p == pid {
+			return true
+		}
+	}
+	return false
+}
+
+// Running children, and those that are waited for, are no zombies.
+func TestZombies(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	if isZombie(t, cmd.Process.Pid) {
+		t.Errorf("running process %d is a zombie", cmd.Process.Pid)
+	}
+	pid := zombie(t)
+	var ws syscall.WaitStatus
+	if _, err := syscall.Wait4(pid, &ws, 0, nil); err != nil || ws.ExitStatus() != 3 {
+		t.Fatalf("waiting for %d: %v, %d", pid, err, ws.ExitStatus())
+	}
+	if isZombie(t, pid) {
+		t.Errorf("process %d is a zombie after waiting for it", pid)
+	}
+}
+
+func TestReap(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		age    time.Duration
+		unseen bool
+		reaped bool
+	}{
+		{name: "new", unseen: true},
+		{name: "young", age: reapGrace / 2},
+		{name: "old", age: reapGrace, reaped: true},
+		{name: "older", age: time.Hour, reaped: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pid := zombie(t)
+			now := time.Now()
+			seen := map[int]time.Time{pid: now.Add(-tc.age)}
+			if tc.unseen {
+				seen = map[int]time.Time{}
+			}
+			before := reapedZombies.get()
+
+			next := reap(seen, now)
+			if n := reapedZombies.get() - before; n != map[bool]float64{true: 1}[tc.reaped] {
+				t.Errorf("reaped %v zombies", n)
+			}
+			if gone := !isZombie(t, pid); gone != tc.reaped {
+				t.Errorf("process %d gone: %t, want %t", pid, gone, tc.reaped)
+			}
+			// Zombies that are kept remember when they were first seen.
+			first, kept := next[pid]
+			if kept == tc.reaped || kept && !first.Equal(now.Add(-tc.age)) {
+				t.Errorf("kept %t, first seen %s, want %t", kept, first, !tc.reaped)
+			}
+		})
+	}
+}
+
+type failingProcs struct{ procInfo }
+
+func (failingProcs) zombies() ([]int, error) {
+	return nil, errors.New("no ps")
+}
+
+// If zombies cannot be listed, what was seen is kept for the next try.
+func TestReapFailing(t *testing.T) {
+	orig := procs
+	procs = failingProcs{orig}
+	defer func() { procs = orig }()
+
+	seen := map[int]time.Time{1234: time.Now()}
+	if next := reap(seen, time.Now().Add(time.Hour)); len(next) != 1 || !next[1234].Equal(seen[1234]) {
+		t.Errorf("got %v, want %v", next, seen)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var driftDetected = newCounter("gcs_mount_drift_total", "Number of discrepancies between mounted buckets and the mount table.")
+
+// reconcile periodically compares the buckets that are mounted according
+// to the driver with the mount table of the kernel, see
+// Config.ReconcileInterval. Only discrepancies that persist over two
+// rounds count, so that mounts in progress are not mistaken for drift.
+func (d Driver) reconcile() {
+	// Found in the previous round.
+	vanished := make(map[*mount]bool)
+	unknown := make(map[string]bool)
+
+	for range time.Tick(d.cfg.ReconcileInterval) {
+		ms, err := procs.mounts()
+		if err == errNotSupported {
+			warnf("Reconciling with the mount table is not supported on this platform.")
+			return
+		}
+		if err != nil {
+			errorf("Reading the mount table failed: %s", err)
+			continue
+		}
+
+		mounted := make(map[string]bool)
+		for _, e := range ms {
+			if strings.HasPrefix(e.fstype, "fuse") {
+				mounted[e.point] = true
+			}
+		}
+
+		d.Lock()
+		vanished = d.reconcileVanished(mounted, vanished)
+		unknown = d.reconcileUnknown(mounted, unknown)
+		d.Unlock()
+	}
+}
+
+// reconcileVanished looks for buckets that are not in the mount table
+// although gcsfuse runs for them, and returns them. Those that vanished
+// in the previous round already are interrupted with Config.ReconcileFix,
+// then supervise takes over.
+func (d Driver) reconcileVanished(mounted map[string]bool, prev map[*mount]bool) map[*mount]bool {
+	next := make(map[*mount]bool)
+	for k, m := range d.cmds {
+		if m.proc == nil || m.err != nil || m.failed != "" || mounted[d.target(k)] {
+			continue
+		}
+		next[m] = true
+		if !prev[m] {
+			continue
+		}
+
+		driftDetected.add(1, "kind", "vanished")
+		if !d.cfg.ReconcileFix {
+			warnf("%s is not mounted anymore, although gcsfuse %s is running.", d.target(k), k)
+			continue
+		}
+		warnf("%s is not mounted anymore, interrupting gcsfuse %s.", d.target(k), k)
+		m.proc.signal(d.teardown)
+	}
+	return next
+}
+
+// reconcileUnknown looks for FUSE file systems mounted below the root that
+// the driver does not know about, and returns them. They are only logged,
+// see Orphan.
+func (d Driver) reconcileUnknown(mounted map[string]bool, prev map[string]bool) map[string]bool {
+	known := make(map[string]bool)
+	for k := range d.cmds {
+		known[d.target(k)] = true
+	}
+
+	next := make(map[string]bool)
+	for mnt := range mounted {
+		if known[mnt] {
+			continue
+		}
+		if rel, err := filepath.Rel(d.cfg.Root, mnt); err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		next[mnt] = true
+		if prev[mnt] {
+			driftDetected.add(1, "kind", "unknown")
+			warnf("%s is mounted, but not by this driver. See `docker-volume-gcs orphans`.", mnt)
+		}
+	}
+	return next
+}
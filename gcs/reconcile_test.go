@@ -0,0 +1,75 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestReconcile(t *testing.T) {
+	for _, fix := range []bool{false, true} {
+		d := newTestDriver(t, Config{ReconcileFix: fix}, &fakeRunner{output: successLine})
+		for _, b := range []string{"a", "b"} {
+			mustCreate(t, d, b, nil)
+			if _, err := d.Mount(&volume.MountRequest{Name: b, ID: "1"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		a, b := d.cmds["a"], d.cmds["b"]
+		unknown := filepath.Join(d.cfg.Root, "x")
+		mounted := map[string]bool{d.target("a"): true, unknown: true, "/elsewhere": true}
+		vanishedBefore, unknownBefore := driftDetected.get("kind", "vanished"), driftDetected.get("kind", "unknown")
+
+		var vanished map[*mount]bool
+		var strays map[string]bool
+		for round := 1; round <= 2; round++ {
+			d.Lock()
+			vanished = d.reconcileVanished(mounted, vanished)
+			strays = d.reconcileUnknown(mounted, strays)
+			d.Unlock()
+
+			if want := map[*mount]bool{b: true}; !reflect.DeepEqual(vanished, want) {
+				t.Errorf("fix=%t, round %d: got vanished %v, want b", fix, round, vanished)
+			}
+			if want := map[string]bool{unknown: true}; !reflect.DeepEqual(strays, want) {
+				t.Errorf("fix=%t, round %d: got unknown %v, want %v", fix, round, strays, want)
+			}
+			// Only discrepancies that persist count.
+			want := float64(round - 1)
+			if n := driftDetected.get("kind", "vanished") - vanishedBefore; n != want {
+				t.Errorf("fix=%t, round %d: counted %g vanished, want %g", fix, round, n, want)
+			}
+			if n := driftDetected.get("kind", "unknown") - unknownBefore; n != want {
+				t.Errorf("fix=%t, round %d: counted %g unknown, want %g", fix, round, n, want)
+			}
+			if round == 1 && !b.proc.alive() {
+				t.Errorf("fix=%t: interrupted gcsfuse right away", fix)
+			}
+		}
+
+		if !a.proc.alive() {
+			t.Errorf("fix=%t: interrupted gcsfuse that is mounted", fix)
+		}
+		if b.proc.alive() != !fix {
+			t.Errorf("fix=%t: gcsfuse that vanished is alive: %t", fix, b.proc.alive())
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+var bucketLocation = newGauge("gcs_bucket_location_info", "Location of mounted buckets.")
+
+// locate looks up the location of bucket b with the credentials of the
+// key file at key, see token, unless it is known already. It is safe to
+// call without holding the lock.
+func (d Driver) locate(b, key string) {
+	d.Lock()
+	_, ok := d.regions[b]
+	d.Unlock()
+	if ok {
+		return
+	}
+
+	var r struct {
+		Location string `json:"location"`
+	}
+	if err := storageGet(key, "/b/"+url.PathEscape(b), url.Values{"fields": {"location"}}, &r); err != nil {
+		warnf("Looking up location of %s failed: %s", b, err)
+		return
+	}
+	loc := r.Location
+
+	d.Lock()
+	d.regions[b] = loc
+	d.Unlock()
+
+	bucketLocation.set(1, "bucket", b, "location", loc)
+
+	if d.cfg.Region != "" && area(loc) != area(d.cfg.Region) {
+		warnf("Bucket %s is located in %s, far away from %s. Expect high latency.", b, loc, d.cfg.Region)
+	}
+}
+
+// metadataRegion asks the Compute Engine metadata server for the region
+// this host runs in. It returns the empty string when not on GCE.
+func metadataRegion() string {
+	req, err := http.NewRequest("GET", metadataEndpoint+"/instance/zone", nil)
+	if err != nil {
+		return ""
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	c := http.Client{Timeout: 2 * time.Second}
+	res, err := c.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil || res.StatusCode != http.StatusOK {
+		return ""
+	}
+
+	// The zone looks like "projects/123/zones/us-central1-a".
+	zone := path.Base(string(b))
+	if i := strings.LastIndex(zone, "-"); i != -1 {
+		return zone[:i]
+	}
+	return ""
+}
+
+// area maps a bucket location (e.g. "US", "EUR4", "ASIA-EAST1") or a
+// Compute Engine region (e.g. "us-central1") to a coarse geographic area,
+// which is good enough to tell whether the two are far apart.
+func area(loc string) string {
+	loc = strings.ToLower(loc)
+	switch {
+	case loc == "us", loc == "nam4", strings.HasPrefix(loc, "us-"), strings.HasPrefix(loc, "northamerica-"):
+		return "northamerica"
+	case loc == "eu", loc == "eur4", strings.HasPrefix(loc, "europe-"):
+		return "europe"
+	case loc == "asia", loc == "asia1", strings.HasPrefix(loc, "asia-"):
+		return "asia"
+	}
+	if i := strings.Index(loc, "-"); i != -1 {
+		return loc[:i]
+	}
+	return loc
+}
@@ -0,0 +1,102 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLocate(t *testing.T) {
+	serveGoogle(t, &fakeGoogle{
+		perms:     map[string][]string{"us": nil, "eu": nil},
+		locations: map[string]string{"us": "US-CENTRAL1", "eu": "EUROPE-WEST1"},
+	})
+
+	for _, tc := range []struct {
+		bucket string
+		want   string
+		ok     bool
+	}{
+		{"us", "US-CENTRAL1", true},
+		{"eu", "EUROPE-WEST1", true},
+		// Not cached, so that it is looked up again.
+		{"missing", "", false},
+	} {
+		d := newTestDriver(t, Config{Region: "us-east1"}, &fakeRunner{})
+		d.locate(tc.bucket, "")
+		if got, ok := d.regions[tc.bucket]; got != tc.want || ok != tc.ok {
+			t.Errorf("location of %s is %q (%t), want %q (%t)", tc.bucket, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestMetadataRegion(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		status int
+		zone   string
+		want   string
+	}{
+		{"zone", http.StatusOK, "projects/123/zones/us-central1-a", "us-central1"},
+		{"unexpected", http.StatusOK, "nowhere", ""},
+		{"not on GCE", http.StatusNotFound, "", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/computeMetadata/v1/instance/zone" || r.Header.Get("Metadata-Flavor") != "Google" {
+					http.NotFound(w, r)
+					return
+				}
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.zone))
+			}))
+			defer srv.Close()
+			saved := metadataEndpoint
+			metadataEndpoint = srv.URL + "/computeMetadata/v1"
+			defer func() { metadataEndpoint = saved }()
+
+			if got := metadataRegion(); got != tc.want {
+				t.Errorf("got region %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestArea(t *testing.T) {
+	for _, tc := range []struct {
+		loc  string
+		want string
+	}{
+		{"US", "northamerica"},
+		{"NAM4", "northamerica"},
+		{"us-central1", "northamerica"},
+		{"NORTHAMERICA-NORTHEAST1", "northamerica"},
+		{"EU", "europe"},
+		{"EUR4", "europe"},
+		{"europe-west1", "europe"},
+		{"ASIA", "asia"},
+		{"asia-east1", "asia"},
+		{"australia-southeast1", "australia"},
+		{"me-west1", "me"},
+	} {
+		if got := area(tc.loc); got != tc.want {
+			t.Errorf("area(%s) = %s, want %s", tc.loc, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// runner starts instances of gcsfuse. It exists so that gcsfuse can be
+// replaced by a fake.
+type runner interface {
+	// start launches gcsfuse with the given arguments and environment.
+	// Its standard error is returned, and reaches EOF when the process
+	// exits.
+	start(args, env []string) (process, io.Reader, error)
+}
+
+// process is a running instance of gcsfuse.
+type process interface {
+	pid() int
+	alive() bool
+	signal(sig os.Signal) error
+
+	// wait blocks until the process exits. It may be called more than
+	// once, and from several goroutines.
+	wait() (exit, error)
+}
+
+// exit describes how a process terminated.
+type exit struct {
+	// Exit code, or -1 if the process was killed by a signal.
+	code int
+
+	// The signal that killed the process, if any.
+	signal os.Signal
+}
+
+func (e exit) success() bool {
+	return e.code == 0
+}
+
+// execRunner runs the real gcsfuse, by means of wrapper if it is set.
+type execRunner struct {
+	wrapper []string
+}
+
+func (r execRunner) start(args, env []string) (process, io.Reader, error) {
+	argv := append(append([]string{}, r.wrapper...), "gcsfuse")
+	argv = append(argv, args...)
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = env
+	cmd.Stdout = os.Stdout
+	rc, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, nil, errBadRead{err}
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+	p := &execProcess{Process: cmd.Process, done: make(chan struct{})}
+	go p.reap()
+	return p, rc, nil
+}
+
+type execProcess struct {
+	*os.Process
+
+	// Closed once the process was waited for, then ex and err are set.
+	done chan struct{}
+	ex   exit
+	err  error
+}
+
+func (p *execProcess) reap() {
+	defer close(p.done)
+
+	ps, err := p.Wait()
+	if err != nil {
+		p.err = err
+		return
+	}
+
+	p.ex = exit{code: ps.ExitCode()}
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		p.ex.signal = ws.Signal()
+	}
+}
+
+func (p *execProcess) pid() int {
+	return p.Pid
+}
+
+// alive asks procs only as long as the process was not reaped, since its
+// pid might be reused afterwards.
+func (p *execProcess) alive() bool {
+	select {
+	case <-p.done:
+		return false
+	default:
+		return procs.alive(p.Pid)
+	}
+}
+
+func (p *execProcess) signal(sig os.Signal) error {
+	return p.Signal(sig)
+}
+
+func (p *execProcess) wait() (exit, error) {
+	<-p.done
+	return p.ex, p.err
+}
@@ -0,0 +1,68 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+func TestExecProcessAlive(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		pid    int
+		reaped bool
+		want   bool
+	}{
+		{"running", os.Getpid(), false, true},
+		{"reaped with reused pid", os.Getpid(), true, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := procs.rss(os.Getpid()); err == errNotSupported {
+				t.Skip("no process information on this platform")
+			}
+			p := &execProcess{Process: &os.Process{Pid: tc.pid}, done: make(chan struct{})}
+			if tc.reaped {
+				close(p.done)
+			}
+			if got := p.alive(); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestExecRunner(t *testing.T) {
+	run := execRunner{wrapper: []string{"sh", "-c", `echo "$@" >&2`, "sh"}}
+	p, rc, err := run.start([]string{"--foreground", "b", "/mnt/b"}, nil)
+	if err != nil {
+		t.Skip(err)
+	}
+	buf := make([]byte, 64)
+	n, _ := io.ReadFull(rc, buf)
+	if got, want := string(buf[:n]), "gcsfuse --foreground b /mnt/b\n"; got != want {
+		t.Errorf("output is %q, want %q", got, want)
+	}
+	ex, err := p.wait()
+	if err != nil || !ex.success() {
+		t.Errorf("exited with %v, %v", ex, err)
+	}
+	if p.alive() {
+		t.Error("alive after exiting")
+	}
+}
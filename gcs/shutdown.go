@@ -0,0 +1,80 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"time"
+)
+
+// How long Shutdown waits for the last output of gcsfuse to be passed on.
+const flushTimeout = 5 * time.Second
+
+// Shutdown refuses any further mounts, waits for those that are under way,
+// then stops all instances of gcsfuse and unmounts their buckets. Failed
+// mounts that are kept for inspection, see Config.KeepFailedMounts, are
+// left alone. Finally, it waits for the output of gcsfuse, spans and
+// events to be passed on.
+// The driver is of no use afterwards.
+func (d Driver) Shutdown() {
+	d.Lock()
+	defer d.Unlock()
+
+	*d.stopping = true
+	infof("Shutting down, refusing to mount buckets, unmounting %d.", len(d.cmds))
+
+	// The lock is released while waiting for mounts, so look for
+	// remaining ones again after each.
+	done := make(map[string]bool)
+	for {
+		k := ""
+		for b := range d.cmds {
+			if !done[b] {
+				k = b
+				break
+			}
+		}
+		if k == "" {
+			break
+		}
+		done[k] = true
+
+		m := d.cmds[k]
+		d.await(m)
+		if d.cmds[k] != m || m.err != nil {
+			continue
+		}
+		if err := d.stop(k); err != nil {
+			errorf("Stopping gcsfuse %s failed: %s", k, err)
+		}
+	}
+
+	// Copiers only finish once gcsfuse exited, which those that are kept
+	// for inspection do not.
+	copied := make(chan struct{})
+	go func() {
+		d.copiers.Wait()
+		close(copied)
+	}()
+	select {
+	case <-copied:
+	case <-time.After(flushTimeout):
+		warnf("Output of gcsfuse did not end within %s, some of it might be lost.", flushTimeout)
+	}
+	stderr.flush(flushTimeout)
+	d.tracer.shutdown(flushTimeout)
+	d.events.shutdown(flushTimeout)
+}
@@ -0,0 +1,114 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestShutdown(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		relaunch bool
+		inUse    bool
+	}{
+		{name: "idle"},
+		{name: "in use", inUse: true},
+		// gcsfuse exiting must not start it again.
+		{name: "in use with relaunch", inUse: true, relaunch: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			run := &fakeRunner{output: successLine}
+			d := newTestDriver(t, Config{Relaunch: tc.relaunch}, run)
+			d.cfg.IdleTimeout = time.Hour
+			mustCreate(t, d, "b", nil)
+			if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"}); err != nil {
+				t.Fatal(err)
+			}
+			if !tc.inUse {
+				if err := d.Unmount(&volume.UnmountRequest{Name: "b", ID: "1"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			d.Lock()
+			proc := d.cmds["b"].proc
+			d.Unlock()
+
+			d.Shutdown()
+
+			// The output of gcsfuse was passed on.
+			copied := make(chan struct{})
+			go func() {
+				d.copiers.Wait()
+				close(copied)
+			}()
+			select {
+			case <-copied:
+			case <-time.After(time.Second):
+				t.Error("output of gcsfuse is still being copied")
+			}
+			if n := len(d.cmds); n != 0 {
+				t.Errorf("%d instances of gcsfuse are left", n)
+			}
+			if proc.alive() {
+				t.Error("gcsfuse is still running")
+			}
+			if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "2"}); err != errShutdown {
+				t.Errorf("mounting after shutdown: got %v, want %v", err, errShutdown)
+			}
+			if n := run.starts(); n != 1 {
+				t.Errorf("gcsfuse was started %d times, want once", n)
+			}
+		})
+	}
+}
+
+func TestShutdownAwaitsMounts(t *testing.T) {
+	run := &fakeRunner{output: successLine, gate: make(chan struct{})}
+	d := newTestDriver(t, Config{}, run)
+	mustCreate(t, d, "b", nil)
+
+	mounted := make(chan error)
+	go func() {
+		_, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"})
+		mounted <- err
+	}()
+	if !eventually(t, d, func() bool { return run.starts() == 1 }) {
+		t.Fatal("gcsfuse was not started")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		d.Shutdown()
+		close(stopped)
+	}()
+	if !eventually(t, d, func() bool { return *d.stopping }) {
+		t.Fatal("shutdown did not start")
+	}
+	close(run.gate)
+
+	if err := <-mounted; err != errShutdown {
+		t.Errorf("mount under way: got %v, want %v", err, errShutdown)
+	}
+	<-stopped
+	if n := len(d.cmds); n != 0 {
+		t.Errorf("%d instances of gcsfuse are left", n)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Output of gcsfuse is passed on to the standard error of the driver
+// through this, so that a slow or stuck consumer cannot block gcsfuse.
+var stderr = newDropWriter(os.Stderr, 1024)
+
+var droppedWrites = newCounter("gcs_stderr_dropped_writes_total", "Number of writes of gcsfuse output that were dropped because stderr was not keeping up.")
+
+// dropWriter writes to an underlying writer from a separate goroutine. Up
+// to a fixed number of writes are buffered, further writes are dropped
+// until there is room again.
+type dropWriter struct {
+	c chan []byte
+
+	// Number of writes that were not passed on yet, see flush.
+	pending *int64
+}
+
+func newDropWriter(w io.Writer, n int) dropWriter {
+	d := dropWriter{c: make(chan []byte, n), pending: new(int64)}
+	go func() {
+		for b := range d.c {
+			w.Write(b)
+			atomic.AddInt64(d.pending, -1)
+		}
+	}()
+	return d
+}
+
+// flush waits for buffered writes to be passed on, for at most timeout.
+func (w dropWriter) flush(timeout time.Duration) {
+	for deadline := time.Now().Add(timeout); atomic.LoadInt64(w.pending) > 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Write never blocks and never fails.
+func (w dropWriter) Write(p []byte) (int, error) {
+	b := make([]byte, len(p))
+	copy(b, p)
+
+	atomic.AddInt64(w.pending, 1)
+	select {
+	case w.c <- b:
+	default:
+		atomic.AddInt64(w.pending, -1)
+		droppedWrites.add(1)
+	}
+	return len(p), nil
+}
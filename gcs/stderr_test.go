@@ -0,0 +1,101 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stuckWriter blocks writes until release is closed, and tells about the
+// first one through entered.
+type stuckWriter struct {
+	entered chan struct{}
+	release chan struct{}
+	once    sync.Once
+
+	mu  sync.Mutex
+	out bytes.Buffer
+}
+
+func (w *stuckWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.entered) })
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.out.Write(p)
+}
+
+func TestDropWriter(t *testing.T) {
+	sw := &stuckWriter{entered: make(chan struct{}), release: make(chan struct{})}
+	w := newDropWriter(sw, 2)
+	dropped := droppedWrites.get()
+
+	b := []byte("0")
+	w.Write(b)
+	<-sw.entered
+	for i, want := range []string{"1", "2", "3", "4"} {
+		start := time.Now()
+		b[0] = want[0]
+		if n, err := w.Write(b); n != 1 || err != nil {
+			t.Errorf("write %d: got %d, %v", i, n, err)
+		}
+		if took := time.Since(start); took > time.Second {
+			t.Errorf("write %d blocked for %s", i, took)
+		}
+	}
+	// Writes are copied, reusing the buffer does not change them.
+	b[0] = 'x'
+
+	close(sw.release)
+	w.flush(time.Second)
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if got := sw.out.String(); got != "012" {
+		t.Errorf("passed on %q, want 012", got)
+	}
+	if n := droppedWrites.get() - dropped; n != 2 {
+		t.Errorf("counted %g dropped writes, want 2", n)
+	}
+}
+
+func TestDropWriterFlush(t *testing.T) {
+	sw := &stuckWriter{entered: make(chan struct{}), release: make(chan struct{})}
+	w := newDropWriter(sw, 2)
+	w.Write([]byte("a"))
+	<-sw.entered
+
+	start := time.Now()
+	w.flush(50 * time.Millisecond)
+	if took := time.Since(start); took < 50*time.Millisecond || took > time.Second {
+		t.Errorf("flushing a stuck writer took %s, want the timeout", took)
+	}
+
+	close(sw.release)
+	start = time.Now()
+	w.flush(time.Second)
+	if took := time.Since(start); took > 500*time.Millisecond {
+		t.Errorf("flushing took %s", took)
+	}
+	if n := atomic.LoadInt64(w.pending); n != 0 {
+		t.Errorf("%d writes are pending", n)
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scope of access tokens, the same that gcsfuse asks for.
+const storageScope = "https://www.googleapis.com/auth/devstorage.full_control"
+
+// Endpoints of the JSON API of Cloud Storage, of the Compute Engine
+// metadata server, and for exchanging credentials for access tokens.
+// Variables, so that tests can point them elsewhere.
+var (
+	storageEndpoint  = "https://storage.googleapis.com/storage/v1"
+	metadataEndpoint = "http://metadata.google.internal/computeMetadata/v1"
+	tokenEndpoint    = "https://oauth2.googleapis.com/token"
+)
+
+var storageClient = &http.Client{Timeout: 10 * time.Second}
+
+var (
+	errNoPrivateKey = errors.New("key file holds no PEM encoded private key")
+	errNotRSA       = errors.New("private key of the key file is not an RSA key")
+)
+
+type errStorageStatus struct {
+	url    string
+	status string
+	msg    string
+}
+
+func (e errStorageStatus) Error() string {
+	if e.msg == "" {
+		return fmt.Sprintf("%s responded with %s", e.url, e.status)
+	}
+	return fmt.Sprintf("%s responded with %s: %s", e.url, e.status, e.msg)
+}
+
+type errCredentialType struct {
+	path string
+	typ  string
+}
+
+func (e errCredentialType) Error() string {
+	return fmt.Sprintf("key file %s holds credentials of type %q, want \"service_account\" or \"authorized_user\"", e.path, e.typ)
+}
+
+// Access tokens by key file, see token.
+var tokens = struct {
+	sync.Mutex
+	m map[string]accessToken
+}{m: make(map[string]accessToken)}
+
+type accessToken struct {
+	value   string
+	expires time.Time
+}
+
+// token returns an access token for the credentials in the key file at
+// path. Without one, it looks for them like gcsfuse does, i.e. in the
+// well-known file of gcloud and last with the metadata server. Tokens are
+// reused until shortly before they expire.
+func token(path string) (string, error) {
+	if path == "" {
+		if p := wellKnownFile(); p != "" {
+			path = p
+		}
+	}
+
+	tokens.Lock()
+	t, ok := tokens.m[path]
+	tokens.Unlock()
+	if ok && time.Until(t.expires) > time.Minute {
+		return t.value, nil
+	}
+
+	var err error
+	if path == "" {
+		t, err = metadataToken()
+	} else {
+		t, err = keyFileToken(path)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	tokens.Lock()
+	tokens.m[path] = t
+	tokens.Unlock()
+	return t.value, nil
+}
+
+// wellKnownFile returns the path of the application default credentials
+// of gcloud, if they exist.
+func wellKnownFile() string {
+	dir := os.Getenv("CLOUDSDK_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config", "gcloud")
+	}
+	path := filepath.Join(dir, "application_default_credentials.json")
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+type keyJSON struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func keyFileToken(path string) (accessToken, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return accessToken{}, err
+	}
+	var k keyJSON
+	if err := json.Unmarshal(b, &k); err != nil {
+		return accessToken{}, fmt.Errorf("key file %s: %s", path, err)
+	}
+
+	switch k.Type {
+	case "service_account":
+		assertion, err := k.assertion(time.Now())
+		if err != nil {
+			return accessToken{}, fmt.Errorf("key file %s: %s", path, err)
+		}
+		uri := k.TokenURI
+		if uri == "" {
+			uri = tokenEndpoint
+		}
+		return exchange(uri, url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		})
+	case "authorized_user":
+		return exchange(tokenEndpoint, url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {k.ClientID},
+			"client_secret": {k.ClientSecret},
+			"refresh_token": {k.RefreshToken},
+		})
+	}
+	return accessToken{}, errCredentialType{path: path, typ: k.Type}
+}
+
+// assertion returns a JWT signed with the private key of a service
+// account, which the token endpoint exchanges for an access token.
+func (k keyJSON) assertion(now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(k.PrivateKey))
+	if block == nil {
+		return "", errNoPrivateKey
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return "", err
+		}
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", errNotRSA
+	}
+
+	aud := k.TokenURI
+	if aud == "" {
+		aud = tokenEndpoint
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   k.ClientEmail,
+		"scope": storageScope,
+		"aud":   aud,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (r tokenResponse) token() accessToken {
+	return accessToken{value: r.AccessToken, expires: time.Now().Add(time.Duration(r.ExpiresIn) * time.Second)}
+}
+
+func exchange(uri string, form url.Values) (accessToken, error) {
+	req, err := http.NewRequest("POST", uri, strings.NewReader(form.Encode()))
+	if err != nil {
+		return accessToken{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var r tokenResponse
+	if err := do(req, &r); err != nil {
+		return accessToken{}, err
+	}
+	return r.token(), nil
+}
+
+func metadataToken() (accessToken, error) {
+	req, err := http.NewRequest("GET", metadataEndpoint+"/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return accessToken{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	var r tokenResponse
+	if err := do(req, &r); err != nil {
+		return accessToken{}, err
+	}
+	return r.token(), nil
+}
+
+// storageGet fetches the resource at path below storageEndpoint, with the
+// credentials of the key file at key, see token, and decodes it into v.
+func storageGet(key, path string, query url.Values, v interface{}) error {
+	t, err := token(key)
+	if err != nil {
+		return err
+	}
+	u := storageEndpoint + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+t)
+	return do(req, v)
+}
+
+// do sends req and decodes the JSON it responds with into v. Errors of
+// the JSON API and of the token endpoint include their message.
+func do(req *http.Request, v interface{}) error {
+	res, err := storageClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return errStorageStatus{url: req.URL.Scheme + "://" + req.URL.Host + req.URL.Path, status: res.Status, msg: errorMessage(body)}
+	}
+	return json.Unmarshal(body, v)
+}
+
+// errorMessage extracts the message of an error of the JSON API, like
+// {"error": {"code": 403, "message": "..."}}, or of the token endpoint,
+// like {"error": "invalid_grant", "error_description": "..."}.
+func errorMessage(body []byte) string {
+	var e struct {
+		Error            json.RawMessage `json:"error"`
+		ErrorDescription string          `json:"error_description"`
+	}
+	if json.Unmarshal(body, &e) != nil {
+		return ""
+	}
+	var api struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(e.Error, &api) == nil && api.Message != "" {
+		return api.Message
+	}
+	var code string
+	if json.Unmarshal(e.Error, &code) == nil && e.ErrorDescription != "" {
+		return code + ": " + e.ErrorDescription
+	}
+	return code
+}
+
+// writable tells whether the credentials of the key file at key may
+// create objects in bucket b, by asking the bucket which of the
+// permissions it was asked about the caller has.
+func writable(b, key string) (bool, error) {
+	var r struct {
+		Permissions []string `json:"permissions"`
+	}
+	q := url.Values{"permissions": {"storage.objects.create"}}
+	if err := storageGet(key, "/b/"+url.PathEscape(b)+"/iam/testPermissions", q, &r); err != nil {
+		return false, err
+	}
+	for _, p := range r.Permissions {
+		if p == "storage.objects.create" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,298 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var (
+	testKeyOnce sync.Once
+	testKey     *rsa.PrivateKey
+)
+
+func privateKey(t *testing.T) *rsa.PrivateKey {
+	testKeyOnce.Do(func() {
+		var err error
+		if testKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return testKey
+}
+
+// fakeGoogle serves the endpoints that storage.go talks to. The access
+// token it hands out is "t", which has perms on the buckets, and the
+// refresh token it accepts is "r".
+type fakeGoogle struct {
+	url       string
+	key       *rsa.PublicKey
+	perms     map[string][]string
+	locations map[string]string
+}
+
+func serveGoogle(t *testing.T, g *fakeGoogle) *fakeGoogle {
+	t.Helper()
+	srv := httptest.NewServer(g)
+	t.Cleanup(srv.Close)
+	g.url = srv.URL
+
+	endpoints := []*string{&storageEndpoint, &metadataEndpoint, &tokenEndpoint}
+	saved := []string{storageEndpoint, metadataEndpoint, tokenEndpoint}
+	storageEndpoint = srv.URL + "/storage/v1"
+	metadataEndpoint = srv.URL + "/computeMetadata/v1"
+	tokenEndpoint = srv.URL + "/token"
+	t.Cleanup(func() {
+		for i, e := range endpoints {
+			*e = saved[i]
+		}
+	})
+
+	tokens.Lock()
+	tokens.m = make(map[string]accessToken)
+	tokens.Unlock()
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	t.Setenv("CLOUDSDK_CONFIG", t.TempDir())
+	return g
+}
+
+func (g *fakeGoogle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fail := func(status int, msg string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": status, "message": msg}})
+	}
+	grant := func() {
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "t", "expires_in": 3600})
+	}
+
+	switch p := r.URL.Path; {
+	case p == "/token":
+		r.ParseForm()
+		switch r.Form.Get("grant_type") {
+		case "urn:ietf:params:oauth:grant-type:jwt-bearer":
+			if !g.verify(r.Form.Get("assertion")) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error": "invalid_grant", "error_description": "Invalid JWT Signature."}`))
+				return
+			}
+			grant()
+		case "refresh_token":
+			if r.Form.Get("refresh_token") != "r" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error": "invalid_grant", "error_description": "Bad Request"}`))
+				return
+			}
+			grant()
+		default:
+			fail(http.StatusBadRequest, "unsupported grant type")
+		}
+	case p == "/computeMetadata/v1/instance/service-accounts/default/token":
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			fail(http.StatusForbidden, "missing Metadata-Flavor")
+			return
+		}
+		grant()
+	case strings.HasPrefix(p, "/storage/v1/b/"):
+		if r.Header.Get("Authorization") != "Bearer t" {
+			fail(http.StatusUnauthorized, "Invalid Credentials")
+			return
+		}
+		parts := strings.Split(strings.TrimPrefix(p, "/storage/v1/b/"), "/")
+		perms, ok := g.perms[parts[0]]
+		if !ok {
+			fail(http.StatusNotFound, "The specified bucket does not exist.")
+			return
+		}
+		if len(parts) == 1 {
+			json.NewEncoder(w).Encode(map[string]string{"location": g.locations[parts[0]]})
+			return
+		}
+		var granted []string
+		for _, asked := range r.URL.Query()["permissions"] {
+			for _, p := range perms {
+				if p == asked {
+					granted = append(granted, p)
+				}
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"kind": "storage#testIamPermissionsResponse", "permissions": granted})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// verify checks the signature and the claims of a JWT from assertion.
+func (g *fakeGoogle) verify(jwt string) bool {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 || g.key == nil {
+		return false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if rsa.VerifyPKCS1v15(g.key, crypto.SHA256, sum[:], sig) != nil {
+		return false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	var claims map[string]interface{}
+	if json.Unmarshal(b, &claims) != nil {
+		return false
+	}
+	return claims["aud"] == g.url+"/token" && claims["scope"] == storageScope && claims["iss"] == "sa@p.iam.gserviceaccount.com"
+}
+
+// writeKey writes a key file of the given type and returns its path.
+func writeKey(t *testing.T, typ string, key *rsa.PrivateKey, tokenURI string) string {
+	t.Helper()
+	k := map[string]string{"type": typ}
+	switch typ {
+	case "service_account":
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k["client_email"] = "sa@p.iam.gserviceaccount.com"
+		k["private_key"] = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+		k["token_uri"] = tokenURI
+	case "authorized_user":
+		k["client_id"], k["client_secret"], k["refresh_token"] = "id", "secret", "r"
+	}
+	b, _ := json.Marshal(k)
+	path := filepath.Join(t.TempDir(), "key.json")
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestToken(t *testing.T) {
+	key := privateKey(t)
+	other, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := serveGoogle(t, &fakeGoogle{key: &key.PublicKey})
+
+	for _, tc := range []struct {
+		name string
+		path func() string
+		ok   bool
+	}{
+		{"metadata server", func() string { return "" }, true},
+		{"service account", func() string { return writeKey(t, "service_account", key, g.url+"/token") }, true},
+		{"service account signed by another key", func() string { return writeKey(t, "service_account", other, g.url+"/token") }, false},
+		{"authorized user", func() string { return writeKey(t, "authorized_user", nil, "") }, true},
+		{"external account", func() string { return writeKey(t, "external_account", nil, "") }, false},
+		{"missing", func() string { return filepath.Join(t.TempDir(), "missing.json") }, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tok, err := token(tc.path())
+			if tc.ok && (err != nil || tok != "t") {
+				t.Errorf("got token %q and error %v, want t", tok, err)
+			}
+			if !tc.ok && err == nil {
+				t.Errorf("got token %q, want an error", tok)
+			}
+		})
+	}
+}
+
+func TestTokenWellKnownFile(t *testing.T) {
+	serveGoogle(t, &fakeGoogle{})
+	dir := t.TempDir()
+	t.Setenv("CLOUDSDK_CONFIG", dir)
+	path := writeKey(t, "authorized_user", nil, "")
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "application_default_credentials.json"), b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := token(""); err != nil {
+		t.Fatal(err)
+	}
+	tokens.Lock()
+	defer tokens.Unlock()
+	if _, ok := tokens.m[filepath.Join(dir, "application_default_credentials.json")]; !ok {
+		t.Errorf("token is not cached for the well-known file, got %v", tokens.m)
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	for _, tc := range []struct {
+		body string
+		want string
+	}{
+		{`{"error": {"code": 403, "message": "sa does not have storage.buckets.get access"}}`, "sa does not have storage.buckets.get access"},
+		{`{"error": "invalid_grant", "error_description": "Bad Request"}`, "invalid_grant: Bad Request"},
+		{`{"error": "invalid_grant"}`, "invalid_grant"},
+		{`<html>Not Found</html>`, ""},
+		{``, ""},
+	} {
+		if got := errorMessage([]byte(tc.body)); got != tc.want {
+			t.Errorf("errorMessage(%q) = %q, want %q", tc.body, got, tc.want)
+		}
+	}
+}
+
+func TestWritable(t *testing.T) {
+	serveGoogle(t, &fakeGoogle{perms: map[string][]string{
+		"rw": {"storage.objects.get", "storage.objects.create"},
+		"ro": {"storage.objects.get"},
+	}})
+
+	for _, tc := range []struct {
+		bucket string
+		want   bool
+		err    error
+	}{
+		{"rw", true, nil},
+		{"ro", false, nil},
+		{"missing", false, errStorageStatus{status: "404 Not Found", msg: "The specified bucket does not exist."}},
+	} {
+		got, err := writable(tc.bucket, "")
+		if e, ok := err.(errStorageStatus); ok {
+			e.url = ""
+			err = e
+		}
+		if got != tc.want || !reflect.DeepEqual(err, tc.err) {
+			t.Errorf("writable(%s) = %t, %v, want %t, %v", tc.bucket, got, err, tc.want, tc.err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"errors"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Directory below root that holds the mountpoints of volumes with
+// only_dir, one directory per bucket. They can not live below the
+// mountpoint of the bucket, which might be mounted at the same time.
+const subpathDir = ".subpaths"
+
+var (
+	errNoSubpath      = errors.New("subpath does not exist in the bucket; create it first or mount the volume without only_dir")
+	errOnlyDirRemount = errors.New("refusing to change only_dir by remounting; remove the volume and create it again")
+	errOnlyDirConf    = errors.New("only_dir can only be set per volume")
+)
+
+// subpath returns the part of a volume name after the bucket, if any.
+func subpath(name string) string {
+	name = strings.TrimPrefix(name, scheme)
+	i := strings.Index(name, "/")
+	if i == -1 {
+		return ""
+	}
+	return strings.Trim(name[i+1:], "/")
+}
+
+// onlyDir reports whether a volume with options opts mounts its subpath
+// on its own, also see mountOptions.
+func onlyDir(opts map[string]string) bool {
+	v, ok := opts["only_dir"]
+	if !ok {
+		return false
+	}
+	on, err := parseBool(v)
+	return err == nil && on
+}
+
+// key identifies the instance of gcsfuse that serves volume name with
+// options opts, i.e. either the bucket or, with only_dir, "<bucket>/<sub>".
+// Config.PrefixMap is applied to name first, and Config.ShareSubpaths
+// overrides only_dir.
+func (d Driver) key(name string, opts map[string]string) string {
+	name = d.resolve(name)
+	b, sub := d.bucket(name), subpath(name)
+	if sub == "" || !onlyDir(opts) || d.cfg.ShareSubpaths {
+		return b
+	}
+	return b + "/" + sub
+}
+
+// target returns where the instance identified by k is mounted. Subpaths
+// are escaped, so that such mountpoints never nest.
+func (d Driver) target(k string) string {
+	b, sub := d.bucket(k), subpath(k)
+	if sub == "" {
+		return filepath.Join(d.cfg.Root, b)
+	}
+	return filepath.Join(d.cfg.Root, subpathDir, b, url.PathEscape(sub))
+}
+
+// subpathExists asks gcloud whether there are objects below the subpath
+// of k. It holds if k is a whole bucket or the check is disabled.
+func (d Driver) subpathExists(k string) error {
+	sub := subpath(k)
+	if sub == "" || !d.cfg.CheckOnlyDir {
+		return nil
+	}
+	if err := exec.Command("gcloud", "storage", "ls", scheme+d.bucket(k)+"/"+sub+"/").Run(); err != nil {
+		return errNoSubpath
+	}
+	return nil
+}
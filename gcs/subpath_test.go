@@ -0,0 +1,184 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestSubpath(t *testing.T) {
+	for name, want := range map[string]string{
+		"b":              "",
+		"b/":             "",
+		"b/sub":          "sub",
+		"b/sub/":         "sub",
+		"b/a/b":          "a/b",
+		"gs://b/sub":     "sub",
+		"gs://b":         "",
+		"b//sub":         "sub",
+		"b/sub dir/with": "sub dir/with",
+	} {
+		if got := subpath(name); got != want {
+			t.Errorf("subpath(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestKeyAndTarget(t *testing.T) {
+	only := map[string]string{"only_dir": "true"}
+	for _, tc := range []struct {
+		name   string
+		opts   map[string]string
+		share  bool
+		key    string
+		target string
+	}{
+		{"b", nil, false, "b", "b"},
+		{"b", only, false, "b", "b"},
+		{"b/sub", nil, false, "b", "b"},
+		{"b/sub", map[string]string{"only_dir": "false"}, false, "b", "b"},
+		{"b/sub", only, false, "b/sub", ".subpaths/b/sub"},
+		{"b/a/b", only, false, "b/a/b", ".subpaths/b/a%2Fb"},
+		{"b/sub", only, true, "b", "b"},
+	} {
+		d := Driver{cfg: &Config{Root: "/mnt", ShareSubpaths: tc.share}}
+		k := d.key(tc.name, tc.opts)
+		if k != tc.key {
+			t.Errorf("key(%s, %v) with share=%t = %s, want %s", tc.name, tc.opts, tc.share, k, tc.key)
+		}
+		if got, want := d.target(k), filepath.Join("/mnt", tc.target); got != want {
+			t.Errorf("target(%s) = %s, want %s", k, got, want)
+		}
+	}
+}
+
+// A subpath with only_dir gets its own gcsfuse, e.g. to mount it
+// read-only while the bucket is mounted read-write.
+func TestMountOnlyDir(t *testing.T) {
+	run := &fakeRunner{output: successLine}
+	d := newTestDriver(t, Config{}, run)
+	mustCreate(t, d, "b", nil)
+	mustCreate(t, d, "b/sub", map[string]string{"only_dir": "true", "access": "ro"})
+
+	for i, name := range []string{"b", "b/sub"} {
+		if _, err := d.Mount(&volume.MountRequest{Name: name, ID: "1"}); err != nil {
+			t.Fatalf("mounting %s: %s", name, err)
+		}
+		if n := run.starts(); n != i+1 {
+			t.Errorf("after mounting %s, started gcsfuse %d times, want %d", name, n, i+1)
+		}
+	}
+	if m := d.cmds["b/sub"]; m.access != "ro" || !containsArg(m.cmd.args, "--only-dir=sub") {
+		t.Errorf("mounted subpath %s with %q", m.access, m.cmd.args)
+	}
+	if m := d.cmds["b"]; m.access != "rw" {
+		t.Errorf("mounted bucket %s", m.access)
+	}
+}
+
+func containsArg(args []string, arg string) bool {
+	for _, a := range args {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGetSubpath(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		opts   map[string]string
+		status map[string]interface{}
+	}{
+		{"b", nil, map[string]interface{}{}},
+		{"b/sub", nil, map[string]interface{}{"bucket": "b", "subpath": "sub", "only_dir": false}},
+		{"b/a/b", map[string]string{"only_dir": "true"}, map[string]interface{}{"bucket": "b", "subpath": "a/b", "only_dir": true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newTestDriver(t, Config{}, &fakeRunner{})
+			mustCreate(t, d, tc.name, tc.opts)
+			res, err := d.Get(&volume.GetRequest{Name: tc.name})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, k := range []string{"bucket", "subpath", "only_dir"} {
+				got, ok := res.Volume.Status[k]
+				if want, wanted := tc.status[k]; ok != wanted || got != want {
+					t.Errorf("%s is %v, want %v", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+// With Config.ShareSubpaths, subpaths of a bucket are served by one
+// gcsfuse, even with only_dir, which is stopped by the last unmount.
+func TestMountShareSubpaths(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		share  bool
+		starts int
+	}{
+		{"separate", false, 3},
+		{"shared", true, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			run := &fakeRunner{output: successLine}
+			d := newTestDriver(t, Config{ShareSubpaths: tc.share}, run)
+			only := map[string]string{"only_dir": "true"}
+			names := []string{"b/x", "b/y", "b/x/z"}
+			for _, name := range names {
+				mustCreate(t, d, name, only)
+			}
+			for _, name := range names {
+				res, err := d.Mount(&volume.MountRequest{Name: name, ID: name})
+				if err != nil {
+					t.Fatalf("mounting %s: %s", name, err)
+				}
+				want := filepath.Join(d.cfg.Root, name)
+				if !tc.share {
+					want = d.target(name)
+				}
+				if res.Mountpoint != want {
+					t.Errorf("mounted %s at %s, want %s", name, res.Mountpoint, want)
+				}
+			}
+			if n := run.starts(); n != tc.starts {
+				t.Errorf("started gcsfuse %d times, want %d", n, tc.starts)
+			}
+			if tc.share && containsArg(run.started[0], "--only-dir=x") {
+				t.Errorf("shared mount with %q", run.started[0])
+			}
+
+			for i, name := range names {
+				if err := d.Unmount(&volume.UnmountRequest{Name: name, ID: name}); err != nil {
+					t.Fatalf("unmounting %s: %s", name, err)
+				}
+				if _, ok := d.cmds["b"]; tc.share && ok != (i < len(names)-1) {
+					t.Errorf("after unmounting %s, bucket mounted: %t", name, ok)
+				}
+			}
+			if len(d.cmds) != 0 {
+				t.Errorf("still mounted: %v", d.cmds)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"fmt"
+	"syscall"
+)
+
+var unexpectedExits = newCounter("gcs_unexpected_exits_total", "Number of times gcsfuse exited without being stopped.")
+
+type errUnexpectedExit struct {
+	reason string
+}
+
+func (e errUnexpectedExit) Error() string {
+	return fmt.Sprintf("gcsfuse exited unexpectedly (%s)", e.reason)
+}
+
+// supervise waits for proc, which serves the instance identified by k, and
+// cleans up if it exits without being stopped. The stale mountpoint is
+// unmounted. If containers still use it, gcsfuse is launched again with
+// Config.Relaunch, otherwise the mount is marked failed.
+func (d Driver) supervise(k string, m *mount, proc process) {
+	ex, err := proc.wait()
+
+	d.Lock()
+	defer d.Unlock()
+
+	// The cgroup is left alone as long as gcsfuse that replaced proc is
+	// in it, see limitMemory.
+	var events map[string]uint64
+	if m.cmd.memory > 0 {
+		cg := d.cgroup(m.cmd)
+		events, _ = memoryEvents(cg)
+		removeCgroup(cg)
+	}
+
+	// Stopped or replaced on purpose.
+	if d.cmds[k] != m || m.proc != proc {
+		return
+	}
+
+	reason := exitReason(ex, err)
+	if reason == "oom" && events["oom_kill"] > 0 {
+		reason = "memory_limit"
+	}
+	errorf("gcsfuse %s exited unexpectedly (%s).", k, reason)
+	unexpectedExits.add(1, "bucket", k, "reason", reason)
+	d.events.emit("exit", "", k, 0, errUnexpectedExit{reason: reason})
+	m.failed = reason
+
+	if len(m.refs) == 0 {
+		if err := d.stop(k); err != nil {
+			errorf("Cleaning up after gcsfuse %s failed: %s", k, err)
+		}
+		return
+	}
+
+	if err := d.release(d.target(k)); err != nil {
+		errorf("Unmounting stale %s failed: %s", k, err)
+	}
+	if !d.cfg.Relaunch || *d.stopping {
+		return
+	}
+
+	infof("Relaunching gcsfuse %s", k)
+	c := m.cmd
+	m.proc, m.ready = nil, make(chan struct{})
+	out := d.logBuffer(k)
+
+	d.Unlock()
+	d.slots <- struct{}{}
+	next, err := d.start(c, out, nil)
+	<-d.slots
+	d.Lock()
+
+	if err != nil {
+		errorf("Relaunching gcsfuse %s failed: %s", k, err)
+		mountErrors.add(1, "bucket", k, "reason", failure(err))
+		d.discard(k, d.target(k), next)
+		m.proc = proc
+	} else {
+		m.proc, m.failed = next, ""
+		go d.supervise(k, m, next)
+	}
+	close(m.ready)
+}
+
+// exitReason tells why a process exited, for logs and metrics.
+func exitReason(ex exit, err error) string {
+	switch {
+	case err != nil:
+		return "unknown"
+	case ex.signal == syscall.SIGKILL:
+		// Most likely the OOM killer, which sends SIGKILL.
+		return "oom"
+	case ex.signal != nil:
+		return "signal"
+	}
+	return "exit"
+}
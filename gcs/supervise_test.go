@@ -0,0 +1,116 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestExitReason(t *testing.T) {
+	for _, tc := range []struct {
+		ex   exit
+		err  error
+		want string
+	}{
+		{exit{code: 0}, nil, "exit"},
+		{exit{code: 1}, nil, "exit"},
+		{exit{code: -1, signal: syscall.SIGKILL}, nil, "oom"},
+		{exit{code: -1, signal: syscall.SIGSEGV}, nil, "signal"},
+		{exit{}, errors.New("no child"), "unknown"},
+	} {
+		if got := exitReason(tc.ex, tc.err); got != tc.want {
+			t.Errorf("exitReason(%v, %v) = %s, want %s", tc.ex, tc.err, got, tc.want)
+		}
+	}
+}
+
+// eventually polls cond for up to a second.
+func eventually(t *testing.T, d *Driver, cond func() bool) bool {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		d.Lock()
+		ok := cond()
+		d.Unlock()
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSupervise(t *testing.T) {
+	killed := exit{code: -1, signal: syscall.SIGKILL}
+	for _, tc := range []struct {
+		name     string
+		inUse    bool
+		relaunch bool
+		// What is left of the mount once supervise is done.
+		gone   bool
+		failed string
+		starts int
+		again  error
+	}{
+		{name: "idle", gone: true, starts: 1},
+		{name: "in use", inUse: true, failed: "oom", starts: 1, again: errZombie},
+		{name: "relaunched", inUse: true, relaunch: true, starts: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			run := &fakeRunner{output: successLine}
+			d := newTestDriver(t, Config{Relaunch: tc.relaunch}, run)
+			d.cfg.IdleTimeout = time.Hour
+			mustCreate(t, d, "b", nil)
+			if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"}); err != nil {
+				t.Fatal(err)
+			}
+			if !tc.inUse {
+				if err := d.Unmount(&volume.UnmountRequest{Name: "b", ID: "1"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			d.Lock()
+			proc := d.cmds["b"].proc.(*fakeProcess)
+			d.Unlock()
+			run.ex = killed
+			proc.exit()
+
+			done := eventually(t, d, func() bool {
+				m, ok := d.cmds["b"]
+				if tc.gone {
+					return !ok
+				}
+				return ok && m.failed == tc.failed && run.starts() == tc.starts && m.proc != nil && (tc.failed != "" || m.proc != process(proc))
+			})
+			if !done {
+				t.Fatal("gcsfuse exiting was not handled")
+			}
+			if tc.gone {
+				return
+			}
+
+			run.ex = exit{}
+			if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "2"}); err != tc.again {
+				t.Errorf("mounting again: got %v, want %v", err, tc.again)
+			}
+		})
+	}
+}
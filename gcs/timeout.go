@@ -0,0 +1,62 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+var queryTimeouts = newCounter("gcs_query_timeouts_total", "Number of requests that did not finish within the query timeout, by operation.")
+
+type errQueryTimeout struct {
+	op      string
+	timeout time.Duration
+}
+
+func (e errQueryTimeout) Error() string {
+	return fmt.Sprintf("%s did not finish within %s; the plugin might be stuck, check its logs", e.op, e.timeout)
+}
+
+// bounded runs f for the operation op, giving up after Config.QueryTimeout.
+// The context that f gets expires then, and is to be checked before each
+// probe. If f is given up on, it keeps running in the background, and
+// whatever it returns is dropped. Callers must only use results of f if
+// bounded returns no error.
+func (d Driver) bounded(op string, f func(ctx context.Context) error) error {
+	if d.cfg.QueryTimeout <= 0 {
+		return f(context.Background())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.cfg.QueryTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- f(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		queryTimeouts.add(1, "op", op)
+		warnf("%s did not finish within %s, giving up.", op, d.cfg.QueryTimeout)
+		return errQueryTimeout{op: op, timeout: d.cfg.QueryTimeout}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestBounded(t *testing.T) {
+	errBoom := errors.New("boom")
+	for _, tc := range []struct {
+		name    string
+		timeout time.Duration
+		f       func(ctx context.Context) error
+		err     error
+	}{
+		{"no limit", 0, func(ctx context.Context) error {
+			if _, ok := ctx.Deadline(); ok {
+				return errors.New("has a deadline")
+			}
+			return nil
+		}, nil},
+		{"in time", time.Second, func(ctx context.Context) error { return nil }, nil},
+		{"failed in time", time.Second, func(ctx context.Context) error { return errBoom }, errBoom},
+		{"too late", 20 * time.Millisecond, func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}, errQueryTimeout{op: "Get", timeout: 20 * time.Millisecond}},
+		// The result is dropped, even after f gave up.
+		{"stuck", 20 * time.Millisecond, func(ctx context.Context) error {
+			time.Sleep(100 * time.Millisecond)
+			return nil
+		}, errQueryTimeout{op: "Get", timeout: 20 * time.Millisecond}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := Driver{cfg: &Config{QueryTimeout: tc.timeout}}
+			before := queryTimeouts.get("op", "Get")
+			if err := d.bounded("Get", tc.f); err != tc.err {
+				t.Errorf("got %v, want %v", err, tc.err)
+			}
+			want := 0.0
+			if _, ok := tc.err.(errQueryTimeout); ok {
+				want = 1
+			}
+			if n := queryTimeouts.get("op", "Get") - before; n != want {
+				t.Errorf("counted %v timeouts, want %v", n, want)
+			}
+		})
+	}
+}
+
+// Queries give up on a driver that is stuck holding its lock, and work
+// again once it is released.
+func TestQueryTimeout(t *testing.T) {
+	const timeout = 20 * time.Millisecond
+	d := newTestDriver(t, Config{QueryTimeout: timeout}, &fakeRunner{})
+	mustCreate(t, d, "b", nil)
+
+	queries := []struct {
+		op string
+		f  func() error
+	}{
+		{"Get", func() error {
+			_, err := d.Get(&volume.GetRequest{Name: "b"})
+			return err
+		}},
+		{"List", func() error {
+			_, err := d.List()
+			return err
+		}},
+		{"Path", func() error {
+			_, err := d.Path(&volume.PathRequest{Name: "b"})
+			return err
+		}},
+	}
+
+	held := make(chan struct{})
+	go func() {
+		d.Lock()
+		close(held)
+		time.Sleep(10 * timeout)
+		d.Unlock()
+	}()
+	<-held
+	for _, q := range queries {
+		if err := q.f(); err != (errQueryTimeout{op: q.op, timeout: timeout}) {
+			t.Errorf("%s while the lock is held: got %v", q.op, err)
+		}
+	}
+
+	d.Lock()
+	d.Unlock()
+	for _, q := range queries {
+		if err := q.f(); err != nil {
+			t.Errorf("%s after the lock was released: %s", q.op, err)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Spans are sent in batches of at most that many, at least that often.
+const (
+	traceBatch    = 64
+	traceInterval = 5 * time.Second
+)
+
+var droppedSpans = newCounter("gcs_spans_dropped_total", "Number of spans that were dropped because the collector did not keep up.")
+
+// tracer sends spans to an OpenTelemetry collector, by OTLP over HTTP in
+// its JSON encoding, see Config.TraceEndpoint. A nil tracer, and the nil
+// spans it starts, do nothing.
+type tracer struct {
+	url    string
+	client *http.Client
+
+	spans chan *span
+
+	// Closed to send the remaining spans, after which done is closed.
+	stop, done chan struct{}
+}
+
+// span covers an operation of the driver, such as Mount.
+type span struct {
+	t      *tracer
+	name   string
+	trace  [16]byte
+	id     [8]byte
+	parent [8]byte
+	start  time.Time
+	end    time.Time
+	attrs  []string
+	err    error
+}
+
+func newTracer(endpoint string) *tracer {
+	if endpoint == "" {
+		return nil
+	}
+	t := &tracer{
+		url:    strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		client: &http.Client{Timeout: 10 * time.Second},
+		spans:  make(chan *span, 16*traceBatch),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go t.export()
+	return t
+}
+
+// start begins a span below parent, or a new trace if parent is nil. kv
+// are pairs of names and values of attributes.
+func (t *tracer) start(name string, parent *span, kv ...string) *span {
+	if t == nil {
+		return nil
+	}
+	s := &span{t: t, name: name, start: time.Now(), attrs: kv}
+	rand.Read(s.id[:])
+	if parent != nil {
+		s.trace, s.parent = parent.trace, parent.id
+	} else {
+		rand.Read(s.trace[:])
+	}
+	return s
+}
+
+// child begins a span below s.
+func (s *span) child(name string, kv ...string) *span {
+	if s == nil {
+		return nil
+	}
+	return s.t.start(name, s, kv...)
+}
+
+// finish ends s, which failed if err is set, and queues it for sending.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.end, s.err = time.Now(), err
+	select {
+	case s.t.spans <- s:
+	default:
+		droppedSpans.add(1)
+	}
+}
+
+// shutdown sends the remaining spans, waiting at most timeout.
+func (t *tracer) shutdown(timeout time.Duration) {
+	if t == nil {
+		return
+	}
+	close(t.stop)
+	select {
+	case <-t.done:
+	case <-time.After(timeout):
+		warnf("Sending spans did not finish within %s, some of them might be lost.", timeout)
+	}
+}
+
+func (t *tracer) export() {
+	defer close(t.done)
+
+	tick := time.NewTicker(traceInterval)
+	defer tick.Stop()
+
+	var batch []*span
+	for {
+		select {
+		case s := <-t.spans:
+			batch = append(batch, s)
+			if len(batch) < traceBatch {
+				continue
+			}
+		case <-tick.C:
+		case <-t.stop:
+			for len(t.spans) > 0 {
+				batch = append(batch, <-t.spans)
+			}
+			t.send(batch)
+			return
+		}
+		t.send(batch)
+		batch = nil
+	}
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Status       struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+// Values of the enums of OTLP.
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	statusOK         = 1
+	statusError      = 2
+)
+
+func attributes(kv []string) []otlpAttribute {
+	var attrs []otlpAttribute
+	for i := 0; i+1 < len(kv); i += 2 {
+		var a otlpAttribute
+		a.Key, a.Value.StringValue = kv[i], kv[i+1]
+		attrs = append(attrs, a)
+	}
+	return attrs
+}
+
+func (t *tracer) send(batch []*span) {
+	if len(batch) == 0 {
+		return
+	}
+
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		o := otlpSpan{
+			TraceID:    hex.EncodeToString(s.trace[:]),
+			SpanID:     hex.EncodeToString(s.id[:]),
+			Name:       s.name,
+			Kind:       spanKindServer,
+			Start:      strconv.FormatInt(s.start.UnixNano(), 10),
+			End:        strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes: attributes(s.attrs),
+		}
+		if s.parent != [8]byte{} {
+			o.ParentSpanID, o.Kind = hex.EncodeToString(s.parent[:]), spanKindInternal
+		}
+		o.Status.Code = statusOK
+		if s.err != nil {
+			o.Status.Code, o.Status.Message = statusError, s.err.Error()
+		}
+		spans = append(spans, o)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": attributes([]string{"service.name", "docker-volume-gcs"}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "github.com/lorenzleutgeb/docker-volume-gcs/gcs"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		errorf("Encoding spans failed: %s", err)
+		return
+	}
+
+	r, err := t.client.Post(t.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		warnf("Sending %d spans failed: %s", len(batch), err)
+		droppedSpans.add(float64(len(batch)))
+		return
+	}
+	r.Body.Close()
+	if r.StatusCode/100 != 2 {
+		warnf("Sending %d spans failed: %s", len(batch), r.Status)
+		droppedSpans.add(float64(len(batch)))
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// collector receives spans like an OpenTelemetry collector, answering
+// with status.
+type collector struct {
+	status int
+
+	mu    sync.Mutex
+	paths []string
+	spans []otlpSpan
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []otlpAttribute `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []otlpSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paths = append(c.paths, r.URL.Path)
+	for _, rs := range req.ResourceSpans {
+		if a := rs.Resource.Attributes; len(a) != 1 || a[0].Value.StringValue != "docker-volume-gcs" {
+			http.Error(w, "no service.name", http.StatusBadRequest)
+			return
+		}
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+	if c.status != 0 {
+		w.WriteHeader(c.status)
+	}
+}
+
+func (c *collector) received() []otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]otlpSpan(nil), c.spans...)
+}
+
+func newCollector(t *testing.T, status int) (*collector, string) {
+	c := &collector{status: status}
+	srv := httptest.NewServer(c)
+	t.Cleanup(srv.Close)
+	return c, srv.URL
+}
+
+func TestTracer(t *testing.T) {
+	c, url := newCollector(t, 0)
+	tr := newTracer(url + "/")
+
+	root := tr.start("Mount", nil, "volume", "b", "gcsfuse")
+	ok := root.child("start_gcsfuse")
+	ok.finish(nil)
+	failed := root.child("await_mounted", "attempt", "1")
+	failed.finish(errors.New("exit status 1"))
+	root.finish(errZombie)
+	tr.shutdown(time.Second)
+
+	if len(c.paths) != 1 || c.paths[0] != "/v1/traces" {
+		t.Errorf("sent to %q, want one batch to /v1/traces", c.paths)
+	}
+	spans := c.received()
+	if len(spans) != 3 {
+		t.Fatalf("received %d spans, want 3", len(spans))
+	}
+	byName := map[string]otlpSpan{}
+	for _, s := range spans {
+		byName[s.Name] = s
+		if len(s.TraceID) != 32 || len(s.SpanID) != 16 || s.End < s.Start {
+			t.Errorf("span %s is malformed: %+v", s.Name, s)
+		}
+		if s.TraceID != spans[0].TraceID {
+			t.Errorf("span %s is in trace %s, want %s", s.Name, s.TraceID, spans[0].TraceID)
+		}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		parent  string
+		kind    int
+		code    int
+		message string
+		attrs   map[string]string
+	}{
+		// The attribute without a value is dropped.
+		{"Mount", "", spanKindServer, statusError, errZombie.Error(), map[string]string{"volume": "b"}},
+		{"start_gcsfuse", "Mount", spanKindInternal, statusOK, "", map[string]string{}},
+		{"await_mounted", "Mount", spanKindInternal, statusError, "exit status 1", map[string]string{"attempt": "1"}},
+	} {
+		s, found := byName[tc.name]
+		if !found {
+			t.Errorf("no span %s", tc.name)
+			continue
+		}
+		parent := ""
+		if tc.parent != "" {
+			parent = byName[tc.parent].SpanID
+		}
+		if s.ParentSpanID != parent || s.Kind != tc.kind {
+			t.Errorf("span %s has parent %q and kind %d, want %q and %d", tc.name, s.ParentSpanID, s.Kind, parent, tc.kind)
+		}
+		if s.Status.Code != tc.code || s.Status.Message != tc.message {
+			t.Errorf("span %s has status %d %q, want %d %q", tc.name, s.Status.Code, s.Status.Message, tc.code, tc.message)
+		}
+		attrs := map[string]string{}
+		for _, a := range s.Attributes {
+			attrs[a.Key] = a.Value.StringValue
+		}
+		if len(attrs) != len(tc.attrs) {
+			t.Errorf("span %s has attributes %v, want %v", tc.name, attrs, tc.attrs)
+		}
+		for k, v := range tc.attrs {
+			if attrs[k] != v {
+				t.Errorf("span %s has attributes %v, want %v", tc.name, attrs, tc.attrs)
+			}
+		}
+	}
+}
+
+func TestTracerBatches(t *testing.T) {
+	c, url := newCollector(t, 0)
+	tr := newTracer(url)
+	for i := 0; i < traceBatch+1; i++ {
+		tr.start("Unmount", nil).finish(nil)
+	}
+	// A full batch is sent right away, the rest with the next tick.
+	for deadline := time.Now().Add(time.Second); len(c.received()) < traceBatch && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(c.received()) != traceBatch {
+		t.Fatalf("received %d spans, want a batch of %d", len(c.received()), traceBatch)
+	}
+	tr.shutdown(time.Second)
+	if n := len(c.received()); n != traceBatch+1 {
+		t.Errorf("received %d spans after shutdown, want %d", n, traceBatch+1)
+	}
+}
+
+func TestTracerDrops(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		status int
+		spans  int
+	}{
+		{"accepted", http.StatusOK, 0},
+		{"rejected", http.StatusServiceUnavailable, 2},
+		{"unreachable", 0, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, url := newCollector(t, tc.status)
+			if tc.status == 0 {
+				url = "http://127.0.0.1:1"
+			}
+			before := droppedSpans.get()
+			tr := newTracer(url)
+			tr.start("Mount", nil).finish(nil)
+			tr.start("Mount", nil).finish(nil)
+			tr.shutdown(15 * time.Second)
+			if n := droppedSpans.get() - before; n != float64(tc.spans) {
+				t.Errorf("dropped %v spans, want %d", n, tc.spans)
+			}
+		})
+	}
+}
+
+// Spans are dropped rather than blocking the driver, if the queue is full.
+func TestTracerQueueFull(t *testing.T) {
+	tr := &tracer{spans: make(chan *span, 1)}
+	before := droppedSpans.get()
+	for i := 0; i < 3; i++ {
+		tr.start("Mount", nil).finish(nil)
+	}
+	if n := droppedSpans.get() - before; n != 2 {
+		t.Errorf("dropped %v spans, want 2", n)
+	}
+}
+
+// Without Config.TraceEndpoint, the tracer and its spans are nil, and do
+// nothing.
+func TestTracerOff(t *testing.T) {
+	tr := newTracer("")
+	if tr != nil {
+		t.Fatalf("got tracer %+v", tr)
+	}
+	s := tr.start("Mount", nil)
+	s.child("start_gcsfuse").finish(nil)
+	s.finish(nil)
+	tr.shutdown(time.Second)
+}
+
+func TestTraceMount(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		output string
+		want   map[string]int
+	}{
+		{"mounted", successLine, map[string]int{
+			"Mount":           statusOK,
+			"start_gcsfuse":   statusOK,
+			"await_mounted":   statusOK,
+			"post_mount_hook": statusOK,
+			"Unmount":         statusOK,
+			"stop_gcsfuse":    statusOK,
+		}},
+		{"failed", "bucket does not exist", map[string]int{
+			"Mount":         statusError,
+			"start_gcsfuse": statusOK,
+			"await_mounted": statusError,
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, url := newCollector(t, 0)
+			d := newTestDriver(t, Config{TraceEndpoint: url}, &fakeRunner{output: tc.output})
+			mustCreate(t, d, "b", nil)
+			if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"}); err == nil {
+				if err := d.Unmount(&volume.UnmountRequest{Name: "b", ID: "1"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			d.tracer.shutdown(time.Second)
+
+			got := map[string]int{}
+			for _, s := range c.received() {
+				got[s.Name] = s.Status.Code
+			}
+			if len(got) != len(tc.want) {
+				t.Errorf("got spans %v, want %v", got, tc.want)
+			}
+			for name, code := range tc.want {
+				if got[name] != code {
+					t.Errorf("span %s has status %d, want %d", name, got[name], code)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// Commands that unmount a FUSE file system, given the mountpoint as last
+// argument, by name of the tool. See Config.UnmountTool.
+var unmountTools = map[string][]string{
+	"fusermount3": {"fusermount3", "-u"},
+	"fusermount":  {"fusermount", "-u"},
+	"umount":      {"umount"},
+}
+
+// Flags that make unmountTools detach the mountpoint right away, and clean
+// up once it is not busy anymore. See Config.LazyUnmount.
+var lazyFlags = map[string]string{
+	"fusermount3": "-z",
+	"fusermount":  "-z",
+	"umount":      "-l",
+}
+
+// Unmounting a busy mountpoint is tried this often, waiting
+// unmountBackoff before the first retry, and twice as long before each
+// further one.
+const (
+	unmountAttempts = 4
+	unmountBackoff  = 100 * time.Millisecond
+)
+
+var unmounts = newCounter("gcs_unmounts_total", "Number of mountpoints that gcsfuse left behind and that were unmounted, by strategy.")
+
+// Tools that "auto" picks from, in order of preference.
+var autoUnmountTools = []string{"fusermount3", "fusermount", "umount"}
+
+type errUnmountTool struct {
+	tool string
+}
+
+func (e errUnmountTool) Error() string {
+	return fmt.Sprintf("unknown unmount tool %q, use fusermount, fusermount3, umount or auto", e.tool)
+}
+
+// unmountCommand resolves tool to a command that is installed.
+func unmountCommand(tool string) ([]string, error) {
+	if tool == "" || tool == "auto" {
+		for _, t := range autoUnmountTools {
+			if _, err := exec.LookPath(t); err == nil {
+				return unmountTools[t], nil
+			}
+		}
+		return nil, errUnmountTool{tool: "auto"}
+	}
+
+	cmd, ok := unmountTools[tool]
+	if !ok {
+		return nil, errUnmountTool{tool: tool}
+	}
+	if _, err := exec.LookPath(cmd[0]); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// lazy returns the variant of the unmount command cmd that detaches the
+// mountpoint even while it is busy.
+func lazy(cmd []string) []string {
+	return append(append([]string{}, cmd...), lazyFlags[cmd[0]])
+}
+
+// umount runs the unmount command for mnt. Once busy, it is retried with backoff, and
+// finally run lazily if Config.LazyUnmount is set. The strategy that
+// worked is logged and counted.
+func (d Driver) umount(mnt string) error {
+	wait := unmountBackoff
+	var out []byte
+	var err error
+	for i := 1; i <= unmountAttempts; i++ {
+		args := append(append([]string{}, d.unmount[1:]...), mnt)
+		out, err = exec.Command(d.unmount[0], args...).CombinedOutput()
+		if err == nil {
+			strategy := "normal"
+			if i > 1 {
+				strategy = "retry"
+				infof("Unmounted %s after %d attempts.", mnt, i)
+			}
+			unmounts.add(1, "strategy", strategy)
+			return nil
+		}
+		if !bytes.Contains(bytes.ToLower(out), []byte("busy")) || i == unmountAttempts {
+			break
+		}
+		debugf("%s is busy, trying to unmount it again in %s.", mnt, wait)
+		time.Sleep(wait)
+		wait *= 2
+	}
+	errorf("Unmounting %s failed: %s", mnt, bytes.TrimSpace(out))
+
+	if !d.cfg.LazyUnmount {
+		return err
+	}
+	cmd := lazy(d.unmount)
+	args := append(append([]string{}, cmd[1:]...), mnt)
+	if out, err := exec.Command(cmd[0], args...).CombinedOutput(); err != nil {
+		errorf("Unmounting %s lazily failed: %s", mnt, bytes.TrimSpace(out))
+		return err
+	}
+	warnf("Unmounted %s lazily, it is cleaned up once nobody uses it anymore.", mnt)
+	unmounts.add(1, "strategy", "lazy")
+	return nil
+}
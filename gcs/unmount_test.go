@@ -0,0 +1,155 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// tools puts executables with the given names on an otherwise empty path,
+// each running body, and returns the directory.
+func tools(t *testing.T, body string, names ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+body+"\n"), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir)
+	return dir
+}
+
+func TestUnmountCommand(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		installed []string
+		tool      string
+		want      []string
+		err       bool
+	}{
+		{"auto prefers fusermount3", []string{"fusermount3", "fusermount", "umount"}, "auto", []string{"fusermount3", "-u"}, false},
+		{"auto falls back to fusermount", []string{"fusermount", "umount"}, "", []string{"fusermount", "-u"}, false},
+		{"auto falls back to umount", []string{"umount"}, "auto", []string{"umount"}, false},
+		{"auto without tools", nil, "auto", nil, true},
+		{"explicit", []string{"fusermount3", "umount"}, "umount", []string{"umount"}, false},
+		{"explicit not installed", []string{"fusermount3"}, "umount", nil, true},
+		{"unknown", []string{"fusermount3"}, "eject", nil, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tools(t, "exit 0", tc.installed...)
+			got, err := unmountCommand(tc.tool)
+			if (err != nil) != tc.err {
+				t.Fatalf("got error %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	tools(t, "exit 0")
+	if _, err := unmountCommand("auto"); err != (errUnmountTool{tool: "auto"}) {
+		t.Errorf("got %v, want %v", err, errUnmountTool{tool: "auto"})
+	}
+}
+
+func TestUmount(t *testing.T) {
+	const busy = `echo "$@" >> "${0%/*}/calls"
+n=0
+while read l; do n=$((n+1)); done < "${0%/*}/calls"
+case "$1 $2" in
+"-u -z") exit 0 ;;
+esac
+if [ $n -le BUSY ]; then
+	echo "fusermount: failed to unmount $3: Device or resource busy"
+	exit 1
+fi`
+	for _, tc := range []struct {
+		name     string
+		busy     string
+		lazy     bool
+		calls    int
+		strategy string
+		failed   bool
+	}{
+		{"normal", "0", false, 1, "normal", false},
+		{"busy once", "1", false, 2, "retry", false},
+		{"busy", "99", false, unmountAttempts, "", true},
+		{"busy lazily", "99", true, unmountAttempts + 1, "lazy", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := tools(t, strings.Replace(busy, "BUSY", tc.busy, 1), "fusermount")
+			d := Driver{cfg: &Config{LazyUnmount: tc.lazy}, unmount: unmountTools["fusermount"]}
+			before := map[string]float64{}
+			for _, s := range []string{"normal", "retry", "lazy"} {
+				before[s] = unmounts.get("strategy", s)
+			}
+
+			err := d.umount("/mnt/b")
+			if (err != nil) != tc.failed {
+				t.Errorf("got %v, want failed %t", err, tc.failed)
+			}
+			b, _ := ioutil.ReadFile(filepath.Join(dir, "calls"))
+			calls := strings.Split(strings.TrimSpace(string(b)), "\n")
+			if len(calls) != tc.calls {
+				t.Errorf("called %q, want %d calls", calls, tc.calls)
+			}
+			if tc.lazy && calls[len(calls)-1] != "-u -z /mnt/b" {
+				t.Errorf("last call was %q, want a lazy one", calls[len(calls)-1])
+			}
+			for s, n := range before {
+				want := n
+				if s == tc.strategy {
+					want++
+				}
+				if got := unmounts.get("strategy", s); got != want {
+					t.Errorf("counted %v unmounts with strategy %s, want %v", got, s, want)
+				}
+			}
+		})
+	}
+}
+
+// Other failures are not retried.
+func TestUmountNotBusy(t *testing.T) {
+	dir := tools(t, `echo "$@" >> "${0%/*}/calls"; echo "fusermount: entry for $2 not found"; exit 1`, "fusermount")
+	d := Driver{cfg: &Config{}, unmount: unmountTools["fusermount"]}
+	if err := d.umount("/mnt/b"); err == nil {
+		t.Error("succeeded")
+	}
+	b, _ := ioutil.ReadFile(filepath.Join(dir, "calls"))
+	if got, want := string(b), "-u /mnt/b\n"; got != want {
+		t.Errorf("called %q, want %q", got, want)
+	}
+}
+
+func TestLazy(t *testing.T) {
+	for tool, cmd := range unmountTools {
+		got := lazy(cmd)
+		if got[len(got)-1] == "" || len(got) != len(cmd)+1 {
+			t.Errorf("lazy %s is %q", tool, got)
+		}
+		if len(cmd) > 0 && &got[0] == &cmd[0] {
+			t.Errorf("lazy %s shares its arguments", tool)
+		}
+	}
+}
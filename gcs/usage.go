@@ -0,0 +1,114 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var (
+	bucketBytes   = newGauge("gcs_bucket_bytes", "Total size of the objects in mounted buckets.")
+	bucketObjects = newGauge("gcs_bucket_objects", "Number of objects in mounted buckets.")
+	usageAlarm    = newGauge("gcs_bucket_usage_alarm", "Whether a mounted bucket exceeds max_size or max_objects.")
+)
+
+// Last line of `gcloud storage ls -l -r`.
+var usageTotal = regexp.MustCompile(`TOTAL: (\d+) objects, (\d+) bytes`)
+
+// limits are the thresholds of usage that raise an alarm, 0 means none.
+type limits struct {
+	size    int64
+	objects int64
+}
+
+// usageLimits returns the limits set by the options of a volume, which are
+// validated already, also see mountOptions.
+func usageLimits(opts map[string]string) limits {
+	var l limits
+	l.size, _ = strconv.ParseInt(opts["max_size"], 10, 64)
+	l.objects, _ = strconv.ParseInt(opts["max_objects"], 10, 64)
+	return l
+}
+
+// watchUsage periodically looks up the usage of mounted buckets and raises
+// alarms for those that exceed their limits, see Config.UsageInterval.
+func (d Driver) watchUsage() {
+	for range time.Tick(d.cfg.UsageInterval) {
+		d.Lock()
+		watched := make(map[string]limits)
+		for k, m := range d.cmds {
+			if m.proc != nil && m.err == nil {
+				watched[k] = m.limits
+			}
+		}
+		d.Unlock()
+
+		// Without the lock, gcloud takes its time.
+		for k, l := range watched {
+			d.checkUsage(k, l)
+		}
+	}
+}
+
+// checkUsage looks up the usage of the bucket or subpath identified by k
+// and compares it to l.
+func (d Driver) checkUsage(k string, l limits) {
+	out, err := exec.Command("gcloud", "storage", "ls", "-l", "-r", scheme+k+"/**").Output()
+	if err != nil {
+		errorf("Looking up usage of %s failed: %s", k, err)
+		return
+	}
+	match := usageTotal.FindSubmatch(out)
+	if match == nil {
+		errorf("Looking up usage of %s failed: unexpected output of gcloud", k)
+		return
+	}
+	objects, _ := strconv.ParseInt(string(match[1]), 10, 64)
+	size, _ := strconv.ParseInt(string(match[2]), 10, 64)
+
+	bucketObjects.set(float64(objects), "bucket", k)
+	bucketBytes.set(float64(size), "bucket", k)
+	alarm(k, "size", l.size, size)
+	alarm(k, "objects", l.objects, objects)
+}
+
+// forgetUsage deletes the usage metrics of k once it is unmounted.
+func forgetUsage(k string) {
+	bucketObjects.delete("bucket", k)
+	bucketBytes.delete("bucket", k)
+	usageAlarm.delete("bucket", k, "kind", "size")
+	usageAlarm.delete("bucket", k, "kind", "objects")
+}
+
+// alarm raises or clears the alarm of the given kind for k, depending on
+// whether value exceeds limit. A warning is logged when it is raised.
+func alarm(k, kind string, limit, value int64) {
+	if limit <= 0 {
+		return
+	}
+	if value <= limit {
+		usageAlarm.set(0, "bucket", k, "kind", kind)
+		return
+	}
+	if usageAlarm.get("bucket", k, "kind", kind) == 0 {
+		warnf("Warning: %s exceeds its limit on %s, %d > %d.", k, kind, value, limit)
+	}
+	usageAlarm.set(1, "bucket", k, "kind", kind)
+}
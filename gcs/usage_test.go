@@ -0,0 +1,105 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUsageLimits(t *testing.T) {
+	for _, tc := range []struct {
+		opts map[string]string
+		want limits
+	}{
+		{nil, limits{}},
+		{map[string]string{"max_size": "1024"}, limits{size: 1024}},
+		{map[string]string{"max_objects": "10"}, limits{objects: 10}},
+		{map[string]string{"max_size": "1", "max_objects": "2", "access": "ro"}, limits{size: 1, objects: 2}},
+	} {
+		if got := usageLimits(tc.opts); got != tc.want {
+			t.Errorf("usageLimits(%v) = %+v, want %+v", tc.opts, got, tc.want)
+		}
+	}
+}
+
+// fakeGcloud puts a gcloud on the path that prints output.
+func fakeGcloud(t *testing.T, output string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "output"), []byte(output), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sh := "#!/bin/sh\ncat \"$(dirname \"$0\")/output\"\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "gcloud"), []byte(sh), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestCheckUsage(t *testing.T) {
+	d := Driver{}
+	for _, tc := range []struct {
+		name    string
+		output  string
+		l       limits
+		objects float64
+		bytes   float64
+		size    float64
+		count   float64
+	}{
+		{"below", "TOTAL: 3 objects, 100 bytes (100B)\n", limits{size: 1000, objects: 10}, 3, 100, 0, 0},
+		{"size", "TOTAL: 3 objects, 2000 bytes (1.95kiB)\n", limits{size: 1000, objects: 10}, 3, 2000, 1, 0},
+		{"objects", "TOTAL: 11 objects, 2000 bytes (1.95kiB)\n", limits{size: 1000, objects: 10}, 11, 2000, 1, 1},
+		{"cleared", "TOTAL: 1 objects, 10 bytes (10B)\n", limits{size: 1000, objects: 10}, 1, 10, 0, 0},
+		// Usage stays as it was.
+		{"bad output", "nope\n", limits{size: 1000, objects: 10}, 1, 10, 0, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeGcloud(t, tc.output)
+			d.checkUsage("b", tc.l)
+			for _, got := range []struct {
+				name string
+				v    float64
+				want float64
+			}{
+				{"objects", bucketObjects.get("bucket", "b"), tc.objects},
+				{"bytes", bucketBytes.get("bucket", "b"), tc.bytes},
+				{"size alarm", usageAlarm.get("bucket", "b", "kind", "size"), tc.size},
+				{"objects alarm", usageAlarm.get("bucket", "b", "kind", "objects"), tc.count},
+			} {
+				if got.v != got.want {
+					t.Errorf("%s is %g, want %g", got.name, got.v, got.want)
+				}
+			}
+		})
+	}
+
+	forgetUsage("b")
+	if v := bucketBytes.get("bucket", "b"); v != 0 {
+		t.Errorf("bytes are %g after forgetting", v)
+	}
+}
+
+func TestAlarmWithoutLimit(t *testing.T) {
+	alarm("nolimit", "size", 0, 1<<40)
+	if v := usageAlarm.get("bucket", "nolimit", "kind", "size"); v != 0 {
+		t.Errorf("alarm is %g without a limit", v)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"sort"
+)
+
+// ValidateOptions checks the options of a volume called name offline, as
+// Create would, and returns the arguments that gcsfuse would be started
+// with. Otherwise, it returns every bad option, or the first problem with
+// their combination. Options from .gcsopts and Config.User and
+// Config.Group are not applied, and nothing is mounted. Unless onHost is
+// set, only the syntax counts: names of users and groups are not
+// resolved, and gcsfuse and the kernel are assumed to support everything.
+// With onHost, they are checked on this host, as the driver would.
+func ValidateOptions(c Config, name string, opts map[string]string, onHost bool) ([]string, []error) {
+	if err := checkArgs(c.GcsfuseArgs); err != nil {
+		return nil, []error{err}
+	}
+
+	keys := make([]string, 0, len(opts))
+	for k := range opts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, k := range keys {
+		if err := checkOption(k, opts[k]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	d := Driver{cfg: &c, host: anyHost}
+	if onHost {
+		d.host = localHost
+	}
+	if err := d.checkExport(opts); err != nil {
+		return nil, []error{err}
+	}
+	args, err := d.buildArgs(d.key(normalize(name), opts), opts)
+	if err != nil {
+		return nil, []error{err}
+	}
+	return args, nil
+}
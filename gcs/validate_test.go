@@ -0,0 +1,97 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateOptions(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		volume string
+		opts   map[string]string
+		onHost bool
+		args   []string
+		errs   []error
+	}{
+		{
+			name:   "defaults",
+			volume: "b",
+			args:   []string{"--foreground", "-o", "subtype=gcsfuse,rw", "b", "/mnt/b"},
+		},
+		{
+			name:   "subpath",
+			volume: "gs://b/reports/",
+			opts:   map[string]string{"only_dir": "true", "access": "ro"},
+			args:   []string{"--foreground", "--only-dir=reports", "-o", "subtype=gcsfuse,ro", "b", "/mnt/.subpaths/b/reports"},
+		},
+		{
+			name:   "names are not resolved",
+			volume: "b",
+			opts:   map[string]string{"user": "no-such-user", "group": "no-such-group"},
+			args:   []string{"--foreground", "--gid=no-such-group", "--uid=no-such-user", "-o", "subtype=gcsfuse,rw", "b", "/mnt/b"},
+		},
+		{
+			name:   "names are resolved on the host",
+			volume: "b",
+			opts:   map[string]string{"user": "no-such-user"},
+			onHost: true,
+			errs:   []error{errUnknownUser{name: "no-such-user"}},
+		},
+		{
+			name:   "the host is not asked",
+			volume: "b",
+			opts:   map[string]string{"memory_limit": "268435456", "cache_dir": "/cache", "refresh_interval": "1m"},
+			args:   []string{"--foreground", "--cache-dir=/cache", "--temp-dir=/cache", "--metadata-cache-ttl-secs=60", "-o", "subtype=gcsfuse,rw", "b", "/mnt/b"},
+		},
+		{
+			name:   "every bad option",
+			volume: "b",
+			opts:   map[string]string{"access": "rx", "nope": "1", "user": "-1", "max_read": "1"},
+			errs: []error{
+				errBadOption{key: "access", value: "rx", reason: "want one of ro, rw"},
+				errBadOption{key: "max_read", value: "1", reason: "want an integer from 4096 to 1048576"},
+				errUnknownOption{key: "nope"},
+				errBadOption{key: "user", value: "-1", reason: "want a name or numeric id"},
+			},
+		},
+		{
+			name:   "combination",
+			volume: "b",
+			opts:   map[string]string{"kernel_cache": "true"},
+			errs:   []error{errBadOption{key: "kernel_cache", value: "true", reason: "requires access=ro, or kernel_cache=force if objects never change"}},
+		},
+		{
+			name:   "export without command",
+			volume: "b",
+			opts:   map[string]string{"nfs_export": "true"},
+			errs:   []error{errNoExport},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			args, errs := ValidateOptions(Config{Root: "/mnt"}, tc.volume, tc.opts, tc.onHost)
+			if !reflect.DeepEqual(errs, tc.errs) {
+				t.Fatalf("got errors %v, want %v", errs, tc.errs)
+			}
+			if !reflect.DeepEqual(args, tc.args) {
+				t.Errorf("got %q, want %q", args, tc.args)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// How long warm may take, no matter the depth.
+	warmTimeout = 30 * time.Second
+
+	// Deepest level that warm_cache may ask for.
+	maxWarmDepth = 16
+)
+
+// Number of entries that are read from a directory at once, the deadline
+// of warm is checked in between.
+const warmBatch = 256
+
+var errWarmTimeout = errors.New("deadline exceeded")
+
+// warm walks the directories below mnt up to the given depth, where 1 only
+// lists mnt itself, so that gcsfuse caches their entries and attributes
+// (see warm_cache). It gives up after timeout, and returns how many
+// entries it saw.
+func warm(mnt string, depth int, timeout time.Duration) (int, error) {
+	deadline := time.Now().Add(timeout)
+	n := 0
+
+	dirs := []string{mnt}
+	for level := 0; level < depth && len(dirs) > 0; level++ {
+		var next []string
+		for _, dir := range dirs {
+			f, err := os.Open(dir)
+			if err != nil {
+				return n, err
+			}
+			for {
+				if time.Now().After(deadline) {
+					f.Close()
+					return n, errWarmTimeout
+				}
+				// Readdir stats the entries, which is the point.
+				fis, err := f.Readdir(warmBatch)
+				n += len(fis)
+				for _, fi := range fis {
+					if fi.IsDir() {
+						next = append(next, filepath.Join(dir, fi.Name()))
+					}
+				}
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					f.Close()
+					return n, err
+				}
+			}
+			f.Close()
+		}
+		dirs = next
+	}
+	return n, nil
+}
+
+// warmCache runs warm for the bucket identified by k, which was mounted at
+// mnt by a volume with the given options, if they ask for it. Failures are
+// only logged, the bucket is mounted after all.
+func (d Driver) warmCache(k, mnt string, opts map[string]string, sp *span) {
+	v, ok := opts["warm_cache"]
+	if !ok {
+		return
+	}
+	depth, _ := parseInt(v, 1, maxWarmDepth)
+
+	s := sp.child("warm_cache")
+	start := time.Now()
+	n, err := warm(mnt, int(depth), warmTimeout)
+	s.finish(err)
+	if err != nil {
+		warnf("Warming caches of %s stopped after %d entries in %s: %s", k, n, time.Since(start), err)
+		return
+	}
+	infof("Warmed caches of %s with %d entries in %s.", k, n, time.Since(start))
+}
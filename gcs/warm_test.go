@@ -0,0 +1,107 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package gcs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// tree creates directories and files below dir, named by relative paths
+// where directories end in a slash.
+func tree(t *testing.T, dir string, paths ...string) {
+	t.Helper()
+	for _, p := range paths {
+		path := filepath.Join(dir, p)
+		var err error
+		if p[len(p)-1] == '/' {
+			err = os.MkdirAll(path, 0755)
+		} else if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+			err = ioutil.WriteFile(path, nil, 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWarm(t *testing.T) {
+	dir := t.TempDir()
+	tree(t, dir, "a", "b/", "b/c", "b/d/", "b/d/e", "b/d/f/g", "h/i")
+	for _, tc := range []struct {
+		name    string
+		dir     string
+		depth   int
+		timeout time.Duration
+		n       int
+		err     bool
+	}{
+		{name: "one level", dir: dir, depth: 1, timeout: time.Second, n: 3},
+		{name: "two levels", dir: dir, depth: 2, timeout: time.Second, n: 6},
+		{name: "three levels", dir: dir, depth: 3, timeout: time.Second, n: 8},
+		{name: "all levels", dir: dir, depth: maxWarmDepth, timeout: time.Second, n: 9},
+		{name: "one file", dir: filepath.Join(dir, "b/d/f"), depth: 1, timeout: time.Second, n: 1},
+		{name: "missing", dir: filepath.Join(dir, "missing"), depth: 1, timeout: time.Second, err: true},
+		{name: "timeout", dir: dir, depth: 1, err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			n, err := warm(tc.dir, tc.depth, tc.timeout)
+			if (err != nil) != tc.err || n != tc.n {
+				t.Errorf("got %d entries, %v, want %d and error %t", n, err, tc.n, tc.err)
+			}
+			if tc.timeout == 0 && err != errWarmTimeout {
+				t.Errorf("got %v, want %v", err, errWarmTimeout)
+			}
+		})
+	}
+}
+
+// Mounts are warmed once gcsfuse is ready, if asked for.
+func TestWarmCache(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		opts   map[string]string
+		status int
+	}{
+		{"warm", map[string]string{"warm_cache": "2"}, statusOK},
+		{"not asked", nil, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, url := newCollector(t, 0)
+			d := newTestDriver(t, Config{TraceEndpoint: url}, &fakeRunner{output: successLine})
+			mustCreate(t, d, "b", tc.opts)
+			tree(t, d.target("b"), "a", "b/c")
+			if _, err := d.Mount(&volume.MountRequest{Name: "b", ID: "1"}); err != nil {
+				t.Fatal(err)
+			}
+			d.tracer.shutdown(time.Second)
+
+			spans := map[string]int{}
+			for _, s := range c.received() {
+				spans[s.Name] = s.Status.Code
+			}
+			if code, ok := spans["warm_cache"]; code != tc.status || ok != (tc.status != 0) {
+				t.Errorf("got spans %v", spans)
+			}
+		})
+	}
+}
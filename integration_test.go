@@ -0,0 +1,224 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/sdk"
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/lorenzleutgeb/docker-volume-gcs/gcs"
+)
+
+// Stands in for gcsfuse: prints its usage, or pretends to mount the bucket
+// until it is stopped, and records both in $FAKE_GCSFUSE_LOG.
+const fakeGcsfuse = `#!/bin/sh
+if [ "$1" = --help ]; then
+	echo "--foreground --only-dir --key-file --implicit-dirs --uid --gid"
+	exit 0
+fi
+trap 'echo "stopped $*" >>"$FAKE_GCSFUSE_LOG"; exit 0' INT TERM
+echo "started $*" >>"$FAKE_GCSFUSE_LOG"
+echo "File system has been successfully mounted." >&2
+while :; do sleep 1 </dev/null >/dev/null 2>&1 & wait $!; done
+`
+
+// serveDriver runs the driver like main does, with the fake gcsfuse, and
+// returns the socket and the log of gcsfuse.
+func serveDriver(t *testing.T) (socket, gcsfuse string) {
+	t.Helper()
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(bin, "gcsfuse"), []byte(fakeGcsfuse), 0755); err != nil {
+		t.Fatal(err)
+	}
+	gcsfuse = filepath.Join(dir, "gcsfuse.log")
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_GCSFUSE_LOG", gcsfuse)
+
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	d, err := gcs.New(gcs.Config{Root: root, MountConcurrency: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	socket = filepath.Join(dir, "gcs.sock")
+	l, err := listen(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := volume.NewHandler(d)
+	d.Register(h)
+	go h.Serve(l)
+	t.Cleanup(func() {
+		l.Close()
+		d.Shutdown()
+	})
+	return socket, gcsfuse
+}
+
+// post sends body to method of the volume plugin protocol, and returns
+// the status and the body of the response.
+func post(t *testing.T, socket, method, body string) (int, string) {
+	t.Helper()
+	r, err := client(socket).Post("http://plugin/"+method, sdk.DefaultContentTypeV1_1, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.StatusCode, string(b)
+}
+
+// The sequence of calls that Docker makes for `docker run -v b/sub:/data`
+// and `docker volume rm`, and what the plugin responds with. Responses are
+// compared as JSON, and "*" matches any value.
+func TestProtocol(t *testing.T) {
+	socket, gcsfuse := serveDriver(t)
+
+	for _, step := range []struct {
+		method string
+		req    string
+		status int
+		res    string
+	}{
+		{"Plugin.Activate", ``, http.StatusOK, `{"Implements": ["VolumeDriver"]}`},
+		{"VolumeDriver.Capabilities", `{}`, http.StatusOK, `{"Capabilities": {"Scope": "global"}}`},
+		{"VolumeDriver.Create", `{"Name": "b/sub", "Options": {"max_read": "1"}}`, http.StatusInternalServerError, `{"Err": "bad value \"1\" for option \"max_read\": want an integer from 4096 to 1048576"}`},
+		{"VolumeDriver.Create", `{"Name": "b/sub", "Options": {"access": "ro"}}`, http.StatusOK, `{}`},
+		{"VolumeDriver.List", `{}`, http.StatusOK, `{"Volumes": [{"Name": "b/sub", "Mountpoint": "*"}]}`},
+		{"VolumeDriver.Get", `{"Name": "b/sub"}`, http.StatusOK, `{"Volume": {"Name": "b/sub", "Mountpoint": "*", "Status": {"bucket": "b", "subpath": "sub", "only_dir": false, "options_hash": "*"}}}`},
+		{"VolumeDriver.Mount", `{"Name": "b/sub", "ID": "c1"}`, http.StatusOK, `{"Mountpoint": "*"}`},
+		{"VolumeDriver.Path", `{"Name": "b/sub"}`, http.StatusOK, `{"Mountpoint": "*"}`},
+		{"VolumeDriver.Get", `{"Name": "b/sub"}`, http.StatusOK, `{"Volume": {"Name": "b/sub", "Mountpoint": "*", "Status": {"bucket": "b", "subpath": "sub", "only_dir": false, "options_hash": "*", "mounted_options_hash": "*", "references": 1, "access": "ro", "command": "*", "memory": "*"}}}`},
+		{"VolumeDriver.Unmount", `{"Name": "b/sub", "ID": "c1"}`, http.StatusOK, `{}`},
+		{"VolumeDriver.Unmount", `{"Name": "b/sub", "ID": "c1"}`, http.StatusInternalServerError, `{"Err": "*"}`},
+		{"VolumeDriver.Remove", `{"Name": "b/sub"}`, http.StatusOK, `{}`},
+		{"VolumeDriver.List", `{}`, http.StatusOK, `{"Volumes": null}`},
+		{"VolumeDriver.Mount", `{"Name": "b/sub", "ID": "c1"`, http.StatusBadRequest, ``},
+	} {
+		status, res := post(t, socket, step.method, step.req)
+		if status != step.status {
+			t.Fatalf("%s %s: got %d %s, want %d", step.method, step.req, status, res, step.status)
+		}
+		if step.res != "" && !matchJSON(t, res, step.res) {
+			t.Errorf("%s %s: got %s, want %s", step.method, step.req, res, step.res)
+		}
+	}
+
+	b, err := ioutil.ReadFile(gcsfuse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "started ") || !strings.HasPrefix(lines[1], "stopped ") {
+		t.Fatalf("gcsfuse did %q, want it started and stopped once", lines)
+	}
+	for _, want := range []string{"--foreground", ",ro", " b "} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("gcsfuse was %s, want %s in its arguments", lines[0], want)
+		}
+	}
+}
+
+// The subcommands that talk to the plugin get what Docker gets.
+func TestProtocolCall(t *testing.T) {
+	socket, _ := serveDriver(t)
+
+	if err := call(socket, "Create", volume.CreateRequest{Name: "b"}, &struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	var res volume.MountResponse
+	if err := call(socket, "Mount", volume.MountRequest{Name: "b", ID: "c1"}, &res); err != nil {
+		t.Fatal(err)
+	}
+	var got volume.GetResponse
+	if err := call(socket, "Get", volume.GetRequest{Name: "b"}, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Volume.Mountpoint != res.Mountpoint || got.Volume.Status["references"] != 1.0 {
+		t.Errorf("got %+v, want it mounted at %s once", got.Volume, res.Mountpoint)
+	}
+
+	err := call(socket, "Create", volume.CreateRequest{Name: "c", Options: map[string]string{"nope": "1"}}, &struct{}{})
+	if e, ok := err.(errPlugin); !ok || e.method != "Create" || !strings.Contains(e.msg, "nope") {
+		t.Errorf("creating with an unknown option got %v, want an error about it", err)
+	}
+}
+
+// matchJSON reports whether got is the JSON value want, where "*" in want
+// matches any value.
+func matchJSON(t *testing.T, got, want string) bool {
+	t.Helper()
+	var g, w interface{}
+	if err := json.Unmarshal([]byte(got), &g); err != nil {
+		t.Errorf("response %q is no JSON: %s", got, err)
+		return false
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatal(err)
+	}
+	return match(g, w)
+}
+
+func match(got, want interface{}) bool {
+	if want == "*" {
+		return got != nil
+	}
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok || len(g) != len(w) {
+			return false
+		}
+		for k, v := range w {
+			if !match(g[k], v) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok || len(g) != len(w) {
+			return false
+		}
+		for i := range w {
+			if !match(g[i], w[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(got, want)
+}
@@ -0,0 +1,112 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"flag"
+	"log"
+	"log/syslog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/lorenzleutgeb/docker-volume-gcs/gcs"
+)
+
+var (
+	logOutput  = flag.String("log-output", "stderr", "where to write logs to: stderr, syslog or the path of a file")
+	instanceID = flag.String("instance-id", "", "identifies this instance in logs and metrics (defaults to the hostname)")
+	logLevel   = flag.String("driver-log-level", "info", "severity of messages that the driver logs: debug, info, warning or error")
+)
+
+// logFile is a log destination that is reopened on SIGHUP, so that it
+// plays well with logrotate.
+type logFile struct {
+	*sync.Mutex
+	path string
+	f    *os.File
+}
+
+func (l *logFile) Write(p []byte) (int, error) {
+	l.Lock()
+	defer l.Unlock()
+	return l.f.Write(p)
+}
+
+func (l *logFile) reopen() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	if l.f != nil {
+		l.f.Close()
+	}
+	l.f = f
+	return nil
+}
+
+// setupLog directs the standard logger to output, see -log-output, tags
+// all lines with the instance, see -instance-id, and drops messages below
+// -driver-log-level.
+func setupLog(output string) error {
+	if err := gcs.SetLogLevel(*logLevel); err != nil {
+		return err
+	}
+
+	if *instanceID == "" {
+		*instanceID, _ = os.Hostname()
+	}
+	if *instanceID != "" {
+		log.SetPrefix("instance=" + *instanceID + " ")
+		gcs.SetCommonLabels("instance", *instanceID)
+	}
+
+	switch output {
+	case "stderr":
+		return nil
+	case "syslog":
+		w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "docker-volume-gcs")
+		if err != nil {
+			return err
+		}
+		// syslog keeps track of time by itself.
+		log.SetFlags(0)
+		log.SetOutput(w)
+		return nil
+	}
+
+	l := &logFile{Mutex: new(sync.Mutex), path: output}
+	if err := l.reopen(); err != nil {
+		return err
+	}
+	log.SetOutput(l)
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			if err := l.reopen(); err != nil {
+				log.Printf("Reopening %s failed: %s", l.path, err)
+			}
+		}
+	}()
+	return nil
+}
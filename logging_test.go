@@ -0,0 +1,112 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/lorenzleutgeb/docker-volume-gcs/gcs"
+)
+
+// Like logrotate does: the file is moved away, then the plugin is asked
+// to reopen it.
+func TestLogFileReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "plugin.log")
+	l := &logFile{Mutex: new(sync.Mutex), path: path}
+	if err := l.reopen(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, step := range []struct {
+		rotate bool
+		reopen bool
+		files  map[string]string
+	}{
+		{false, false, map[string]string{"plugin.log": "0\n"}},
+		{false, false, map[string]string{"plugin.log": "0\n1\n"}},
+		{true, true, map[string]string{"plugin.log": "2\n", "plugin.log.1": "0\n1\n"}},
+		// Reopening a file that is still there appends to it.
+		{false, true, map[string]string{"plugin.log": "2\n3\n"}},
+	} {
+		if step.rotate {
+			if err := os.Rename(path, path+".1"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if step.reopen {
+			if err := l.reopen(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fmt.Fprintf(l, "%d\n", i)
+
+		for name, want := range step.files {
+			b, err := ioutil.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != want {
+				t.Errorf("after step %d, %s holds %q, want %q", i, name, b, want)
+			}
+		}
+	}
+}
+
+func TestSetupLogInstance(t *testing.T) {
+	host, _ := os.Hostname()
+	for _, tc := range []struct {
+		id   string
+		want string
+	}{
+		{"h1", "instance=h1 hello\n"},
+		{"", "instance=" + host + " hello\n"},
+	} {
+		t.Run(tc.id, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "plugin.log")
+			id, prefix, flags := *instanceID, log.Prefix(), log.Flags()
+			t.Cleanup(func() {
+				*instanceID = id
+				log.SetPrefix(prefix)
+				log.SetFlags(flags)
+				log.SetOutput(os.Stderr)
+				gcs.SetCommonLabels()
+			})
+
+			*instanceID = tc.id
+			if err := setupLog(path); err != nil {
+				t.Fatal(err)
+			}
+			log.SetFlags(0)
+			log.Print("hello")
+
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tc.want {
+				t.Errorf("logged %q, want %q", b, tc.want)
+			}
+		})
+	}
+}
@@ -17,211 +17,223 @@
 package main
 
 import (
-	"bufio"
-	"errors"
-	"fmt"
-	"io"
-	"io/ioutil"
+	"flag"
 	"log"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"os/signal"
 	"strings"
-	"sync"
+	"syscall"
+	"time"
 
-        "github.com/docker/go-plugins-helpers/volume"
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/lorenzleutgeb/docker-volume-gcs/gcs"
 )
 
 // Socket address by convention. Docker will look there, so
 // this needs to be in sync with upstream.
 const socketAddress = "/run/docker/plugins/gcs.sock"
 
-var (
-	errDaemonDirty   = errors.New("gcsfuse did not exit cleanly")
-	errUnknownVolume = errors.New("unknwon volume, no gcfsfuse instance found")
-	errZombie        = errors.New("found gcfsfuse instance where there should be none")
+var root = os.Args[len(os.Args)-1]
 
+// Flags of the driver, see gcs.Config.
+var (
+	mountConcurrency = flag.Int("mount-concurrency", 4, "maximum number of gcsfuse instances that are started at the same time")
+	secureDefaults   = flag.Bool("secure-defaults", false, "mount with noexec, nosuid and nodev unless volumes say otherwise")
+	noatime          = flag.Bool("noatime", true, "mount with noatime unless volumes say otherwise")
+	defaultUser      = flag.String("user", "", "name or id of the user that owns all files, unless volumes say otherwise")
+	defaultGroup     = flag.String("group", "", "name or id of the group that owns all files, unless volumes say otherwise")
+	asyncMount       = flag.Bool("async-mount", false, "default for the async_mount option of volumes")
+	idleTimeout      = flag.Duration("idle-timeout", 0, "keep buckets mounted for this long after the last container stopped using them")
+	maxIdleMounts    = flag.Int("max-idle-mounts", 0, "maximum number of buckets that are kept mounted while unused, 0 means no limit")
+	lookupRegion     = flag.Bool("lookup-region", false, "look up the location of each mounted bucket")
+	hostRegion       = flag.String("region", "", "region of this host, used to warn about distant buckets (defaults to the region reported by the metadata server)")
+	checkOnlyDir     = flag.Bool("check-only-dir", false, "make sure that the subpath of volumes with only_dir exists before mounting them, using gcloud")
+	wrapper          = flag.String("wrapper", "", "command to run gcsfuse with, e.g. \"taskset -c 0-3\"")
+	postMountHook    = flag.String("post-mount-hook", "", "executable to run with the bucket and mountpoint as arguments after mounting")
+	postMountOpt     = flag.Bool("post-mount-hook-optional", false, "do not fail mounting if the post-mount hook fails")
+	preUnmountHook   = flag.String("pre-unmount-hook", "", "executable to run with the bucket and mountpoint as arguments before unmounting")
+	preUnmountReq    = flag.Bool("pre-unmount-hook-required", false, "fail unmounting if the pre-unmount hook fails")
+	hookTimeout      = flag.Duration("hook-timeout", 30*time.Second, "how long hooks may run")
+	reconcileEvery   = flag.Duration("reconcile-interval", 0, "compare mounted buckets with the mount table this often, 0 disables it")
+	reconcileFix     = flag.Bool("reconcile-fix", false, "unmount or relaunch buckets that vanished from the mount table")
+	unmountTool      = flag.String("unmount-tool", "auto", "how to unmount mountpoints that gcsfuse left behind: fusermount, fusermount3, umount or auto")
+	usageInterval    = flag.Duration("usage-interval", 0, "look up the usage of mounted buckets this often, 0 disables it")
+	unmountOnError   = flag.Bool("unmount-on-error", true, "stop gcsfuse and unmount if mounting fails, otherwise keep them for inspection")
+	breakerThreshold = flag.Int("breaker-threshold", 0, "refuse to mount a bucket for a while after it failed this many times in a row, 0 disables it")
+	breakerCooldown  = flag.Duration("breaker-cooldown", time.Minute, "how long to refuse mounting a bucket, see -breaker-threshold")
+	relaunch         = flag.Bool("relaunch", false, "launch gcsfuse again if it exits while containers use it")
+	gcsfuseLogLevel  = flag.String("gcsfuse-log-level", "", "severity of messages that gcsfuse logs: trace, debug, info, warning, error or off")
+	chownMountpoint  = flag.String("chown-mountpoint", "", "owner of mountpoint directories as uid:gid, set before gcsfuse mounts over them")
+	lockWarn         = flag.Duration("lock-warn-threshold", 0, "warn whenever the lock of the driver was held for longer than this, 0 disables it")
+	exportCommand    = flag.String("export-command", "", "executable to run with the bucket and mountpoint as arguments to export volumes with nfs_export")
+	unexportCommand  = flag.String("unexport-command", "", "executable to run with the bucket and mountpoint as arguments before unmounting exported volumes")
+	lazyUnmount      = flag.Bool("lazy-unmount", false, "unmount mountpoints that stay busy lazily")
+	adminTokenFile   = flag.String("admin-token-file", "", "file with a bearer token that administrative endpoints require")
+	adminBasicAuth   = flag.String("admin-basic-auth-file", "", "file with user:password that administrative endpoints accept as basic auth")
+	protectMetrics   = flag.Bool("protect-metrics", false, "require the credentials of administrative endpoints for /metrics too")
+	manageFuseConf   = flag.Bool("manage-fuse-conf", false, "add user_allow_other to /etc/fuse.conf if mounting with allow_other, keeping a backup")
+	shareSubpaths    = flag.Bool("share-subpaths", false, "ignore only_dir, so that volumes of subpaths share one gcsfuse for their bucket")
+	traceEndpoint    = flag.String("otlp-endpoint", "", "base URL of an OpenTelemetry collector to send traces of mounts to by OTLP over HTTP, e.g. http://localhost:4318")
+	reapZombies      = flag.Bool("reap-zombies", false, "adopt orphaned processes and reap exited children, which is always done as PID 1")
+	eventWebhook     = flag.String("event-webhook", "", "URL that receives a JSON event for each mount, unmount and unexpected exit of gcsfuse")
+	cgroupRoot       = flag.String("cgroup-root", "/sys/fs/cgroup/docker-volume-gcs", "cgroup v2 directory below which gcsfuse of volumes with memory_limit runs, in a cgroup each")
+	readiness        = flag.String("readiness", "output", "how to tell that gcsfuse mounted a bucket: output for its message, mounts for the mount table, or either")
+	queryTimeout     = flag.Duration("query-timeout", 10*time.Second, "how long inspecting and listing volumes may take before failing, 0 means no limit")
+	teardownSignal   = flag.String("teardown-signal", "auto", "signal that stops gcsfuse: SIGINT, SIGTERM, or auto for SIGTERM if gcsfuse may ignore interrupts")
+	prefixMap        = flag.String("prefix-map", "", "file that maps prefixes of volume names to other buckets or subpaths, one \"<from> <to>\" per line")
+	strictRoot       = flag.Bool("strict-root", false, "refuse to start if the root is on a network file system or in a FUSE mount")
+	listSource       = flag.String("list-source", "memory", "where to list volumes from: memory for those created since the plugin started, or filesystem for the directories below the root")
 )
 
-type errBadRead struct {
-	cause error
-}
-
-func (e errBadRead) Error() string {
-	return fmt.Sprintf("failed to read from gcfsfuse, caused by: %s", e.cause.Error())
-}
-
-type errUnexpectedOutput struct {
-	output string
-}
-
-func (e errUnexpectedOutput) Error() string {
-	return fmt.Sprintf("unexpected output from gcfsfuse: %s", e.output)
-}
-
-
-// driver wraps multiple gcsfuse processes
-type driver struct {
-	*sync.Mutex
-
-	// Maps bucket to the gcfsfuse command that owns the bucket.
-	cmds map[string]exec.Cmd
-}
-
-var root = os.Args[len(os.Args)-1]
+var removeStaleSocket = flag.Bool("remove-stale-socket", true, "remove the socket if it was left behind by an instance that is no longer running")
 
 func init() {
-	if _, err := exec.LookPath("gcsfuse"); err != nil {
-		log.Fatal("Could not find gcsfuse.")
-	}
 	log.SetFlags(log.Lmicroseconds)
 }
 
 func main() {
-	d := driver{
-		Mutex: new(sync.Mutex),
-		cmds:  make(map[string]exec.Cmd),
-	}
-
-	h := volume.NewHandler(d)
-	log.Printf("Listening on %s with mount target %s\n", socketAddress, root)
-	log.Println(h.ServeUnix(socketAddress, 0))
-}
-
-func (d driver) Mount(r *volume.MountRequest) (*volume.MountResponse, error) {
-	d.Lock()
-	defer d.Unlock()
-
-	b := d.bucket(r.Name)
-
-	daemon, ok := d.cmds[b]
-
-	if ok {
-		if daemon.ProcessState != nil && daemon.ProcessState.Exited() {
-			return nil, errZombie
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
 		}
-		return &volume.MountResponse{Mountpoint: d.mountpoint(r.Name)}, nil
-	}
-
-	mnt := d.mountpoint(b)
-
-	if err := os.MkdirAll(mnt, os.ModeTemporary); err != nil {
-		return nil, err
 	}
 
-	daemon = *exec.Command("gcsfuse", append(os.Args[1:len(os.Args)-1], b, mnt)...)
-	daemon.Stdout = os.Stdout
-	rc, err := daemon.StderrPipe()
-	if err != nil {
-		return nil, errBadRead{err}
-	}
-
-	if err := daemon.Start(); err != nil {
-		return nil, err
+	if _, err := exec.LookPath("gcsfuse"); err != nil {
+		log.Fatal("Could not find gcsfuse.")
 	}
 
-	l, err := bufio.NewReader(io.TeeReader(rc, os.Stderr)).ReadString(byte('\n'))
-	if err != nil {
-		return nil, errBadRead{err}
+	// Arguments that are passed through to gcsfuse, i.e. everything
+	// except the root and the flags of the driver itself.
+	var own, gcsfuseArgs []string
+	if len(os.Args) > 2 {
+		own, gcsfuseArgs = splitArgs(os.Args[1 : len(os.Args)-1])
 	}
+	flag.CommandLine.Parse(own)
 
-	if !strings.HasSuffix(l, "File system has been successfully mounted.\n") {
-		return nil, errUnexpectedOutput{output: l}
+	if err := setupLog(*logOutput); err != nil {
+		log.Fatal(err)
 	}
 
-	d.cmds[b] = daemon
-
-	go io.Copy(os.Stderr, rc)
-
-	return &volume.MountResponse{Mountpoint: d.mountpoint(r.Name)}, nil
-}
-
-func (d driver) Remove(r *volume.RemoveRequest) error {
-	d.Lock()
-	defer d.Unlock()
-
-	b := d.bucket(r.Name)
-
-	daemon, ok := d.cmds[b]
-
-	if !ok {
-		log.Printf("Doing nothing when asked to remove volume for %s ...", r.Name)
-		return nil
+	if *raiseFDLimit {
+		raiseFDs()
 	}
 
-	log.Printf("Interrupting gcsfuse %s", b)
-	daemon.Process.Signal(os.Interrupt)
-	ps, err := daemon.Process.Wait()
+	d, err := gcs.New(gcs.Config{
+		Root:                   root,
+		GcsfuseArgs:            gcsfuseArgs,
+		MountConcurrency:       *mountConcurrency,
+		AsyncMount:             *asyncMount,
+		SecureDefaults:         *secureDefaults,
+		Noatime:                *noatime,
+		User:                   *defaultUser,
+		Group:                  *defaultGroup,
+		IdleTimeout:            *idleTimeout,
+		MaxIdleMounts:          *maxIdleMounts,
+		LookupRegion:           *lookupRegion,
+		Region:                 *hostRegion,
+		CheckOnlyDir:           *checkOnlyDir,
+		Wrapper:                strings.Fields(*wrapper),
+		PostMountHook:          *postMountHook,
+		PostMountHookOptional:  *postMountOpt,
+		HookTimeout:            *hookTimeout,
+		PreUnmountHook:         *preUnmountHook,
+		PreUnmountHookRequired: *preUnmountReq,
+		ReconcileInterval:      *reconcileEvery,
+		ReconcileFix:           *reconcileFix,
+		UnmountTool:            *unmountTool,
+		UsageInterval:          *usageInterval,
+		KeepFailedMounts:       !*unmountOnError,
+		BreakerThreshold:       *breakerThreshold,
+		BreakerCooldown:        *breakerCooldown,
+		Relaunch:               *relaunch,
+		GcsfuseLogLevel:        *gcsfuseLogLevel,
+		ChownMountpoint:        *chownMountpoint,
+		LockWarnThreshold:      *lockWarn,
+		ExportCommand:          *exportCommand,
+		UnexportCommand:        *unexportCommand,
+		ListSource:             *listSource,
+		LazyUnmount:            *lazyUnmount,
+		StrictRoot:             *strictRoot,
+		PrefixMap:              *prefixMap,
+		TeardownSignal:         *teardownSignal,
+		AdminTokenFile:         *adminTokenFile,
+		AdminBasicAuthFile:     *adminBasicAuth,
+		ProtectMetrics:         *protectMetrics,
+		ManageFuseConf:         *manageFuseConf,
+		ShareSubpaths:          *shareSubpaths,
+		TraceEndpoint:          *traceEndpoint,
+		ReapZombies:            *reapZombies || os.Getpid() == 1,
+		QueryTimeout:           *queryTimeout,
+		EventWebhook:           *eventWebhook,
+		Readiness:              *readiness,
+		CgroupRoot:             *cgroupRoot,
+	})
 	if err != nil {
-		log.Printf("Waiting for gcsfuse %s errored, returning error.", b)
-		return err
+		log.Fatal(err)
 	}
-	if !ps.Success() {
-		log.Printf("gcsfuse %s exited dirty, returning error.", b)
-		return errDaemonDirty
-	}
-
-	return nil
-}
-
-func (d driver) Get(r *volume.GetRequest) (*volume.GetResponse, error) {
-	d.Lock()
-	defer d.Unlock()
-
-	return &volume.GetResponse{
-		Volume: &volume.Volume{
-			Name: r.Name,
-			Mountpoint: d.mountpoint(r.Name),
-		},
-	}, nil
-}
-
-func (d driver) List() (*volume.ListResponse, error) {
-	d.Lock()
-	defer d.Unlock()
-
-	var volumes []*volume.Volume
-	files, err := ioutil.ReadDir(root)
 
+	l, err := listen(socketAddress)
 	if err != nil {
-		return nil, err
+		log.Fatal(err)
 	}
+	defer os.Remove(socketAddress)
 
-	for _, entry := range files {
-		if entry.IsDir() {
-			volumes = append(volumes, &volume.Volume{Name: entry.Name(), Mountpoint: d.mountpoint(entry.Name())})
-		}
-	}
-
-	return &volume.ListResponse{Volumes: volumes}, nil
-}
-
-func (d driver) Path(r *volume.PathRequest) (*volume.PathResponse, error) {
-	return &volume.PathResponse{Mountpoint: d.mountpoint(r.Name)}, nil
-}
+	h := volume.NewHandler(d)
+	d.Register(h)
+	log.Printf("Listening on %s with mount target %s\n", socketAddress, root)
 
-func (d driver) Create(r *volume.CreateRequest) error {
-	return nil
+	served := make(chan error, 1)
+	go func() {
+		served <- h.Serve(l)
+	}()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-served:
+		log.Println(err)
+	case sig := <-sigs:
+		// Stop listening first, so that Docker does not get to mount
+		// while buckets are unmounted. The driver refuses mounts on
+		// connections that are still open.
+		log.Printf("Received %s.", sig)
+		l.Close()
+		d.Shutdown()
+	}
 }
 
-func (d driver) Unmount(r *volume.UnmountRequest) error {
-	return nil
-}
+// splitArgs separates the flags of the driver, which are defined using
+// package flag, from arguments that are meant for gcsfuse. The order of
+// arguments in both slices is preserved.
+func splitArgs(args []string) (own, rest []string) {
+	for i := 0; i < len(args); i++ {
+		name := strings.TrimLeft(args[i], "-")
+		if name == args[i] || name == "" {
+			rest = append(rest, args[i])
+			continue
+		}
 
-func (d driver) mountpoint(name string) string {
-	return filepath.Join(root, name)
-}
+		value := false
+		if j := strings.Index(name, "="); j != -1 {
+			name, value = name[:j], true
+		}
 
-func (d driver) bucket(name string) string {
-	i := strings.Index(name, "/")
-	if i == -1 {
-		return name
-	}
-	return name[0:i]
-}
+		f := flag.Lookup(name)
+		if f == nil {
+			rest = append(rest, args[i])
+			continue
+		}
 
-func (d driver) Capabilities() *volume.CapabilitiesResponse {
-	return &volume.CapabilitiesResponse{
-		Capabilities: volume.Capability{Scope: "global"},
+		own = append(own, args[i])
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !value && !(ok && b.IsBoolFlag()) && i+1 < len(args) {
+			i++
+			own = append(own, args[i])
+		}
 	}
+	return own, rest
 }
@@ -0,0 +1,67 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+var (
+	errSocketInUse = errors.New("socket is in use, another instance of the plugin is running")
+	errStaleSocket = errors.New("socket exists but nobody is listening on it, remove it or pass -remove-stale-socket")
+)
+
+// listen binds the socket Docker connects to. An existing socket is only
+// replaced if no other instance of the plugin answers on it.
+func listen(addr string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(addr), 0755); err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("unix", addr)
+	if errors.Is(err, syscall.EADDRINUSE) {
+		if c, err := net.Dial("unix", addr); err == nil {
+			c.Close()
+			return nil, errSocketInUse
+		}
+
+		if !*removeStaleSocket {
+			return nil, errStaleSocket
+		}
+
+		log.Printf("Removing stale socket %s", addr)
+		if err := os.Remove(addr); err != nil {
+			return nil, err
+		}
+		l, err = net.Listen("unix", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Same permissions as the socket created by the plugin helpers.
+	if err := os.Chmod(addr, 0660); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,99 @@
+// Copyright  Lorenz Leutgeb <lorenz@leutgeb.xyz>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListen(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		before func(t *testing.T, addr string)
+		remove bool
+		err    error
+	}{
+		{"new", nil, false, nil},
+		{"in use", inUse, false, errSocketInUse},
+		{"in use, removing stale sockets", inUse, true, errSocketInUse},
+		{"stale", stale, false, errStaleSocket},
+		{"stale, removing stale sockets", stale, true, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func(v bool) { *removeStaleSocket = v }(*removeStaleSocket)
+			*removeStaleSocket = tc.remove
+
+			// The directory is created unless it exists.
+			dir := filepath.Join(t.TempDir(), "run")
+			addr := filepath.Join(dir, "gcs.sock")
+			if tc.before != nil {
+				if err := os.Mkdir(dir, 0755); err != nil {
+					t.Fatal(err)
+				}
+				tc.before(t, addr)
+			}
+
+			l, err := listen(addr)
+			if err != tc.err {
+				t.Fatalf("got %v, want %v", err, tc.err)
+			}
+			if err != nil {
+				return
+			}
+			defer l.Close()
+			fi, err := os.Stat(addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != 0660 {
+				t.Errorf("socket has mode %s, want 0660", fi.Mode().Perm())
+			}
+		})
+	}
+}
+
+// inUse listens on addr like another instance of the plugin.
+func inUse(t *testing.T, addr string) {
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+}
+
+// stale leaves a socket at addr that nobody listens on, like an instance
+// of the plugin that crashed.
+func stale(t *testing.T, addr string) {
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+}